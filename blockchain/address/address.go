@@ -0,0 +1,92 @@
+// Package address derives and checks blockchain addresses, Bitcoin style
+// base58check encodings of the RIPEMD-160 of the SHA-256 of a public key.
+package address
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"errors"
+
+	"github.com/btcsuite/btcutil/base58"
+	"golang.org/x/crypto/ripemd160"
+)
+
+// Version is the version byte in front of every single key address (0x00
+// for Main Network), which makes them start with a 1.
+const Version byte = 0x00
+
+// hashLength is the length of the RIPEMD-160 public key hash.
+const hashLength = ripemd160.Size
+
+var (
+	ErrInvalidEncoding = errors.New("invalid address: not base58")
+	ErrInvalidLength   = errors.New("invalid address: wrong length")
+	ErrInvalidVersion  = errors.New("invalid address: unknown version")
+	ErrInvalidChecksum = errors.New("invalid address: checksum mismatch")
+	ErrKeyMismatch     = errors.New("address does not belong to the public key")
+)
+
+// FromPublicKey derives the address of publicKey: the version byte and the
+// public key hash, followed by the first 4 bytes of their double SHA-256 as
+// a checksum, in base58.
+func FromPublicKey(publicKey *ecdsa.PublicKey) string {
+	return base58.CheckEncode(PublicKeyHash(publicKey), Version)
+}
+
+// PublicKeyHash is the RIPEMD-160 of the SHA-256 of both 32 byte public key
+// coordinates.
+func PublicKeyHash(publicKey *ecdsa.PublicKey) []byte {
+	xy := make([]byte, 64)
+	publicKey.X.FillBytes(xy[:32])
+	publicKey.Y.FillBytes(xy[32:])
+	digest := sha256.Sum256(xy)
+
+	h := ripemd160.New()
+	h.Write(digest[:])
+	return h.Sum(nil)
+}
+
+// Decode checks s and returns the public key or policy hash it encodes.
+func Decode(s string) ([]byte, error) {
+	hash, _, err := decode(s)
+	return hash, err
+}
+
+func decode(s string) ([]byte, byte, error) {
+	decoded := base58.Decode(s)
+	if len(decoded) == 0 {
+		return nil, 0, ErrInvalidEncoding
+	}
+	if len(decoded) != 1+hashLength+4 {
+		return nil, 0, ErrInvalidLength
+	}
+	hash, version, err := base58.CheckDecode(s)
+	if err != nil {
+		return nil, 0, ErrInvalidChecksum
+	}
+	if version != Version && version != MultisigVersion {
+		return nil, 0, ErrInvalidVersion
+	}
+	return hash, version, nil
+}
+
+// Validate returns why s is not a valid address, nil if it is.
+func Validate(s string) error {
+	_, err := Decode(s)
+	return err
+}
+
+// VerifyPublicKey checks that s is the address of publicKey.
+func VerifyPublicKey(s string, publicKey *ecdsa.PublicKey) error {
+	_, version, err := decode(s)
+	if err != nil {
+		return err
+	}
+	if version != Version {
+		return ErrKeyMismatch
+	}
+	if publicKey == nil || publicKey.X == nil || publicKey.Y == nil || s != FromPublicKey(publicKey) {
+		return ErrKeyMismatch
+	}
+	return nil
+}
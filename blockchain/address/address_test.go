@@ -0,0 +1,155 @@
+package address
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+// generator is the public key of private key 1 on P-256, the curve's base
+// point.
+func generator() *ecdsa.PublicKey {
+	curve := elliptic.P256()
+	return &ecdsa.PublicKey{Curve: curve, X: curve.Params().Gx, Y: curve.Params().Gy}
+}
+
+func newKey(t *testing.T) *ecdsa.PublicKey {
+	t.Helper()
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &k.PublicKey
+}
+
+func keyString(k *ecdsa.PublicKey) string {
+	return fmt.Sprintf("%064x%064x", k.X, k.Y)
+}
+
+func TestFromPublicKey(t *testing.T) {
+	k := generator()
+	if got, want := hex.EncodeToString(PublicKeyHash(k)), "b134d1f44dca906dcd7c96e4455d7eaa9efa6987"; got != want {
+		t.Fatalf("public key hash %s, want %s", got, want)
+	}
+	if got, want := FromPublicKey(k), "1H9ysxkbjve5xCgsooBQLxWbPjD77AHuCC"; got != want {
+		t.Fatalf("address %s, want %s", got, want)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	// The base58check example of the Bitcoin wiki.
+	hash, err := Decode("16UwLL9Risc3QfPqBUvKofHmBQ7wMtjvM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := hex.DecodeString("010966776006953d5567439e5e39f86a0d273bee"); !bytes.Equal(hash, want) {
+		t.Fatalf("decoded hash %x, want %x", hash, want)
+	}
+
+	for _, tt := range []struct {
+		in  string
+		err error
+	}{
+		{"16UwLL9Risc3QfPqBUvKofHmBQ7wMtjvM", nil},
+		{"31nVrspaydBz8aMpxH9WkS2DuhgqS1fCuG", nil},
+		{"16UwLL9Risc3QfPqBUvKofHmBQ7wMtjvN", ErrInvalidChecksum},
+		{"mfcSEPR8EkJrpX91YkTJ9iscdAzppJrG9j", ErrInvalidVersion},
+		{"12F2tWG3tB78Lrw7PK7C4KeL5Aio3WM4", ErrInvalidLength},
+		{"1RCJpKWC7pMfdSTmfGEMzwzyzu3Crjud4v", ErrInvalidLength},
+		{"16UwLL9Risc3QfPqBUvKofHmBQ7wMtjv0", ErrInvalidEncoding},
+		{"", ErrInvalidEncoding},
+	} {
+		if err := Validate(tt.in); !errors.Is(err, tt.err) {
+			t.Errorf("Validate(%q) = %v, want %v", tt.in, err, tt.err)
+		}
+	}
+}
+
+func TestVerifyPublicKey(t *testing.T) {
+	k := generator()
+	a := FromPublicKey(k)
+	if err := VerifyPublicKey(a, k); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyPublicKey(a, newKey(t)); !errors.Is(err, ErrKeyMismatch) {
+		t.Fatalf("address verified against another key: %v", err)
+	}
+	if err := VerifyPublicKey(a, nil); !errors.Is(err, ErrKeyMismatch) {
+		t.Fatalf("address verified against no key: %v", err)
+	}
+	if err := VerifyPublicKey(a, &ecdsa.PublicKey{Curve: elliptic.P256(), X: big.NewInt(1)}); !errors.Is(err, ErrKeyMismatch) {
+		t.Fatalf("address verified against a partial key: %v", err)
+	}
+	if err := VerifyPublicKey(a[:len(a)-1]+"D", k); !errors.Is(err, ErrInvalidChecksum) {
+		t.Fatalf("address with a typo: %v", err)
+	}
+
+	ms, err := NewMultisig(1, keyString(k))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyPublicKey(ms.Address(), k); !errors.Is(err, ErrKeyMismatch) {
+		t.Fatalf("multisig address verified as a single key address: %v", err)
+	}
+}
+
+func TestMultisig(t *testing.T) {
+	a, b, c := keyString(newKey(t)), keyString(newKey(t)), keyString(newKey(t))
+	ms, err := NewMultisig(2, a, strings.ToUpper(b), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ms.Address()
+	if !strings.HasPrefix(addr, "3") || !IsMultisig(addr) || IsMultisig(FromPublicKey(generator())) {
+		t.Fatalf("multisig address %s", addr)
+	}
+	if _, version, err := decode(addr); err != nil || version != MultisigVersion {
+		t.Fatalf("multisig address version %#x, %v", version, err)
+	}
+	if err := VerifyPolicy(addr, ms); err != nil {
+		t.Fatal(err)
+	}
+	if !ms.Has(strings.ToUpper(a)) || ms.Has(keyString(generator())) {
+		t.Fatal("Has does not match the policy keys")
+	}
+
+	// The same keys in any order make the same address, another M or
+	// other keys another one.
+	reordered, _ := NewMultisig(2, c, b, a)
+	other, _ := NewMultisig(1, a, b, c)
+	if reordered.Address() != addr || other.Address() == addr {
+		t.Fatal("multisig address does not depend on exactly M and the keys")
+	}
+	if err := VerifyPolicy(addr, other); !errors.Is(err, ErrKeyMismatch) {
+		t.Fatalf("address verified against another policy: %v", err)
+	}
+	if err := VerifyPolicy(FromPublicKey(generator()), ms); !errors.Is(err, ErrInvalidVersion) {
+		t.Fatalf("single key address verified as a policy: %v", err)
+	}
+
+	for _, tt := range []struct {
+		m    int
+		keys []string
+	}{
+		{0, []string{a, b}},
+		{3, []string{a, b}},
+		{1, []string{a, a}},
+		{1, []string{a, "xyz"}},
+		{1, nil},
+	} {
+		if _, err := NewMultisig(tt.m, tt.keys...); !errors.Is(err, ErrInvalidPolicy) {
+			t.Errorf("NewMultisig(%d, %d keys) = %v, want ErrInvalidPolicy", tt.m, len(tt.keys), err)
+		}
+	}
+	unsorted := &Multisig{M: 1, PublicKeys: []string{ms.PublicKeys[1], ms.PublicKeys[0]}}
+	if err := unsorted.Validate(); !errors.Is(err, ErrInvalidPolicy) {
+		t.Fatalf("unsorted keys: %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package address
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/btcsuite/btcutil/base58"
+	"golang.org/x/crypto/ripemd160"
+
+	"github.com/EmilioCliff/learn-go/blockchain/utils"
+)
+
+// MultisigVersion is the version byte of multisig addresses, which makes
+// them start with a 3 like Bitcoin's pay to script hash addresses.
+const MultisigVersion byte = 0x05
+
+// MaxMultisigKeys is the largest N of an M-of-N policy.
+const MaxMultisigKeys = 15
+
+var ErrInvalidPolicy = errors.New("invalid multisig policy")
+
+// Multisig is an M-of-N policy: spending from its address takes signatures
+// by M of its N public keys. The keys are kept sorted, so the same keys in
+// any order make the same address.
+type Multisig struct {
+	M          int      `json:"m"`
+	PublicKeys []string `json:"public_keys"`
+}
+
+// NewMultisig returns the M-of-N policy over publicKeys, written like
+// wallet.PublicKeyStr.
+func NewMultisig(m int, publicKeys ...string) (*Multisig, error) {
+	ms := &Multisig{M: m, PublicKeys: make([]string, len(publicKeys))}
+	for i, k := range publicKeys {
+		ms.PublicKeys[i] = strings.ToLower(k)
+	}
+	slices.Sort(ms.PublicKeys)
+	return ms, ms.Validate()
+}
+
+// Validate checks that 1 <= M <= N <= MaxMultisigKeys and that the keys
+// are distinct, well formed and sorted.
+func (ms *Multisig) Validate() error {
+	n := len(ms.PublicKeys)
+	if ms.M < 1 || ms.M > n || n > MaxMultisigKeys {
+		return fmt.Errorf("%w: %d of %d keys", ErrInvalidPolicy, ms.M, n)
+	}
+	for i, k := range ms.PublicKeys {
+		if !utils.IsBigIntTupleString(k) || k != strings.ToLower(k) {
+			return fmt.Errorf("%w: malformed public key %q", ErrInvalidPolicy, k)
+		}
+		if i > 0 && ms.PublicKeys[i-1] >= k {
+			return fmt.Errorf("%w: public keys not sorted or repeated", ErrInvalidPolicy)
+		}
+	}
+	return nil
+}
+
+// Has reports whether publicKey is one of the keys of the policy.
+func (ms *Multisig) Has(publicKey string) bool {
+	_, found := slices.BinarySearch(ms.PublicKeys, strings.ToLower(publicKey))
+	return found
+}
+
+// Address is the base58check encoding, with MultisigVersion, of the
+// RIPEMD-160 of the SHA-256 of M, N and the 64 byte keys.
+func (ms *Multisig) Address() string {
+	b := []byte{byte(ms.M), byte(len(ms.PublicKeys))}
+	for _, k := range ms.PublicKeys {
+		x, y := utils.String2BigIntTuple(k)
+		b = append(b, x.FillBytes(make([]byte, 32))...)
+		b = append(b, y.FillBytes(make([]byte, 32))...)
+	}
+	digest := sha256.Sum256(b)
+	h := ripemd160.New()
+	h.Write(digest[:])
+	return base58.CheckEncode(h.Sum(nil), MultisigVersion)
+}
+
+// IsMultisig reports whether s is a valid multisig address.
+func IsMultisig(s string) bool {
+	_, version, err := decode(s)
+	return err == nil && version == MultisigVersion
+}
+
+// VerifyPolicy checks that s is the address of policy ms.
+func VerifyPolicy(s string, ms *Multisig) error {
+	if !IsMultisig(s) {
+		return ErrInvalidVersion
+	}
+	if ms == nil || ms.Validate() != nil || s != ms.Address() {
+		return ErrKeyMismatch
+	}
+	return nil
+}
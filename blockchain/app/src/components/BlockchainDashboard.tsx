@@ -24,6 +24,9 @@ import { getChain } from '@/services/getChain';
 import { DEFAULT_BASE_URL } from '@/lib/constants';
 import { stripProtocol } from '@/lib/utils';
 import { getWalletAmount } from '@/services/getWallentAmount';
+import { listWallets } from '@/services/listWallets';
+import { subscribeEvents } from '@/services/subscribeEvents';
+import type { Transaction } from '@/lib/types';
 
 export function BlockchainDashboard() {
 	const [selectedNode, setSelectedNode] = useState<string>(DEFAULT_BASE_URL);
@@ -47,12 +50,58 @@ export function BlockchainDashboard() {
 		// refetchInterval: 5000,
 	});
 
+	const { data: walletList } = useQuery({
+		queryKey: ['wallets', selectedNode],
+		queryFn: () => listWallets(selectedNode),
+	});
+	const wallets = Object.fromEntries(
+		(walletList || []).map((w) => [w.blockchain_address, w]),
+	);
+
 	useEffect(() => {
 		if (chainData && chainData.blockchain_address) {
 			setSelectedWalletKey(chainData.blockchain_address);
 		}
 	}, [chainData]);
 
+	// The node pushes new blocks, transactions, reorgs and mining state
+	// changes, refresh whatever they touch instead of polling.
+	useEffect(
+		() =>
+			subscribeEvents(
+				() => {
+					queryClient.invalidateQueries({ queryKey: ['chain'] });
+					queryClient.invalidateQueries({
+						queryKey: ['walletAmount'],
+					});
+				},
+				{},
+				selectedNode,
+			),
+		[queryClient, selectedNode],
+	);
+
+	useEffect(() => {
+		if (!selectedWalletKey) return;
+		return subscribeEvents(
+			(event) => {
+				const { transaction } = event.data as {
+					transaction: Transaction;
+				};
+				if (
+					transaction.recipient_blockchain_address !==
+					selectedWalletKey
+				)
+					return;
+				toast('Incoming payment', {
+					description: `${transaction.value} from ${transaction.sender_blockchain_address}`,
+				});
+			},
+			{ types: ['transaction'], addresses: [selectedWalletKey] },
+			selectedNode,
+		);
+	}, [selectedNode, selectedWalletKey]);
+
 	const handleNodeChange = (node: string) => {
 		if (node) {
 			setSelectedNode(node);
@@ -120,7 +169,7 @@ export function BlockchainDashboard() {
 					{/* Wallet Section */}
 					<div className="lg:col-span-1">
 						<WalletSection
-							wallet={chainData?.wallets || {}}
+							wallet={wallets}
 							miners_address={chainData?.blockchain_address || ''}
 							selectedNode={selectedNode}
 							selectedWalletKey={selectedWalletKey}
@@ -133,7 +182,7 @@ export function BlockchainDashboard() {
 					{/* Send Crypto */}
 					<div className="lg:col-span-1">
 						<SendCryptoForm
-							wallet={chainData?.wallets || {}}
+							wallet={wallets}
 							walletAmount={walletAmountData?.amount || 0}
 							selectedWalletKey={selectedWalletKey}
 							refetchAmount={refetchAmount}
@@ -146,10 +195,7 @@ export function BlockchainDashboard() {
 					<div className="lg:col-span-1">
 						<MiningSection
 							minerWallet={
-								chainData?.wallets[
-									chainData.blockchain_address
-								] || {
-									private_key: '',
+								wallets[chainData?.blockchain_address || ''] || {
 									public_key: '',
 									blockchain_address: '',
 								}
@@ -158,7 +204,6 @@ export function BlockchainDashboard() {
 							mining_difficulty={
 								chainData?.mining_difficulty || 0
 							}
-							refetchChain={refetchChain}
 							mining_reward={chainData?.mining_reward || 0}
 							selectedNode={selectedNode}
 						/>
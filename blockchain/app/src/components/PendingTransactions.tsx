@@ -41,7 +41,7 @@ export function PendingTransactions({
 	};
 
 	const getTotalValue = () => {
-		return transactions.reduce((sum, tx) => sum + tx.value, 0);
+		return transactions.reduce((sum, tx) => sum + Number(tx.value), 0);
 	};
 
 	return (
@@ -116,6 +116,14 @@ export function PendingTransactions({
 											>
 												{tx.value} BTC
 											</Badge>
+											{tx.fee && Number(tx.fee) > 0 && (
+												<Badge
+													variant="outline"
+													className="text-xs"
+												>
+													fee {tx.fee}
+												</Badge>
+											)}
 										</div>
 
 										{/* Transaction Flow */}
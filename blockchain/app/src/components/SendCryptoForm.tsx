@@ -29,6 +29,8 @@ export function SendCryptoForm({
 }: SendCryptoFormProps) {
 	const [recipient, setRecipient] = useState('');
 	const [amount, setAmount] = useState('');
+	const [fee, setFee] = useState('0.0001');
+	const [passphrase, setPassphrase] = useState('');
 	const selectedWallet = wallet[selectedWalletKey];
 
 	const [error, setError] = useState('');
@@ -46,7 +48,10 @@ export function SendCryptoForm({
 		if (!amount || parseFloat(amount) <= 0) {
 			return 'Amount must be greater than 0';
 		}
-		if (parseFloat(amount) > walletAmount) {
+		if (!fee || parseFloat(fee) < 0) {
+			return 'Fee cannot be negative';
+		}
+		if (parseFloat(amount) + parseFloat(fee) > walletAmount) {
 			return 'Insufficient balance';
 		}
 		return null;
@@ -68,6 +73,7 @@ export function SendCryptoForm({
 			);
 			setRecipient('');
 			setAmount('');
+			setPassphrase('');
 
 			toast.success('Transaction sent', {
 				description: `${amount} BTC sent successfully`,
@@ -90,13 +96,11 @@ export function SendCryptoForm({
 		}
 
 		mutation.mutate({
-			transaction: {
-				sender_private_key: selectedWallet.private_key,
-				sender_public_key: selectedWallet.public_key,
-				sender_blockchain_address: selectedWallet.blockchain_address,
-				recipient_blockchain_address: recipient.trim(),
-				value: parseFloat(amount),
-			},
+			wallet: selectedWallet,
+			passphrase,
+			recipient: recipient.trim(),
+			value: amount,
+			fee,
 			baseUrl: selectedNode,
 		});
 	};
@@ -141,6 +145,34 @@ export function SendCryptoForm({
 						</div>
 					</div>
 
+					{/* Fee */}
+					<div className="space-y-2">
+						<Label htmlFor="fee">Network Fee (BTC)</Label>
+						<Input
+							id="fee"
+							type="number"
+							step="0.0001"
+							min="0"
+							value={fee}
+							onChange={(e) => setFee(e.target.value)}
+						/>
+						<div className="text-xs text-muted-foreground">
+							Paid to the miner, higher fees are mined first
+						</div>
+					</div>
+
+					{/* Passphrase */}
+					<div className="space-y-2">
+						<Label htmlFor="passphrase">Wallet Passphrase</Label>
+						<Input
+							id="passphrase"
+							type="password"
+							placeholder="Unlocks the key in your browser"
+							value={passphrase}
+							onChange={(e) => setPassphrase(e.target.value)}
+						/>
+					</div>
+
 					{/* Error/Success Messages */}
 					{error && (
 						<Alert variant="destructive">
@@ -181,13 +213,15 @@ export function SendCryptoForm({
 					<div className="text-xs text-muted-foreground bg-muted p-3 rounded">
 						<div className="flex justify-between">
 							<span>Network Fee:</span>
-							<span>0.0001 BTC</span>
+							<span>{(parseFloat(fee) || 0).toFixed(6)} BTC</span>
 						</div>
 						<div className="flex justify-between">
 							<span>Total:</span>
 							<span>
 								{amount
-									? (parseFloat(amount) + 0.0001).toFixed(6)
+									? (
+											parseFloat(amount) + (parseFloat(fee) || 0)
+										).toFixed(6)
 									: '0.000000'}{' '}
 								BTC
 							</span>
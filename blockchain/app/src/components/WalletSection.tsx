@@ -21,6 +21,7 @@ import { toast } from 'sonner';
 import { useMutation, useQueryClient } from '@tanstack/react-query';
 import type { Wallet } from '@/lib/types';
 import { createWallet } from '@/services/createWallet';
+import { unlockWallet } from '@/lib/keystore';
 
 interface WalletSectionProps {
 	wallet: Record<string, Wallet>;
@@ -41,7 +42,8 @@ export function WalletSection({
 	setSelectedWalletKey,
 	refetchAmount,
 }: WalletSectionProps) {
-	const [showPrivateKey, setShowPrivateKey] = useState(false);
+	const [privateKey, setPrivateKey] = useState('');
+	const showPrivateKey = privateKey !== '';
 	const selectedWallet = wallet[selectedWalletKey];
 
 	const queryClient = useQueryClient();
@@ -54,7 +56,7 @@ export function WalletSection({
 		mutationFn: createWallet,
 		onSuccess: async (data) => {
 			await queryClient.invalidateQueries({
-				queryKey: ['chain', 'walletAmount'],
+				queryKey: ['wallets', selectedNode],
 			});
 			toast.success('Wallet created successfully');
 			wallet[data.blockchain_address] = data;
@@ -68,7 +70,34 @@ export function WalletSection({
 
 	const onWalletChange = (value: string) => {
 		setSelectedWalletKey(value);
-		setShowPrivateKey(false);
+		setPrivateKey('');
+	};
+
+	const onCreateWallet = () => {
+		const passphrase = window.prompt('Passphrase for the new wallet');
+		if (!passphrase) return;
+		mutation.mutate({ passphrase, baseUrl: selectedNode });
+	};
+
+	// The key file is decrypted here, the node never sends the private key.
+	const togglePrivateKey = async () => {
+		if (showPrivateKey) {
+			setPrivateKey('');
+			return;
+		}
+		const passphrase = window.prompt('Wallet passphrase');
+		if (passphrase === null) return;
+		try {
+			setPrivateKey(
+				await unlockWallet(
+					selectedNode,
+					selectedWallet.blockchain_address,
+					passphrase,
+				),
+			);
+		} catch (error: any) {
+			toast.error(error.message);
+		}
 	};
 
 	const copyToClipboard = (text: string, label: string) => {
@@ -85,7 +114,7 @@ export function WalletSection({
 					<WalletIcon className="h-5 w-5" />
 					Wallet
 					<Button
-						onClick={() => mutation.mutate(selectedNode)}
+						onClick={onCreateWallet}
 						className="ml-auto"
 						size="sm"
 						variant="outline"
@@ -174,15 +203,13 @@ export function WalletSection({
 						<div className="flex items-center gap-2 mt-1">
 							<div className="crypto-address flex-1">
 								{showPrivateKey
-									? selectedWallet?.private_key
+									? privateKey
 									: '••••••••••••••••••••••••••••••••••••••••••••••••••••'}
 							</div>
 							<Button
 								variant="ghost"
 								size="sm"
-								onClick={() =>
-									setShowPrivateKey(!showPrivateKey)
-								}
+								onClick={togglePrivateKey}
 							>
 								{showPrivateKey ? (
 									<EyeOff className="h-4 w-4" />
@@ -196,7 +223,7 @@ export function WalletSection({
 									size="sm"
 									onClick={() =>
 										copyToClipboard(
-											selectedWallet?.private_key,
+											privateKey,
 											'Private key',
 										)
 									}
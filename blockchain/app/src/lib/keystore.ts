@@ -0,0 +1,114 @@
+import type { KeyFile } from '@/lib/types';
+import { bytesToHex, hexToBytes } from '@/lib/signing';
+
+// Key files are encrypted with AES-256-GCM under a PBKDF2-SHA256 key derived
+// from the passphrase, with the address as additional data, exactly as the
+// Go keystore writes them. The node only hands a key file out for the
+// HMAC-SHA256 of "keystore download" under that same key, see
+// wallet.DownloadProof, so the passphrase never leaves the browser.
+
+type KeyDerivation = Pick<KeyFile['crypto'], 'kdf' | 'iterations' | 'salt'>;
+
+async function getJSON<T>(url: string, init?: RequestInit): Promise<T> {
+	const res = await fetch(url, init);
+	if (!res.ok) {
+		const errorData = await res.json().catch(() => ({}));
+		throw new Error(errorData.error || 'Failed to get key file');
+	}
+	return await res.json();
+}
+
+// deriveKeyBytes runs the key derivation of a key file on passphrase.
+async function deriveKeyBytes(
+	kdf: KeyDerivation,
+	passphrase: string,
+): Promise<ArrayBuffer> {
+	if (kdf.kdf !== 'pbkdf2-sha256') {
+		throw new Error('Unsupported key file');
+	}
+	const material = await crypto.subtle.importKey(
+		'raw',
+		new TextEncoder().encode(passphrase),
+		'PBKDF2',
+		false,
+		['deriveBits'],
+	);
+	return crypto.subtle.deriveBits(
+		{
+			name: 'PBKDF2',
+			hash: 'SHA-256',
+			salt: hexToBytes(kdf.salt),
+			iterations: kdf.iterations,
+		},
+		material,
+		256,
+	);
+}
+
+// downloadProof is the proof of the passphrase a node asks for a key file.
+async function downloadProof(keyBytes: ArrayBuffer): Promise<string> {
+	const key = await crypto.subtle.importKey(
+		'raw',
+		keyBytes,
+		{ name: 'HMAC', hash: 'SHA-256' },
+		false,
+		['sign'],
+	);
+	const mac = await crypto.subtle.sign(
+		'HMAC',
+		key,
+		new TextEncoder().encode('keystore download'),
+	);
+	return bytesToHex(new Uint8Array(mac));
+}
+
+// decryptKeyFile returns the private key of keyFile in hex, keyBytes being
+// the key derived from its passphrase.
+async function decryptKeyFile(
+	keyFile: KeyFile,
+	keyBytes: ArrayBuffer,
+): Promise<string> {
+	const { crypto: c } = keyFile;
+	if (c.cipher !== 'aes-256-gcm') {
+		throw new Error('Unsupported key file');
+	}
+	const key = await crypto.subtle.importKey(
+		'raw',
+		keyBytes,
+		'AES-GCM',
+		false,
+		['decrypt'],
+	);
+	try {
+		const plain = await crypto.subtle.decrypt(
+			{
+				name: 'AES-GCM',
+				iv: hexToBytes(c.nonce),
+				additionalData: new TextEncoder().encode(
+					keyFile.blockchain_address,
+				),
+			},
+			key,
+			hexToBytes(c.ciphertext),
+		);
+		return bytesToHex(new Uint8Array(plain));
+	} catch {
+		throw new Error('Wrong passphrase');
+	}
+}
+
+// unlockWallet downloads the key file of address with the proof of
+// passphrase and returns its private key in hex.
+export async function unlockWallet(
+	baseUrl: string,
+	address: string,
+	passphrase: string,
+): Promise<string> {
+	const url = `${baseUrl}/wallet/${address}/keystore`;
+	const kdf = await getJSON<KeyDerivation>(`${url}/kdf`);
+	const keyBytes = await deriveKeyBytes(kdf, passphrase);
+	const keyFile = await getJSON<KeyFile>(url, {
+		headers: { 'X-Keystore-Proof': await downloadProof(keyBytes) },
+	});
+	return decryptKeyFile(keyFile, keyBytes);
+}
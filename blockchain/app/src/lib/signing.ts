@@ -0,0 +1,145 @@
+import type { Wallet } from '@/lib/types';
+
+// Transactions are signed in the browser, the node only receives the public
+// key and the signature. What is signed is the canonical encoding of the Go
+// canonical package, rebuilt here byte for byte.
+
+export interface SignedTransaction {
+	chain_id: string;
+	sender_blockchain_address: string;
+	recipient_blockchain_address: string;
+	sender_public_key: string;
+	value: string;
+	fee: string;
+	nonce: number;
+	timestamp: number;
+	unlock_height?: number;
+	unlock_time?: number;
+	signature: string;
+}
+
+const AMOUNT_DECIMALS = 8;
+const CANONICAL_VERSION = 3;
+
+// normalizeAmount formats a decimal string the way utils.Amount does:
+// no leading zeros, no trailing fractional zeros.
+export function normalizeAmount(value: string): string {
+	const match = value.trim().match(/^(\d+)(?:\.(\d*))?$/);
+	if (!match) {
+		throw new Error('Invalid amount');
+	}
+	const [, int, frac = ''] = match;
+	if (frac.length > AMOUNT_DECIMALS) {
+		throw new Error(`Amount has more than ${AMOUNT_DECIMALS} decimal places`);
+	}
+	const i = int.replace(/^0+(?=\d)/, '');
+	const f = frac.replace(/0+$/, '');
+	return f ? `${i}.${f}` : i;
+}
+
+function amountToUnits(value: string): bigint {
+	const [int, frac = ''] = normalizeAmount(value).split('.');
+	return (
+		BigInt(int) * 10n ** BigInt(AMOUNT_DECIMALS) +
+		BigInt(frac.padEnd(AMOUNT_DECIMALS, '0'))
+	);
+}
+
+// encodeTransaction lays out the signed fields like canonical.Encode: a
+// version byte, the length prefixed chain ID, sender and recipient, then
+// value and fee in base units, nonce, timestamp, unlock height and unlock
+// time, all big endian.
+export function encodeTransaction(
+	tx: Omit<SignedTransaction, 'sender_public_key' | 'signature'>,
+): Uint8Array {
+	const strings = [
+		tx.chain_id,
+		tx.sender_blockchain_address,
+		tx.recipient_blockchain_address,
+	].map((s) => new TextEncoder().encode(s));
+	const size = 1 + strings.reduce((n, s) => n + 4 + s.length, 0) + 6 * 8;
+	const bytes = new Uint8Array(size);
+	const view = new DataView(bytes.buffer);
+	let offset = 0;
+	view.setUint8(offset++, CANONICAL_VERSION);
+	for (const s of strings) {
+		view.setUint32(offset, s.length);
+		bytes.set(s, offset + 4);
+		offset += 4 + s.length;
+	}
+	view.setBigUint64(offset, amountToUnits(tx.value));
+	view.setBigUint64(offset + 8, amountToUnits(tx.fee));
+	view.setBigUint64(offset + 16, BigInt(tx.nonce));
+	view.setBigInt64(offset + 24, BigInt(tx.timestamp));
+	view.setBigUint64(offset + 32, BigInt(tx.unlock_height ?? 0));
+	view.setBigInt64(offset + 40, BigInt(tx.unlock_time ?? 0));
+	return bytes;
+}
+
+export function hexToBytes(hex: string): Uint8Array {
+	const bytes = new Uint8Array(hex.length / 2);
+	for (let i = 0; i < bytes.length; i++) {
+		bytes[i] = parseInt(hex.slice(2 * i, 2 * i + 2), 16);
+	}
+	return bytes;
+}
+
+export function bytesToHex(bytes: Uint8Array): string {
+	return Array.from(bytes, (b) => b.toString(16).padStart(2, '0')).join('');
+}
+
+function base64Url(hex: string): string {
+	const binary = String.fromCharCode(...hexToBytes(hex));
+	return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+}
+
+async function importPrivateKey(
+	wallet: Wallet,
+	privateKey: string,
+): Promise<CryptoKey> {
+	return crypto.subtle.importKey(
+		'jwk',
+		{
+			kty: 'EC',
+			crv: 'P-256',
+			d: base64Url(privateKey.padStart(64, '0')),
+			x: base64Url(wallet.public_key.slice(0, 64)),
+			y: base64Url(wallet.public_key.slice(64)),
+			ext: false,
+		},
+		{ name: 'ECDSA', namedCurve: 'P-256' },
+		false,
+		['sign'],
+	);
+}
+
+export async function signTransaction(
+	wallet: Wallet,
+	privateKey: string,
+	chainId: string,
+	recipient: string,
+	value: string,
+	nonce: number,
+	fee = '0',
+): Promise<SignedTransaction> {
+	const payload = {
+		chain_id: chainId,
+		sender_blockchain_address: wallet.blockchain_address,
+		recipient_blockchain_address: recipient,
+		value: normalizeAmount(value),
+		fee: normalizeAmount(fee),
+		nonce,
+		timestamp: Math.floor(Date.now() / 1000),
+	};
+	const key = await importPrivateKey(wallet, privateKey);
+	const signature = await crypto.subtle.sign(
+		{ name: 'ECDSA', hash: 'SHA-256' },
+		key,
+		encodeTransaction(payload),
+	);
+	return {
+		...payload,
+		sender_public_key: wallet.public_key,
+		signature: bytesToHex(new Uint8Array(signature)),
+	};
+}
@@ -3,28 +3,54 @@ export interface Commonresponse {
 	error: string;
 }
 
+// The node never sends private keys, they stay encrypted in its keystore
+// and are only decrypted in the browser (see lib/keystore.ts).
 export interface Wallet {
-	private_key: string;
 	public_key: string;
 	blockchain_address: string;
 	amount?: number;
 }
 
+export interface KeyFile {
+	version: number;
+	blockchain_address: string;
+	public_key: string;
+	crypto: {
+		kdf: string;
+		iterations: number;
+		salt: string;
+		cipher: string;
+		nonce: string;
+		ciphertext: string;
+	};
+}
+
+// Amounts are exact decimal strings (e.g. "1.5") on the wire.
 export interface Transaction {
+	id?: string;
+	chain_id?: string;
+	nonce?: number;
 	sender_blockchain_address: string;
 	recipient_blockchain_address: string;
-	value: number;
+	value: string;
+	fee?: string;
 	timestamp?: number;
+	unlock_height?: number;
+	unlock_time?: number;
 }
 
 export interface Block {
+	hash?: string;
 	nonce: number;
 	previous_hash: string;
+	merkle_root?: string;
+	witness_root?: string;
 	timestamp: number;
 	transactions: Transaction[];
 }
 
 export interface GetChainResponse {
+	chain_id: string;
 	chain: Block[];
 	chain_length: number;
 	transaction_pool: Transaction[];
@@ -35,11 +61,11 @@ export interface GetChainResponse {
 	mining_difficulty: number;
 	mining_reward: number;
 	neighbors: string[];
-	wallets: Record<string, Wallet>;
 }
 
 export interface GetWalletAmountResponse {
 	amount: number;
+	spendable?: number;
 }
 
 export type ListTransactionPoolsResponse = Transaction[];
@@ -55,3 +81,12 @@ export interface Node {
 export interface WalletWithBalance extends Wallet {
 	balance?: number;
 }
+
+export type NodeEventType = 'block' | 'transaction' | 'reorg' | 'mining';
+
+// Events from GET /events, data depends on the type.
+export interface NodeEvent {
+	type: NodeEventType;
+	time: string;
+	data: unknown;
+}
@@ -1,13 +1,13 @@
-import type { Commonresponse } from '@/lib/types';
+import { unlockWallet } from '@/lib/keystore';
+import { signTransaction } from '@/lib/signing';
+import type { Commonresponse, Wallet } from '@/lib/types';
 
 interface CreateTransactionParams {
-	transaction: {
-		sender_private_key: string;
-		sender_public_key: string;
-		sender_blockchain_address: string;
-		recipient_blockchain_address: string;
-		value: number;
-	};
+	wallet: Wallet;
+	passphrase: string;
+	recipient: string;
+	value: string;
+	fee: string;
 	baseUrl?: string;
 }
 
@@ -15,13 +15,35 @@ export async function createTransaction(
 	data: CreateTransactionParams,
 ): Promise<Commonresponse> {
 	try {
+		const nonceRes = await fetch(
+			`${data.baseUrl}/address/${data.wallet.blockchain_address}/nonce`,
+		);
+		if (!nonceRes.ok) {
+			throw new Error('Failed to fetch nonce');
+		}
+		const { chain_id, nonce } = await nonceRes.json();
+
+		const privateKey = await unlockWallet(
+			data.baseUrl || '',
+			data.wallet.blockchain_address,
+			data.passphrase,
+		);
+		const transaction = await signTransaction(
+			data.wallet,
+			privateKey,
+			chain_id,
+			data.recipient,
+			data.value,
+			nonce,
+			data.fee,
+		);
 		const res = await fetch(`${data.baseUrl}/transactions`, {
 			method: 'POST',
 			headers: {
 				'Content-Type': 'application/json',
 				Accept: 'application/json',
 			},
-			body: JSON.stringify(data.transaction),
+			body: JSON.stringify(transaction),
 		});
 		if (!res.ok) {
 			const errorData = await res.json().catch(() => ({}));
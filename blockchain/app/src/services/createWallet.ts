@@ -1,23 +1,26 @@
 import { DEFAULT_BASE_URL } from '@/lib/constants';
 import type { Wallet, Commonresponse } from '@/lib/types';
 
-export async function createWallet(
-	baseUrl: string = DEFAULT_BASE_URL,
-): Promise<Wallet & Partial<Commonresponse>> {
-	try {
-		const res = await fetch(`${baseUrl}/wallet`);
-		if (!res.ok) {
-			const errorData = await res.json().catch(() => ({}));
-			throw new Error(errorData.error || 'Failed to create wallet');
-		}
-		return await res.json();
-	} catch (err: any) {
-		return {
-			private_key: '',
-			public_key: '',
-			blockchain_address: '',
-			message: 'failed',
-			error: err.message || 'Unknown error occurred',
-		};
+interface CreateWalletParams {
+	passphrase: string;
+	baseUrl?: string;
+}
+
+export async function createWallet({
+	passphrase,
+	baseUrl = DEFAULT_BASE_URL,
+}: CreateWalletParams): Promise<Wallet & Partial<Commonresponse>> {
+	const res = await fetch(`${baseUrl}/wallet`, {
+		method: 'POST',
+		headers: {
+			'Content-Type': 'application/json',
+			Accept: 'application/json',
+		},
+		body: JSON.stringify({ passphrase }),
+	});
+	if (!res.ok) {
+		const errorData = await res.json().catch(() => ({}));
+		throw new Error(errorData.error || 'Failed to create wallet');
 	}
+	return await res.json();
 }
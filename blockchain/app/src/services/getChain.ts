@@ -10,9 +10,11 @@ export async function getChain(
 			const errorData = await res.json().catch(() => ({}));
 			throw new Error(errorData.error || 'Failed to get chain');
 		}
-		return await res.json();
+		const data = await res.json();
+		return { ...data, mining_reward: Number(data.mining_reward) };
 	} catch (err: any) {
 		return {
+			chain_id: '',
 			chain: [],
 			chain_length: 0,
 			transaction_pool: [],
@@ -23,7 +25,6 @@ export async function getChain(
 			mining: false,
 			host: '',
 			neighbors: [],
-			wallets: {},
 			message: 'failed',
 			error: err.message || 'Unknown error occurred',
 		};
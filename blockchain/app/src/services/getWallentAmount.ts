@@ -15,7 +15,12 @@ export async function getWalletAmount(
 			throw new Error(errorData.error || `Failed to fetch wallet amount`);
 		}
 
-		return await res.json();
+		const data = await res.json();
+		return {
+			...data,
+			amount: Number(data.amount),
+			spendable: Number(data.spendable),
+		};
 	} catch (err: any) {
 		return {
 			amount: 0,
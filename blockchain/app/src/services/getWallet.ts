@@ -14,7 +14,6 @@ export async function getWallet(
 		return await res.json();
 	} catch (err: any) {
 		return {
-			private_key: '',
 			public_key: '',
 			blockchain_address: '',
 			message: 'failed',
@@ -0,0 +1,17 @@
+import { DEFAULT_BASE_URL } from '@/lib/constants';
+import type { Wallet } from '@/lib/types';
+
+export async function listWallets(
+	baseUrl: string = DEFAULT_BASE_URL,
+): Promise<Wallet[]> {
+	try {
+		const res = await fetch(`${baseUrl}/wallet`);
+		if (!res.ok) {
+			const errorData = await res.json().catch(() => ({}));
+			throw new Error(errorData.error || 'Failed to list wallets');
+		}
+		return await res.json();
+	} catch {
+		return [];
+	}
+}
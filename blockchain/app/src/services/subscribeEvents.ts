@@ -0,0 +1,25 @@
+import { DEFAULT_BASE_URL } from '@/lib/constants';
+import type { NodeEvent, NodeEventType } from '@/lib/types';
+
+// subscribeEvents opens the node's event stream and calls onEvent for every
+// event of the given types, only the blocks and transactions of addresses
+// when some are given. It returns a function closing the stream.
+export function subscribeEvents(
+	onEvent: (event: NodeEvent) => void,
+	{
+		types = [],
+		addresses = [],
+	}: { types?: NodeEventType[]; addresses?: string[] } = {},
+	baseUrl: string = DEFAULT_BASE_URL,
+): () => void {
+	const params = new URLSearchParams();
+	if (types.length) params.set('type', types.join(','));
+	if (addresses.length) params.set('address', addresses.join(','));
+
+	const source = new EventSource(`${baseUrl}/events?${params}`);
+	const handler = (e: MessageEvent) => onEvent(JSON.parse(e.data));
+	for (const type of ['block', 'transaction', 'reorg', 'mining']) {
+		source.addEventListener(type, handler);
+	}
+	return () => source.close();
+}
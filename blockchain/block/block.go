@@ -5,28 +5,106 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 )
 
+// BlockHeader is the part of a block that proof of work and the chain of
+// previous hashes are computed over. The transactions are bound to it
+// through their Merkle root and their witnesses through the witness root.
+type BlockHeader struct {
+	Nonce        int
+	PreviousHash [32]byte
+	Timestamp    int64
+	MerkleRoot   [32]byte
+	WitnessRoot  [32]byte
+	Difficulty   int
+}
+
+func (h *BlockHeader) Hash() [32]byte {
+	m, _ := h.MarshalJSON()
+	return sha256.Sum256(m)
+}
+
+// MeetsDifficulty reports whether the header hash starts with as many zero
+// hex digits as the header's difficulty asks for.
+func (h *BlockHeader) MeetsDifficulty() bool {
+	difficulty := clampDifficulty(h.Difficulty)
+	hash := fmt.Sprintf("%x", h.Hash())
+	return hash[:difficulty] == strings.Repeat("0", difficulty)
+}
+
+func (h *BlockHeader) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Nonce        int    `json:"nonce"`
+		PreviousHash string `json:"previous_hash"`
+		Timestamp    int64  `json:"timestamp"`
+		MerkleRoot   string `json:"merkle_root"`
+		WitnessRoot  string `json:"witness_root"`
+		Difficulty   int    `json:"difficulty"`
+	}{
+		Nonce:        h.Nonce,
+		PreviousHash: fmt.Sprintf("%x", h.PreviousHash),
+		Timestamp:    h.Timestamp,
+		MerkleRoot:   fmt.Sprintf("%x", h.MerkleRoot),
+		WitnessRoot:  fmt.Sprintf("%x", h.WitnessRoot),
+		Difficulty:   h.Difficulty,
+	})
+}
+
 type Block struct {
 	nonce        int
 	previousHash [32]byte
 	timestamp    int64
+	merkleRoot   [32]byte
+	witnessRoot  [32]byte
+	difficulty   int
 	transactions []*Transaction
 }
 
-func NewBlock(nonce int, previousHash [32]byte, transactions []*Transaction) *Block {
+// NewHeader prepares the header of the next block over transactions, the
+// nonce is left for ProofOfWork to find.
+func NewHeader(previousHash [32]byte, difficulty int, transactions []*Transaction) *BlockHeader {
+	return &BlockHeader{
+		PreviousHash: previousHash,
+		Timestamp:    time.Now().UnixNano(),
+		MerkleRoot:   MerkleRoot(transactions),
+		WitnessRoot:  WitnessRoot(transactions),
+		Difficulty:   difficulty,
+	}
+}
+
+func NewBlock(header *BlockHeader, transactions []*Transaction) *Block {
 	b := new(Block)
-	b.timestamp = time.Now().UnixNano()
-	b.nonce = nonce
-	b.previousHash = previousHash
+	b.timestamp = header.Timestamp
+	b.nonce = header.Nonce
+	b.previousHash = header.PreviousHash
+	b.merkleRoot = header.MerkleRoot
+	b.witnessRoot = header.WitnessRoot
+	b.difficulty = header.Difficulty
 	b.transactions = transactions
 	return b
 }
 
+func (b *Block) Header() *BlockHeader {
+	return &BlockHeader{
+		Nonce:        b.nonce,
+		PreviousHash: b.previousHash,
+		Timestamp:    b.timestamp,
+		MerkleRoot:   b.merkleRoot,
+		WitnessRoot:  b.witnessRoot,
+		Difficulty:   b.difficulty,
+	}
+}
+
+// consistent reports whether the transactions of b and their witnesses are
+// the ones its header commits to.
+func (b *Block) consistent() bool {
+	return b.merkleRoot == MerkleRoot(b.transactions) && b.witnessRoot == WitnessRoot(b.transactions)
+}
+
 func (b *Block) Hash() [32]byte {
-	m, _ := b.MarshalJSON()
-	return sha256.Sum256(m)
+	return b.Header().Hash()
 }
 
 func (b *Block) PreviousHash() [32]byte {
@@ -37,49 +115,82 @@ func (b *Block) Nonce() int {
 	return b.nonce
 }
 
+func (b *Block) MerkleRoot() [32]byte {
+	return b.merkleRoot
+}
+
+func (b *Block) WitnessRoot() [32]byte {
+	return b.witnessRoot
+}
+
+func (b *Block) Difficulty() int {
+	return b.difficulty
+}
+
 func (b *Block) Transactions() []*Transaction {
 	return b.transactions
 }
 
 func (b *Block) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
+		Hash         string         `json:"hash"`
 		Nonce        int            `json:"nonce"`
 		PreviousHash string         `json:"previous_hash"`
 		Timestamp    int64          `json:"timestamp"`
+		MerkleRoot   string         `json:"merkle_root"`
+		WitnessRoot  string         `json:"witness_root"`
+		Difficulty   int            `json:"difficulty"`
 		Transactions []*Transaction `json:"transactions"`
 	}{
+		Hash:         fmt.Sprintf("%x", b.Hash()),
 		Nonce:        b.nonce,
 		PreviousHash: fmt.Sprintf("%x", b.previousHash),
 		Timestamp:    b.timestamp,
+		MerkleRoot:   fmt.Sprintf("%x", b.merkleRoot),
+		WitnessRoot:  fmt.Sprintf("%x", b.witnessRoot),
+		Difficulty:   b.difficulty,
 		Transactions: b.transactions,
 	})
 }
 
 func (b *Block) UnmarshalJSON(data []byte) error {
-	var previousHash string
+	var previousHash, merkleRoot, witnessRoot string
 	v := &struct {
 		Nonce        *int            `json:"nonce"`
 		PreviousHash *string         `json:"previous_hash"`
 		Timestamp    *int64          `json:"timestamp"`
+		MerkleRoot   *string         `json:"merkle_root"`
+		WitnessRoot  *string         `json:"witness_root"`
+		Difficulty   *int            `json:"difficulty"`
 		Transactions *[]*Transaction `json:"transactions"`
 	}{
 		Nonce:        &b.nonce,
 		PreviousHash: &previousHash,
 		Timestamp:    &b.timestamp,
+		MerkleRoot:   &merkleRoot,
+		WitnessRoot:  &witnessRoot,
+		Difficulty:   &b.difficulty,
 		Transactions: &b.transactions,
 	}
 	if err := json.Unmarshal(data, &v); err != nil {
 		return err
 	}
 	ph, _ := hex.DecodeString(previousHash)
-	copy(b.previousHash[:], ph[:32])
+	copy(b.previousHash[:], ph)
+	mr, _ := hex.DecodeString(merkleRoot)
+	copy(b.merkleRoot[:], mr)
+	wr, _ := hex.DecodeString(witnessRoot)
+	copy(b.witnessRoot[:], wr)
 	return nil
 }
 
 func (b *Block) Print() {
 	fmt.Printf("Timestamp: %d\n", b.timestamp)
 	fmt.Printf("Nonce: %d\n", b.nonce)
+	fmt.Printf("Difficulty: %d\n", b.difficulty)
 	fmt.Printf("Previous Hash: %x\n", b.previousHash)
+	fmt.Printf("Merkle Root: %x\n", b.merkleRoot)
+	fmt.Printf("Witness Root: %x\n", b.witnessRoot)
 	for _, t := range b.transactions {
 		t.Print()
 	}
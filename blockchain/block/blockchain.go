@@ -1,345 +1,953 @@
 package block
 
 import (
-	"bytes"
-	"crypto/ecdsa"
-	"crypto/sha256"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"net/http"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/EmilioCliff/learn-go/blockchain/address"
 	"github.com/EmilioCliff/learn-go/blockchain/utils"
-	"github.com/EmilioCliff/learn-go/blockchain/wallet"
 )
 
+// Blockchain is safe for concurrent use. mux guards the chain, the pool,
+// the index and the mining state; every exported method takes
+// it, and the unexported helpers expect the caller to hold it. Network calls
+// to neighbors are always made without holding it.
 type Blockchain struct {
 	chain             []*Block
-	transactionPool   []*Transaction
+	pool              *mempool
 	blockchainAddress string
 	port              uint16
-	mux               sync.Mutex
+	mux               sync.RWMutex
 	cancelMining      *time.Timer
+	autoMining        bool
+	miner             *Miner
+	miningMux         sync.Mutex
+	cancelSearch      context.CancelFunc
+	store             Store
+	index             *chainIndex
+	reorgs            []ReorgEvent
+	events            *eventBus
+	genesis           *Block
 
 	config utils.Config
+	peers  *PeerManager
+}
+
+// NewBlockchain opens the block store configured by DATA_DIR (one file per
+// port so several nodes can share a directory) or keeps the chain in memory
+// when DATA_DIR is empty. The node key is read from NODE_KEY_FILE, or
+// DATA_DIR/node_<port>.key, and created on first start. A new chain starts
+// from GENESIS_FILE when it is set.
+func NewBlockchain(blockchainAddress string, config utils.Config) (*Blockchain, error) {
+	port := config.PORT
+	var store Store = NewMemoryStore()
+	if config.DATA_DIR != "" {
+		fs, err := NewFileStore(filepath.Join(config.DATA_DIR, fmt.Sprintf("chain_%d.dat", port)))
+		if err != nil {
+			return nil, err
+		}
+		store = fs
+	}
+
+	keyFile := config.NODE_KEY_FILE
+	if keyFile == "" && config.DATA_DIR != "" {
+		keyFile = filepath.Join(config.DATA_DIR, fmt.Sprintf("node_%d.key", port))
+	}
+	var key *NodeKey
+	if keyFile != "" {
+		var err error
+		if key, err = LoadNodeKey(keyFile); err != nil {
+			return nil, fmt.Errorf("failed to load node key: %w", err)
+		}
+	}
 
-	neighbors   []string
-	neighborMux sync.Mutex
+	var genesis *Genesis
+	if config.GENESIS_FILE != "" {
+		var err error
+		if genesis, err = LoadGenesis(config.GENESIS_FILE); err != nil {
+			return nil, fmt.Errorf("failed to load genesis: %w", err)
+		}
+	}
 
-	wallets map[string]*wallet.Wallet
+	return NewBlockchainWithStore(blockchainAddress, config, store, key, genesis)
 }
 
-func NewBlockchain(blockchainAddress string, port uint16) *Blockchain {
+// NewBlockchainWithStore reloads the chain persisted in store, keeping only
+// the longest prefix that still passes validation, and creates the genesis
+// block when the store is empty, from genesis or DefaultGenesis when it is
+// nil. A genesis with another chain ID than CHAIN_ID, or a stored chain
+// that starts from another genesis block, is an error. A nil key gives the
+// node a new identity for as long as it runs.
+func NewBlockchainWithStore(blockchainAddress string, config utils.Config, store Store, key *NodeKey, genesis *Genesis) (*Blockchain, error) {
+	if genesis == nil {
+		genesis = DefaultGenesis()
+	}
+	genesis, err := genesis.withChainID(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if key == nil {
+		var err error
+		if key, err = NewNodeKey(); err != nil {
+			return nil, fmt.Errorf("failed to create node key: %w", err)
+		}
+	}
+
 	bc := &Blockchain{
 		blockchainAddress: blockchainAddress,
-		port:              port,
-		wallets:           make(map[string]*wallet.Wallet),
-		transactionPool:   []*Transaction{},
+		port:              config.PORT,
+		config:            config,
 		chain:             []*Block{},
 		cancelMining:      nil,
+		store:             store,
+		events:            newEventBus(),
+	}
+	bc.genesis = genesis.block(bc.config.MINING_SENDER)
+	bc.index = newChainIndex(bc.config.MINING_SENDER)
+	bc.pool = newMempool(
+		bc.config.MEMPOOL_SIZE,
+		bc.config.MEMPOOL_MAX_BYTES,
+		bc.config.MEMPOOL_MAX_PER_SENDER,
+		bc.config.MEMPOOL_TTL,
+		bc.config.MEMPOOL_RBF_BUMP,
+	)
+	bc.peers = NewPeerManager(
+		bc.config.AdvertisedURL(),
+		Network{ChainID: bc.config.CHAIN_ID, GenesisHash: fmt.Sprintf("%x", bc.genesis.Hash())},
+		bc.config.NEIGHBORS,
+		bc.config.MAX_PEERS,
+		bc.config.PEER_FANOUT,
+		bc.config.PEER_TIMEOUT,
+		bc.config.PEER_HEALTH_INTERVAL,
+		key,
+		bc.config.PEER_KEYS,
+	)
+	log.Printf("INFO: Node %s at %s\n", key.ID(), bc.peers.Self())
+	if len(bc.config.PEER_KEYS) == 0 {
+		log.Println("WARN: PEER_KEYS is empty, any node that handshakes becomes a peer")
+	}
+	bc.miner = NewMiner(bc.config.MINING_WORKERS)
+
+	chain, err := store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chain: %w", err)
+	}
+	if len(chain) > 0 && chain[0].Hash() != bc.genesis.Hash() {
+		return nil, fmt.Errorf("stored chain starts from genesis block %x, not %x: remove it or start with its GENESIS_FILE", chain[0].Hash(), bc.genesis.Hash())
+	}
+	if n := bc.validPrefix(chain, 1); n < len(chain) {
+		log.Printf("WARN: Stored chain is invalid from block %d, dropping %d blocks\n", n, len(chain)-n)
+		chain = chain[:n]
+		if err := store.Replace(chain); err != nil {
+			return nil, fmt.Errorf("failed to rewrite chain: %w", err)
+		}
+	}
+	bc.chain = chain
+	if err := bc.index.rebuild(bc.chain, bc.pool.transactions); err != nil {
+		return nil, fmt.Errorf("failed to index chain: %w", err)
 	}
-	bc.config, _ = utils.LoanConfig()
-	bc.neighbors = bc.config.NEIGHBORS
 
-	b := &Block{}
-	bc.CreateBlock(0, b.previousHash)
+	if len(bc.chain) == 0 {
+		if _, err := bc.appendBlock(bc.genesis.Header(), bc.genesis.transactions); err != nil {
+			return nil, fmt.Errorf("failed to create genesis block: %w", err)
+		}
+	} else {
+		log.Printf("INFO: Loaded %d blocks from store\n", len(bc.chain))
+	}
 
-	return bc
+	return bc, nil
 }
 
+// Run connects to the seed peers and catches up with the heaviest chain
+// among them.
 func (bc *Blockchain) Run() {
+	bc.peers.Start()
 	bc.ResolveConflicts()
 	// bc.StartMining() // comment auto mining out
 }
 
-func (bc *Blockchain) CreateBlock(nonce int, previousHash [32]byte) *Block {
-	b := NewBlock(nonce, previousHash, bc.transactionPool)
-	bc.chain = append(bc.chain, b)
-	bc.transactionPool = []*Transaction{}
+// CreateBlock appends a block of transactions sealed with header, which
+// ProofOfWork found over those same transactions, drops them from the pool
+// and announces the block to the peers.
+func (bc *Blockchain) CreateBlock(header *BlockHeader, transactions []*Transaction) (*Block, error) {
+	bc.mux.Lock()
+	b, err := bc.appendBlock(header, transactions)
+	height := len(bc.chain) - 1
+	bc.mux.Unlock()
+	if err != nil {
+		return nil, err
+	}
 
-	for _, n := range bc.neighbors {
-		if n == fmt.Sprintf("%s", bc.config.HOST) {
-			continue
-		}
-		endpoint := fmt.Sprintf("%s/transactions", n)
-		req, _ := http.NewRequest("DELETE", endpoint, nil)
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			log.Printf("ERROR: Send  transaction to %s : %s\n", n, err.Error())
-			continue
-		}
-		if resp.StatusCode == http.StatusOK {
-			log.Printf("INFO: Send transaction to %s\n", n)
-		} else {
-			log.Printf("ERROR: Send transaction to %s\n", n)
+	bc.announceBlock(b, height)
+	return b, nil
+}
+
+// appendBlock adds the block sealed with header to the index, the store and
+// the chain, in that order, and sweeps its transactions from the pool. A
+// block the index or the store rejects is an error and leaves the chain as
+// it was. The caller holds bc.mux.
+func (bc *Blockchain) appendBlock(header *BlockHeader, transactions []*Transaction) (*Block, error) {
+	b := NewBlock(header, transactions)
+	if err := bc.index.applyBlock(b, len(bc.chain)); err != nil {
+		return nil, err
+	}
+	if err := bc.store.Append(b); err != nil {
+		err = fmt.Errorf("persist block: %w", err)
+		if rerr := bc.index.rebuild(bc.chain, bc.pool.transactions); rerr != nil {
+			err = errors.Join(err, fmt.Errorf("rebuild index: %w", rerr))
 		}
+		return nil, err
 	}
-	return b
+	bc.chain = append(bc.chain, b)
+	bc.resetPool(bc.pool.transactions)
+	bc.publishBlock(b, len(bc.chain)-1)
+	return b, nil
 }
 
-func (bc *Blockchain) CreateTransaction(sender string, recipient string, value float32, senderPublicKey *ecdsa.PublicKey, s *utils.Signature) bool {
-	isTransacted := bc.AddTransaction(sender, recipient, value, senderPublicKey, s)
-	if isTransacted {
-		for _, n := range bc.neighbors {
-			if n == fmt.Sprintf("%s", bc.config.HOST) {
-				continue
-			}
-			publickKeyStr := fmt.Sprintf("%064x%064x", senderPublicKey.X.Bytes(), senderPublicKey.Y.Bytes())
-			signatureStr := s.String()
-			bt := &TransactionRequest{
-				SenderBlockchainAddress:    &sender,
-				RecipientBlockchainAddress: &recipient,
-				Value:                      value,
-				SenderPublicKey:            &publickKeyStr,
-				Signature:                  &signatureStr,
-			}
-			m, _ := json.Marshal(bt)
-			endpoint := fmt.Sprintf("%s/transactions", n)
-			req, _ := http.NewRequest("PUT", endpoint, bytes.NewBuffer(m))
-			client := &http.Client{}
-			resp, _ := client.Do(req)
-			if resp.StatusCode == http.StatusOK {
-				log.Printf("INFO: Send transaction to %s\n", n)
-			} else {
-				log.Printf("ERROR: Send transaction to %s\n", n)
-			}
-		}
+// CreateTransaction adds t to the pool and gossips it to the peers, it is
+// used both for transactions submitted by clients and for the ones relayed
+// by other nodes.
+func (bc *Blockchain) CreateTransaction(t *Transaction, w *Witness) bool {
+	isTransacted := bc.AddTransaction(t, w)
+	if isTransacted && bc.peers.MarkSeen(t.ID()) {
+		m, _ := json.Marshal(w.request(t))
+		bc.peers.Broadcast("PUT", "/transactions", m)
 	}
 
 	return isTransacted
 }
 
-func (bc *Blockchain) AddTransaction(sender string, recipient string, value float32, senderPublicKey *ecdsa.PublicKey, s *utils.Signature) bool {
-	t := NewTransaction(sender, recipient, value)
+// AddTransaction verifies t and puts it in the pool. A transaction whose ID
+// is already pending or confirmed is rejected, and so is one that does not
+// carry exactly the sender's next nonce, which stops signed requests from
+// being replayed. w must authorize t, see Witness.
+func (bc *Blockchain) AddTransaction(t *Transaction, w *Witness) bool {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
 
-	if sender == bc.config.MINING_SENDER {
-		log.Println("INFO: Mining reward")
-		bc.transactionPool = append(bc.transactionPool, t)
-		return true
+	bc.expirePool(time.Now())
+	if err := bc.admit(t, w); err != nil {
+		bc.pool.stats.Rejected++
+		log.Printf("ERROR: Reject transaction %s: %s\n", t.ID(), err.Error())
+		return false
 	}
+	bc.pool.stats.Admitted++
+	bc.publishTransaction(t)
+	return true
+}
 
-	if bc.VerifyTransactionSignature(senderPublicKey, s, t) {
-		if bc.CalculateTotalAmount(sender) < value {
-			log.Println("ERROR: Not enough balance in a wallet")
-			return false
-		}
-		bc.transactionPool = append(bc.transactionPool, t)
-		return true
-	} else {
-		log.Println("ERROR: Verify Transaction")
+// admissible checks t against the chain and the pool, everything but its
+// signature.
+func (bc *Blockchain) admissible(t *Transaction) error {
+	if bc.hasTransaction(t.ID()) {
+		return fmt.Errorf("duplicate transaction")
 	}
-
-	return false
+	if t.senderBlockchainAddress == bc.config.MINING_SENDER {
+		return fmt.Errorf("mining rewards are only created by the miner")
+	}
+	if err := bc.wellFormed(t); err != nil {
+		return err
+	}
+	if expected := bc.index.nextNonce(t.senderBlockchainAddress); t.nonce != expected {
+		return fmt.Errorf("invalid nonce %d, expected %d", t.nonce, expected)
+	}
+	if t.fee < 0 {
+		return fmt.Errorf("negative fee")
+	}
+	cost, err := t.cost()
+	if err != nil {
+		return fmt.Errorf("cost: %w", err)
+	}
+	if bc.spendable(t.senderBlockchainAddress) < cost {
+		return fmt.Errorf("not enough balance in a wallet")
+	}
+	if _, err := bc.index.balance(t.recipientBlockchainAddress).Add(t.value); err != nil {
+		return fmt.Errorf("recipient balance: %w", err)
+	}
+	return nil
 }
 
-func (bc *Blockchain) RegisterWallet(w *wallet.Wallet) {
-	bc.wallets[w.BlockchainAddress()] = w
+// resetPool rebuilds the pool from candidates on top of the current chain.
+// Candidates already confirmed are dropped, the others are admitted again
+// in as many passes as it takes for transactions that depend on each other,
+// and the ones that still do not fit, because their nonce was taken or
+// their funds spent by the chain, are evicted and returned. Witnesses were
+// verified when the transactions were first admitted or when the block that
+// confirmed them was checked, so they are not checked again.
+func (bc *Blockchain) resetPool(candidates []*Transaction) []*Transaction {
+	_, added := bc.pool.reset()
+	bc.index.clearPending()
+
+	remaining := []*Transaction{}
+	for _, t := range candidates {
+		if _, confirmed := bc.index.blockHeight(t.ID()); !confirmed {
+			remaining = append(remaining, t)
+		}
+	}
+	for progress := true; progress && len(remaining) > 0; {
+		progress = false
+		rejected := []*Transaction{}
+		for _, t := range remaining {
+			if bc.admissible(t) != nil || bc.index.applyPending(t) != nil {
+				rejected = append(rejected, t)
+				continue
+			}
+			at, ok := added[t.ID()]
+			if !ok {
+				at = time.Now()
+			}
+			bc.pool.insert(t, at)
+			progress = true
+		}
+		remaining = rejected
+	}
+	for _, t := range remaining {
+		log.Printf("WARN: Evicted transaction %s from the pool\n", t.ID())
+	}
+	bc.pool.stats.Dropped += uint64(len(remaining))
+	return remaining
 }
 
-func (bc *Blockchain) GetWallet(blockchainAddress string) *wallet.Wallet {
-	return bc.wallets[blockchainAddress]
+// VerifyTransactionSignature checks that w authorizes t: a signature by the
+// key behind a single key sender, or signatures by enough keys of the
+// policy behind a multisig sender.
+func (bc *Blockchain) VerifyTransactionSignature(w *Witness, t *Transaction) bool {
+	if err := w.verify(t); err != nil {
+		log.Printf("ERROR: Reject transaction %s: %s\n", t.ID(), err.Error())
+		return false
+	}
+	return true
 }
 
-func (bc *Blockchain) VerifyTransactionSignature(senderPublicKey *ecdsa.PublicKey, s *utils.Signature, t *Transaction) bool {
-	m, _ := json.Marshal(t)
-	h := sha256.Sum256([]byte(m))
-	return ecdsa.Verify(senderPublicKey, h[:], s.R, s.S)
+// spendable is the balance of blockchainAddress without the value it
+// received that is still locked for the next block.
+func (bc *Blockchain) spendable(blockchainAddress string) utils.Amount {
+	return bc.index.balance(blockchainAddress) - bc.index.locked(blockchainAddress, uint64(len(bc.chain)), time.Now().Unix())
 }
 
-func (bc *Blockchain) ProofOfWork() int {
-	previousHash := bc.LastBlock().Hash()
-	transaction := bc.CopyTransactionPool()
-	nonce := 0
-	for !bc.ValidProof(nonce, previousHash, transaction) {
-		nonce++
-	}
-	return nonce
+// ProofOfWork searches for a nonce that makes header meet its difficulty
+// on all miner workers, giving up when ctx is cancelled.
+func (bc *Blockchain) ProofOfWork(ctx context.Context, header *BlockHeader) (*BlockHeader, error) {
+	return bc.miner.Search(ctx, *header)
 }
 
-func (bc *Blockchain) ValidProof(nonce int, previousHash [32]byte, transactions []*Transaction) bool {
-	b := Block{nonce, previousHash, 0, transactions}
-	hash := fmt.Sprintf("%x", b.Hash())
-	return hash[:bc.config.MINING_DIFFICULTY] == strings.Repeat("0", bc.config.MINING_DIFFICULTY)
+// ValidProof checks the header hash against the difficulty the header
+// claims, whether that difficulty was the right one is up to ValidChain.
+func (bc *Blockchain) ValidProof(header *BlockHeader) bool {
+	return header.MeetsDifficulty()
 }
 
+// Mining mines one block on top of the chain tip with the pool
+// transactions paying the best fee rates that fit in MAX_BLOCK_SIZE, and a
+// reward of MINING_REWARD plus their fees. The chain lock is only held to
+// prepare and to commit the block, not during the nonce search, which is
+// cancelled when the tip changes under it. It returns false when another
+// search is running or this one was cancelled.
 func (bc *Blockchain) Mining() bool {
-	bc.mux.Lock()
-	defer bc.mux.Unlock()
-
-	bc.AddTransaction(bc.config.MINING_SENDER, bc.blockchainAddress, bc.config.MINING_REWARD, nil, nil)
-	nonce := bc.ProofOfWork()
-	previousHash := bc.LastBlock().Hash()
-	bc.CreateBlock(nonce, previousHash)
+	if !bc.miningMux.TryLock() {
+		return false
+	}
+	defer bc.miningMux.Unlock()
 
-	for _, n := range bc.neighbors {
-		if n == fmt.Sprintf("%s", bc.config.HOST) {
-			continue
-		}
-		enpoint := fmt.Sprintf("%s/consensus", n)
-		client := &http.Client{}
-		req, _ := http.NewRequest("PUT", enpoint, nil)
-		resp, err := client.Do(req)
+	bc.mux.Lock()
+	bc.expirePool(time.Now())
+	previousHash := bc.lastBlock().Hash()
+	reward := NewTransaction(bc.config.CHAIN_ID, bc.config.MINING_SENDER, bc.blockchainAddress, bc.config.MINING_REWARD, uint64(len(bc.chain)))
+	// The header is stamped no earlier than this, so what is unlocked at
+	// the selection is still unlocked when the block is validated.
+	timestamp := max(time.Now().UnixNano(), medianTimePast(bc.chain)+1)
+	selected := bc.selectTransactions(reward.Size(), timestamp)
+	for _, t := range selected {
+		value, err := reward.value.Add(t.fee)
 		if err != nil {
-			log.Printf("ERROR: Send consensus to %s : %s\n", n, err.Error())
-			continue
+			bc.mux.Unlock()
+			log.Printf("ERROR: Mining reward: %s\n", err.Error())
+			return false
 		}
-		log.Printf("INFO: Send consensus to %s %d\n", n, resp.StatusCode)
+		reward.value = value
 	}
+	transactions := append([]*Transaction{reward}, selected...)
+	header := NewHeader(previousHash, bc.expectedDifficulty(bc.chain), transactions)
+	// A clock running behind the chain still has to stamp the block after
+	// the median time past.
+	header.Timestamp = max(header.Timestamp, medianTimePast(bc.chain)+1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bc.cancelSearch = cancel
+	bc.publishMiningState()
+	bc.mux.Unlock()
+
+	sealed, err := bc.ProofOfWork(ctx, header)
+
+	bc.mux.Lock()
+	bc.cancelSearch = nil
+	bc.publishMiningState()
+	if err != nil {
+		bc.mux.Unlock()
+		log.Printf("INFO: Mining cancelled: %s\n", err.Error())
+		return false
+	}
+	if bc.lastBlock().Hash() != previousHash {
+		bc.mux.Unlock()
+		log.Println("INFO: Mined block is stale, chain tip changed")
+		return false
+	}
+	b, err := bc.appendBlock(sealed, transactions)
+	height := len(bc.chain) - 1
+	bc.mux.Unlock()
+	if err != nil {
+		log.Printf("ERROR: Append mined block: %s\n", err.Error())
+		return false
+	}
+
+	bc.announceBlock(b, height)
 	return true
 }
 
+// ValidChain checks every block of chain, starting with the genesis block,
+// which has to be ours.
 func (bc *Blockchain) ValidChain(chain []*Block) bool {
-	preBlock := chain[0]
-	currentIndex := 1
+	return bc.validPrefix(chain, 0) == len(chain)
+}
+
+// validPrefix returns how many blocks from the start of chain link up,
+// carry a valid proof of work at the expected difficulty and hold valid
+// transactions. The blocks before start are trusted; the genesis block has
+// no parent to check, it has to be ours.
+func (bc *Blockchain) validPrefix(chain []*Block, start int) int {
+	if len(chain) == 0 {
+		return 0
+	}
+	if start == 0 && chain[0].Hash() != bc.genesis.Hash() {
+		log.Printf("ERROR: Chain starts from another genesis block %x\n", chain[0].Hash())
+		return 0
+	}
+	currentIndex := max(start, 1)
+	index := newChainIndex(bc.config.MINING_SENDER)
+	if err := index.rebuild(chain[:currentIndex], nil); err != nil {
+		log.Printf("ERROR: Index chain: %s\n", err.Error())
+		return 0
+	}
 	for currentIndex < len(chain) {
-		b := chain[currentIndex]
-		if b.previousHash != preBlock.Hash() {
-			return false
+		if !bc.validNextBlock(chain[:currentIndex], index, chain[currentIndex]) {
+			return currentIndex
 		}
-		if !bc.ValidProof(b.Nonce(), b.PreviousHash(), b.Transactions()) {
-			return false
+		if err := index.applyBlock(chain[currentIndex], currentIndex); err != nil {
+			log.Printf("ERROR: Block %d: %s\n", currentIndex, err.Error())
+			return currentIndex
 		}
-		preBlock = b
 		currentIndex++
 	}
-	return true
+	return currentIndex
 }
 
-func (bc *Blockchain) ResolveConflicts() bool {
-	var longestChain []*Block = nil
-	maxLength := len(bc.chain)
+// validNextBlock checks that b can be appended to chain, whose state index
+// holds.
+func (bc *Blockchain) validNextBlock(chain []*Block, index *chainIndex, b *Block) bool {
+	if b.previousHash != chain[len(chain)-1].Hash() {
+		return false
+	}
+	if !b.consistent() {
+		return false
+	}
+	if err := uniqueTransactions(b.transactions); err != nil {
+		log.Printf("ERROR: Block %x: %s\n", b.Hash(), err.Error())
+		return false
+	}
+	if b.difficulty != bc.expectedDifficulty(chain) {
+		return false
+	}
+	if err := validTimestamp(chain, b, time.Now()); err != nil {
+		log.Printf("ERROR: Block %x: %s\n", b.Hash(), err.Error())
+		return false
+	}
+	if size := blockSize(b.transactions); size > bc.config.MAX_BLOCK_SIZE {
+		log.Printf("ERROR: Block of %d bytes exceeds %d\n", size, bc.config.MAX_BLOCK_SIZE)
+		return false
+	}
+	if err := bc.validRewards(b.transactions); err != nil {
+		log.Printf("ERROR: %s\n", err.Error())
+		return false
+	}
+	for _, t := range b.transactions {
+		if err := bc.wellFormed(t); err != nil {
+			log.Printf("ERROR: Transaction %s in block: %s\n", t.ID(), err.Error())
+			return false
+		}
+	}
+	if err := bc.validTransactions(index, b, uint64(len(chain))); err != nil {
+		log.Printf("ERROR: Block %x: %s\n", b.Hash(), err.Error())
+		return false
+	}
+	return bc.ValidProof(b.Header())
+}
 
-	for _, n := range bc.neighbors {
-		if n == fmt.Sprintf("%s", bc.config.HOST) {
+// validTransactions replays the transactions of b, the block at height, on
+// the confirmed state in index the way admissible checks them for the pool:
+// none may be confirmed already, each sender's nonces follow on from the
+// chain with a witness that authorizes the transaction, the sender can pay
+// for the value and the fee out of what is not time locked at height and
+// the block time, and the recipient can hold the value. The index is only
+// read.
+func (bc *Blockchain) validTransactions(index *chainIndex, b *Block, height uint64) error {
+	state := newBlockState(index, height, time.Unix(0, b.timestamp).Unix())
+	for _, t := range b.transactions {
+		id := t.ID()
+		if _, confirmed := index.blockHeight(id); confirmed {
+			return fmt.Errorf("duplicate transaction %s", id)
+		}
+		if t.value <= 0 || t.fee < 0 {
+			return fmt.Errorf("transaction %s: value %s or fee %s out of range", id, t.value, t.fee)
+		}
+		if t.senderBlockchainAddress == bc.config.MINING_SENDER {
+			if err := state.issue(t); err != nil {
+				return fmt.Errorf("transaction %s: %w", id, err)
+			}
 			continue
 		}
-		endpoint := fmt.Sprintf("%s/chain", n)
-		resp, err := http.Get(endpoint)
+		if next := state.nextNonce(t.senderBlockchainAddress); t.nonce != next {
+			return fmt.Errorf("transaction %s: invalid nonce %d, expected %d", id, t.nonce, next)
+		}
+		if err := t.witness.verify(t); err != nil {
+			return fmt.Errorf("transaction %s: %w", id, err)
+		}
+		if err := state.apply(t); err != nil {
+			return fmt.Errorf("transaction %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// blockState is the state of the accounts a block touches while its
+// transactions are applied one by one on top of the confirmed state in
+// index. Value a transaction earlier in the block locks for its recipient
+// counts as locked like the value the chain locks. Both block validation
+// and the miner go through it, so the miner never assembles a block the
+// validation rejects.
+type blockState struct {
+	index    *chainIndex
+	height   uint64
+	unixTime int64
+	balances map[string]utils.Amount
+	nonces   map[string]uint64
+	locked   map[string]utils.Amount
+}
+
+// newBlockState starts from index for the block at height with block time
+// unixTime.
+func newBlockState(index *chainIndex, height uint64, unixTime int64) *blockState {
+	return &blockState{
+		index:    index,
+		height:   height,
+		unixTime: unixTime,
+		balances: map[string]utils.Amount{},
+		nonces:   map[string]uint64{},
+		locked:   map[string]utils.Amount{},
+	}
+}
+
+func (s *blockState) balance(blockchainAddress string) utils.Amount {
+	if amount, ok := s.balances[blockchainAddress]; ok {
+		return amount
+	}
+	return s.index.confirmedBalance(blockchainAddress)
+}
+
+func (s *blockState) nextNonce(blockchainAddress string) uint64 {
+	if next, ok := s.nonces[blockchainAddress]; ok {
+		return next
+	}
+	return s.index.confirmedNonce(blockchainAddress)
+}
+
+// spendable is the balance of blockchainAddress less what is still locked.
+func (s *blockState) spendable(blockchainAddress string) (utils.Amount, error) {
+	spendable, err := s.balance(blockchainAddress).Sub(s.index.confirmedLocked(blockchainAddress, s.height, s.unixTime))
+	if err != nil {
+		return 0, err
+	}
+	return spendable.Sub(s.locked[blockchainAddress])
+}
+
+// check reports whether t, carrying its sender's next nonce, can be
+// applied: the sender can pay for it and the recipient can hold the value.
+func (s *blockState) check(t *Transaction) error {
+	cost, err := t.cost()
+	if err != nil {
+		return fmt.Errorf("cost: %w", err)
+	}
+	if spendable, err := s.spendable(t.senderBlockchainAddress); err != nil || spendable < cost {
+		return fmt.Errorf("not enough balance in a wallet")
+	}
+	if _, err := s.balance(t.recipientBlockchainAddress).Add(t.value); err != nil {
+		return fmt.Errorf("recipient balance: %w", err)
+	}
+	return nil
+}
+
+// apply checks t and moves its cost from the sender to the recipient.
+func (s *blockState) apply(t *Transaction) error {
+	if err := s.check(t); err != nil {
+		return err
+	}
+	cost, _ := t.cost()
+	s.balances[t.senderBlockchainAddress] = s.balance(t.senderBlockchainAddress) - cost
+	s.nonces[t.senderBlockchainAddress] = t.nonce + 1
+	return s.credit(t)
+}
+
+// issue credits the recipient of t with newly issued coins.
+func (s *blockState) issue(t *Transaction) error {
+	if _, err := t.cost(); err != nil {
+		return fmt.Errorf("cost: %w", err)
+	}
+	return s.credit(t)
+}
+
+func (s *blockState) credit(t *Transaction) error {
+	recipient := t.recipientBlockchainAddress
+	received, err := s.balance(recipient).Add(t.value)
+	if err != nil {
+		return fmt.Errorf("recipient balance: %w", err)
+	}
+	if t.Locked(s.height, s.unixTime) {
+		locked, err := s.locked[recipient].Add(t.value)
 		if err != nil {
-			log.Printf("ERROR: Get chain from %s\n", n)
-			continue
+			return fmt.Errorf("locked value: %w", err)
 		}
-		if resp.StatusCode == http.StatusOK {
-			var bcResp Blockchain
-			json.NewDecoder(resp.Body).Decode(&bcResp)
-
-			chain := bcResp.Chain()
-			if len(chain) > maxLength && bc.ValidChain(chain) {
-				maxLength = len(chain)
-				longestChain = chain
-			}
+		s.locked[recipient] = locked
+	}
+	s.balances[recipient] = received
+	return nil
+}
+
+// wellFormed checks that t belongs to our chain and that both of its
+// addresses are well formed, the sender of mining rewards being the only
+// exception.
+func (bc *Blockchain) wellFormed(t *Transaction) error {
+	if t.chainID != bc.config.CHAIN_ID {
+		return fmt.Errorf("foreign chain ID %q", t.chainID)
+	}
+	if t.senderBlockchainAddress != bc.config.MINING_SENDER {
+		if err := address.Validate(t.senderBlockchainAddress); err != nil {
+			return fmt.Errorf("sender: %w", err)
 		}
 	}
-	if longestChain != nil {
-		bc.chain = longestChain
-		log.Printf("INFO: Replace chain with the longest chain from neighbors\n")
+	if err := address.Validate(t.recipientBlockchainAddress); err != nil {
+		return fmt.Errorf("recipient: %w", err)
+	}
+	return nil
+}
+
+// ResolveConflicts syncs with every peer in turn and switches to a peer's
+// chain when it carries more cumulative proof of work, which is not
+// necessarily the longest one once difficulty changes over time. Only the
+// blocks after the common ancestor are downloaded.
+func (bc *Blockchain) ResolveConflicts() bool {
+	replaced := false
+	for _, n := range bc.Neighbors() {
+		if bc.syncFrom(n, -1) {
+			replaced = true
+		}
+	}
+	if replaced {
+		log.Printf("INFO: Replace chain with the heaviest chain from neighbors\n")
+		bc.mux.RLock()
+		tip, height := bc.lastBlock(), len(bc.chain)-1
+		bc.mux.RUnlock()
+		bc.announceBlock(tip, height)
 		return true
 	}
 	log.Printf("INFO: No conflicts found\n")
 	return false
 }
 
+// replaceChain switches to chain unless ours has become at least as heavy
+// in the meantime. A chain that only extends ours is appended block by
+// block, anything else rewrites the store.
+func (bc *Blockchain) replaceChain(chain []*Block) bool {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	if ChainWork(chain).Cmp(ChainWork(bc.chain)) <= 0 {
+		return false
+	}
+	fork := commonPrefix(bc.chain, chain)
+	if fork < len(bc.chain) {
+		if _, err := bc.reorganize(chain, fork); err != nil {
+			log.Printf("ERROR: Reorg at block %d: %s\n", fork, err.Error())
+			return false
+		}
+		bc.interruptSearch()
+		return true
+	}
+	for _, b := range chain[fork:] {
+		if _, err := bc.appendBlock(b.Header(), b.transactions); err != nil {
+			log.Printf("ERROR: Append block %x: %s\n", b.Hash(), err.Error())
+			break
+		}
+	}
+	if len(bc.chain) == fork {
+		return false
+	}
+	bc.interruptSearch()
+	return true
+}
+
+// commonPrefix returns how many blocks a and b share from the genesis on.
+func commonPrefix(a, b []*Block) int {
+	n := 0
+	for n < len(a) && n < len(b) && (a[n] == b[n] || a[n].Hash() == b[n].Hash()) {
+		n++
+	}
+	return n
+}
+
+// StartMining mines a block now and then one every MINING_TIMER until
+// StopMining is called.
 func (bc *Blockchain) StartMining() {
+	bc.mux.Lock()
+	if bc.autoMining {
+		bc.mux.Unlock()
+		return
+	}
+	bc.autoMining = true
+	bc.publishMiningState()
+	bc.mux.Unlock()
+	bc.autoMine()
+}
+
+func (bc *Blockchain) autoMine() {
 	log.Println("INFO: Start mining...")
 	bc.Mining()
-	bc.cancelMining = time.AfterFunc(bc.config.MINING_TIMER, bc.StartMining)
+
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	if bc.autoMining {
+		bc.cancelMining = time.AfterFunc(bc.config.MINING_TIMER, bc.autoMine)
+	}
+}
+
+// interruptSearch cancels a running nonce search, the caller holds bc.mux.
+func (bc *Blockchain) interruptSearch() {
+	if bc.cancelSearch != nil {
+		bc.cancelSearch()
+	}
 }
 
 func (bc *Blockchain) StopMining() {
+	bc.mux.Lock()
+	bc.autoMining = false
 	if bc.cancelMining != nil {
 		bc.cancelMining.Stop()
 		bc.cancelMining = nil
 	}
+	bc.interruptSearch()
+	bc.publishMiningState()
+	bc.mux.Unlock()
 	log.Println("INFO: Stop mining")
 }
 
+// Close stops the peer health checks and releases the block store.
+func (bc *Blockchain) Close() error {
+	bc.peers.Stop()
+	return bc.store.Close()
+}
+
 func (bc *Blockchain) ClearTransactionPool() {
-	bc.transactionPool = bc.transactionPool[:0]
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	transactions, _ := bc.pool.reset()
+	bc.pool.stats.Cleared += uint64(len(transactions))
+	bc.index.clearPending()
 }
 
 func (bc *Blockchain) LastBlock() *Block {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.lastBlock()
+}
+
+func (bc *Blockchain) lastBlock() *Block {
 	return bc.chain[len(bc.chain)-1]
 }
 
 func (bc *Blockchain) CopyTransactionPool() []*Transaction {
-	transactions := make([]*Transaction, len(bc.transactionPool))
-	for i, t := range bc.transactionPool {
-		transactions[i] = NewTransaction(t.senderBlockchainAddress, t.recipientBlockchainAddress, t.value)
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.copyTransactionPool()
+}
+
+func (bc *Blockchain) copyTransactionPool() []*Transaction {
+	transactions := make([]*Transaction, len(bc.pool.transactions))
+	for i, t := range bc.pool.transactions {
+		c := *t
+		transactions[i] = &c
 	}
 
 	return transactions
 }
 
-func (bc *Blockchain) CalculateTotalAmount(blockchainAddress string) float32 {
-	var totalAmount float32 = 0.0
-	for _, b := range bc.chain {
-		for _, t := range b.transactions {
-			value := t.value
-			if blockchainAddress == t.recipientBlockchainAddress {
-				totalAmount += value
-			}
-			if blockchainAddress == t.senderBlockchainAddress {
-				totalAmount -= value
+// CalculateTotalAmount returns the confirmed balance of blockchainAddress
+// plus whatever the transaction pool is about to add or take away.
+func (bc *Blockchain) CalculateTotalAmount(blockchainAddress string) utils.Amount {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.index.balance(blockchainAddress)
+}
+
+// SpendableAmount returns the part of CalculateTotalAmount that is not
+// time locked and can be spent in the next block.
+func (bc *Blockchain) SpendableAmount(blockchainAddress string) utils.Amount {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.spendable(blockchainAddress)
+}
+
+// NextNonce returns the nonce the next transaction from blockchainAddress
+// has to use.
+func (bc *Blockchain) NextNonce(blockchainAddress string) uint64 {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.index.nextNonce(blockchainAddress)
+}
+
+// AddressUsed reports whether blockchainAddress appears in any confirmed
+// or pending transaction, wallets rescan with it.
+func (bc *Blockchain) AddressUsed(blockchainAddress string) bool {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.index.used(blockchainAddress)
+}
+
+func (bc *Blockchain) hasTransaction(id string) bool {
+	if _, ok := bc.index.blockHeight(id); ok {
+		return true
+	}
+	return bc.pool.get(id) != nil
+}
+
+// LookupTransaction finds transaction id in the pool or the chain, it
+// returns nil when the transaction is unknown.
+func (bc *Blockchain) LookupTransaction(id string) *TransactionStatusResponse {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+
+	if t := bc.pool.get(id); t != nil {
+		return &TransactionStatusResponse{Transaction: t, Status: TransactionPending}
+	}
+	height, ok := bc.index.blockHeight(id)
+	if !ok {
+		return nil
+	}
+	b := bc.chain[height]
+	for _, t := range b.transactions {
+		if t.ID() == id {
+			return &TransactionStatusResponse{
+				Transaction: t,
+				Status:      TransactionConfirmed,
+				BlockHeight: &height,
+				BlockHash:   fmt.Sprintf("%x", b.Hash()),
 			}
 		}
 	}
+	return nil
+}
 
-	for _, t := range bc.transactionPool {
-		if blockchainAddress == t.senderBlockchainAddress {
-			totalAmount -= t.value
-		}
-		if blockchainAddress == t.recipientBlockchainAddress {
-			totalAmount += t.value
+// TransactionProof returns the Merkle inclusion proof of a confirmed
+// transaction, or nil while it is unknown or still pending.
+func (bc *Blockchain) TransactionProof(id string) *MerkleProof {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+
+	height, ok := bc.index.blockHeight(id)
+	if !ok {
+		return nil
+	}
+	b := bc.chain[height]
+	for i, t := range b.transactions {
+		if t.ID() == id {
+			proof, err := NewMerkleProof(b, height, i)
+			if err != nil {
+				return nil
+			}
+			return proof
 		}
 	}
-	return totalAmount
+	return nil
+}
+
+func (bc *Blockchain) MinerStats() MinerStats {
+	return bc.miner.Stats()
 }
 
 func (bc *Blockchain) BlockchainAddress() string {
 	return bc.blockchainAddress
 }
 
+// ChainID is the chain every transaction signs for, transactions of other
+// chains are rejected.
+func (bc *Blockchain) ChainID() string {
+	return bc.config.CHAIN_ID
+}
+
 func (bc *Blockchain) Neighbors() []string {
-	return bc.neighbors
+	return bc.peers.Peers()
+}
+
+func (bc *Blockchain) Peers() *PeerManager {
+	return bc.peers
 }
 
+// Chain returns a copy of the block list, blocks themselves are never
+// modified once appended.
 func (bc *Blockchain) Chain() []*Block {
-	return bc.chain
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return append([]*Block{}, bc.chain...)
 }
 
 func (bc *Blockchain) TransactionsPool() []*Transaction {
-	return bc.transactionPool
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return append([]*Transaction{}, bc.pool.transactions...)
 }
 
 func (bc *Blockchain) MarshalJSON() ([]byte, error) {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+
 	return json.Marshal(struct {
-		Blocks            []*Block                  `json:"chain"`
-		ChainLenght       int                       `json:"chain_length"`
-		TransactionPool   []*Transaction            `json:"transaction_pool"`
-		BlockchainAddress string                    `json:"blockchain_address"`
-		Port              uint16                    `json:"port"`
-		Host              string                    `json:"host"`
-		Mining            bool                      `json:"mining"`
-		MiningDifficulty  int                       `json:"mining_difficulty"`
-		MiningReward      float32                   `json:"mining_reward"`
-		Neighbors         []string                  `json:"neighbors"`
-		Wallets           map[string]*wallet.Wallet `json:"wallets"`
+		ChainID           string         `json:"chain_id"`
+		Blocks            []*Block       `json:"chain"`
+		ChainLenght       int            `json:"chain_length"`
+		TransactionPool   []*Transaction `json:"transaction_pool"`
+		BlockchainAddress string         `json:"blockchain_address"`
+		Port              uint16         `json:"port"`
+		Host              string         `json:"host"`
+		Mining            bool           `json:"mining"`
+		MiningDifficulty  int            `json:"mining_difficulty"`
+		MiningReward      utils.Amount   `json:"mining_reward"`
+		Neighbors         []string       `json:"neighbors"`
 	}{
+		ChainID:           bc.config.CHAIN_ID,
 		Blocks:            bc.chain,
 		ChainLenght:       len(bc.chain),
-		TransactionPool:   bc.transactionPool,
+		TransactionPool:   bc.pool.transactions,
 		BlockchainAddress: bc.blockchainAddress,
 		Host:              bc.config.HOST,
 		Port:              bc.port,
-		Mining:            bc.cancelMining != nil,
-		MiningDifficulty:  bc.config.MINING_DIFFICULTY,
+		Mining:            bc.autoMining,
+		MiningDifficulty:  bc.expectedDifficulty(bc.chain),
 		MiningReward:      bc.config.MINING_REWARD,
-		Neighbors:         bc.neighbors,
-		Wallets:           bc.wallets,
+		Neighbors:         bc.Neighbors(),
 	})
 }
 
@@ -353,6 +961,9 @@ func (bc *Blockchain) UnmarshalJSON(data []byte) error {
 }
 
 func (bc *Blockchain) Print() {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+
 	for i, block := range bc.chain {
 		fmt.Printf("%s Block %d %s\n", strings.Repeat("=", 25), i, strings.Repeat("=", 25))
 		block.Print()
@@ -0,0 +1,103 @@
+package block
+
+import (
+	"context"
+	"testing"
+
+	"github.com/EmilioCliff/learn-go/blockchain/utils"
+	"github.com/EmilioCliff/learn-go/blockchain/wallet"
+)
+
+func newTestBlockchain(t *testing.T) *Blockchain {
+	t.Helper()
+	return newTestBlockchainWithStore(t, NewMemoryStore())
+}
+
+func newTestBlockchainWithStore(t *testing.T, store Store) *Blockchain {
+	t.Helper()
+	t.Setenv("MINING_WORKERS", "2")
+	t.Setenv("MINER_PASSPHRASE", "miner-secret")
+	config, err := utils.LoadConfig(nil)
+	if err != nil {
+		t.Fatalf("load config: %s", err)
+	}
+	// Blocks of the lowest difficulty are mined in no time.
+	genesis := &Genesis{Timestamp: defaultGenesisTime, Difficulty: minDifficulty}
+	bc, err := NewBlockchainWithStore(wallet.NewWallet().BlockchainAddress(), config, store, nil, genesis)
+	if err != nil {
+		t.Fatalf("new blockchain: %s", err)
+	}
+	t.Cleanup(func() { bc.Close() })
+	return bc
+}
+
+// transfer is a transaction from w with its witness attached, the way the
+// pool keeps it.
+func transfer(bc *Blockchain, w *wallet.Wallet, recipient string, value utils.Amount, nonce uint64) *Transaction {
+	t := NewTransaction(bc.ChainID(), w.BlockchainAddress(), recipient, value, nonce)
+	s, _ := t.canonical().Sign(w.PrivateKey())
+	t.witness = NewWitness(w.PublicKey(), s)
+	return t
+}
+
+// nextBlock seals a block of transactions on top of bc's tip, after a
+// reward to miner.
+func nextBlock(t *testing.T, bc *Blockchain, miner string, transactions ...*Transaction) *Block {
+	t.Helper()
+	chain := bc.Chain()
+	reward := bc.config.MINING_REWARD
+	for _, tx := range transactions {
+		reward += tx.fee
+	}
+	transactions = append([]*Transaction{NewTransaction(bc.ChainID(), bc.config.MINING_SENDER, miner, reward, uint64(len(chain)))}, transactions...)
+	header := NewHeader(chain[len(chain)-1].Hash(), bc.expectedDifficulty(chain), transactions)
+	sealed, err := bc.ProofOfWork(context.Background(), header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewBlock(sealed, transactions)
+}
+
+// receive hands b to bc as if a peer announced it.
+func receive(bc *Blockchain, b *Block) bool {
+	return bc.ReceiveBlock(b, bc.BlockCount(), "http://127.0.0.1:1")
+}
+
+func TestBlockWitnesses(t *testing.T) {
+	bc := newTestBlockchain(t)
+	victim, thief := wallet.NewWallet(), wallet.NewWallet()
+	if !receive(bc, nextBlock(t, bc, victim.BlockchainAddress())) {
+		t.Fatal("block funding the victim was rejected")
+	}
+
+	unsigned := NewTransaction(bc.ChainID(), victim.BlockchainAddress(), thief.BlockchainAddress(), utils.Coin/2, 0)
+	if receive(bc, nextBlock(t, bc, thief.BlockchainAddress(), unsigned)) {
+		t.Fatal("block with an unsigned transfer was accepted")
+	}
+
+	stolen := transfer(bc, thief, thief.BlockchainAddress(), utils.Coin/2, 0)
+	stolen.senderBlockchainAddress = victim.BlockchainAddress()
+	if receive(bc, nextBlock(t, bc, thief.BlockchainAddress(), stolen)) {
+		t.Fatal("block with a transfer signed by another key was accepted")
+	}
+
+	// Swapping the witness after the fact breaks the witness root.
+	signed := transfer(bc, victim, thief.BlockchainAddress(), utils.Coin/2, 0)
+	b := nextBlock(t, bc, thief.BlockchainAddress(), signed)
+	m, _ := b.MarshalJSON()
+	var relayed Block
+	if err := relayed.UnmarshalJSON(m); err != nil {
+		t.Fatal(err)
+	}
+	relayed.transactions[1].witness = nil
+	if receive(bc, &relayed) {
+		t.Fatal("block stripped of a witness was accepted")
+	}
+
+	if !receive(bc, b) {
+		t.Fatal("block with a signed transfer was rejected")
+	}
+	if got := bc.CalculateTotalAmount(victim.BlockchainAddress()); got != bc.config.MINING_REWARD-utils.Coin/2 {
+		t.Fatalf("victim balance = %s", got)
+	}
+}
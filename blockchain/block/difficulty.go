@@ -0,0 +1,93 @@
+package block
+
+import (
+	"fmt"
+	"math/big"
+	"slices"
+	"time"
+)
+
+const (
+	// Difficulty is counted in leading zero hex digits of the header hash,
+	// so one step makes a block 16 times harder to find.
+	minDifficulty = 1
+	maxDifficulty = 64
+	// retargetFactor is the geometric middle of one step: a window mined
+	// more than 4x off target moves the difficulty by one.
+	retargetFactor = 4
+	// A block has to be stamped after the median of the last
+	// medianTimeBlocks timestamps and at most maxFutureBlockTime ahead of
+	// the clock of the node checking it, which keeps miners from bending
+	// the retarget windows with made up times.
+	medianTimeBlocks   = 11
+	maxFutureBlockTime = 2 * time.Hour
+)
+
+// expectedDifficulty returns the difficulty the block following chain has
+// to be mined at. It only changes every RETARGET_INTERVAL blocks, based on
+// how long the last interval took compared to TARGET_BLOCK_TIME. The genesis
+// block is left out of the windows since its timestamp says nothing about
+// mining speed.
+func (bc *Blockchain) expectedDifficulty(chain []*Block) int {
+	if len(chain) == 0 {
+		return bc.genesis.difficulty
+	}
+	last := chain[len(chain)-1]
+	height := len(chain)
+	interval := bc.config.RETARGET_INTERVAL
+	if interval < 2 || height%interval != 0 || height <= interval {
+		return last.difficulty
+	}
+
+	first := chain[height-interval]
+	actual := time.Duration(last.timestamp - first.timestamp)
+	expected := bc.config.TARGET_BLOCK_TIME * time.Duration(interval-1)
+
+	difficulty := last.difficulty
+	switch {
+	case actual*retargetFactor < expected:
+		difficulty++
+	case actual > expected*retargetFactor:
+		difficulty--
+	}
+	return clampDifficulty(difficulty)
+}
+
+// medianTimePast is the median timestamp of the last medianTimeBlocks
+// blocks of chain.
+func medianTimePast(chain []*Block) int64 {
+	recent := chain[max(len(chain)-medianTimeBlocks, 0):]
+	timestamps := make([]int64, len(recent))
+	for i, b := range recent {
+		timestamps[i] = b.timestamp
+	}
+	slices.Sort(timestamps)
+	return timestamps[len(timestamps)/2]
+}
+
+// validTimestamp checks that b, the block following chain, is stamped after
+// the median time past of chain and no further than maxFutureBlockTime
+// past now.
+func validTimestamp(chain []*Block, b *Block, now time.Time) error {
+	if median := medianTimePast(chain); b.timestamp <= median {
+		return fmt.Errorf("timestamp %d is not after the median time past %d", b.timestamp, median)
+	}
+	if limit := now.Add(maxFutureBlockTime).UnixNano(); b.timestamp > limit {
+		return fmt.Errorf("timestamp %d is more than %s in the future", b.timestamp, maxFutureBlockTime)
+	}
+	return nil
+}
+
+func clampDifficulty(d int) int {
+	return min(max(d, minDifficulty), maxDifficulty)
+}
+
+// ChainWork is the expected number of hashes needed to produce chain, each
+// block contributing 16^difficulty.
+func ChainWork(chain []*Block) *big.Int {
+	work := new(big.Int)
+	for _, b := range chain {
+		work.Add(work, new(big.Int).Lsh(big.NewInt(1), uint(4*clampDifficulty(b.difficulty))))
+	}
+	return work
+}
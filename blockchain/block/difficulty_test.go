@@ -0,0 +1,104 @@
+package block
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+// spacedChain returns n blocks at difficulty, the first one at start and
+// the others spacing apart.
+func spacedChain(n, difficulty int, start time.Time, spacing time.Duration) []*Block {
+	chain := make([]*Block, n)
+	for i := range chain {
+		chain[i] = &Block{timestamp: start.Add(time.Duration(i) * spacing).UnixNano(), difficulty: difficulty}
+	}
+	return chain
+}
+
+func TestExpectedDifficulty(t *testing.T) {
+	bc := newTestBlockchain(t)
+	bc.config.RETARGET_INTERVAL = 5
+	bc.config.TARGET_BLOCK_TIME = 10 * time.Second
+	start := time.Unix(1_700_000_000, 0)
+
+	for _, tt := range []struct {
+		name       string
+		blocks     int
+		difficulty int
+		spacing    time.Duration
+		want       int
+	}{
+		{"on target", 10, 3, 10 * time.Second, 3},
+		{"a little fast", 10, 3, 5 * time.Second, 3},
+		{"fast", 10, 3, 2 * time.Second, 4},
+		{"a little slow", 10, 3, 30 * time.Second, 3},
+		{"slow", 10, 3, 50 * time.Second, 2},
+		{"between retargets", 9, 3, time.Second, 3},
+		{"first window", 5, 3, time.Second, 3},
+		{"at the minimum", 10, minDifficulty, time.Minute, minDifficulty},
+		{"at the maximum", 10, maxDifficulty, time.Millisecond, maxDifficulty},
+	} {
+		chain := spacedChain(tt.blocks, tt.difficulty, start, tt.spacing)
+		if got := bc.expectedDifficulty(chain); got != tt.want {
+			t.Errorf("%s: difficulty %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	// The genesis block is outside of every window, however old it is.
+	chain := spacedChain(10, 3, start, 10*time.Second)
+	chain[0].timestamp = 0
+	if got := bc.expectedDifficulty(chain); got != 3 {
+		t.Errorf("old genesis block: difficulty %d, want 3", got)
+	}
+
+	bc.config.RETARGET_INTERVAL = 0
+	if got := bc.expectedDifficulty(spacedChain(10, 3, start, time.Millisecond)); got != 3 {
+		t.Errorf("retargeting off: difficulty %d, want 3", got)
+	}
+}
+
+func TestValidTimestamp(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	// The last 11 blocks are a minute apart and end at now, so the median
+	// time past is five minutes ago. Older blocks do not count.
+	chain := spacedChain(20, 1, now.Add(-19*time.Minute), time.Minute)
+	median := now.Add(-5 * time.Minute)
+	if got := medianTimePast(chain); got != median.UnixNano() {
+		t.Fatalf("median time past %d, want %d", got, median.UnixNano())
+	}
+
+	for _, tt := range []struct {
+		name  string
+		stamp time.Time
+		valid bool
+	}{
+		{"at the median", median, false},
+		{"before the median", median.Add(-time.Hour), false},
+		{"after the median", median.Add(time.Nanosecond), true},
+		{"now", now, true},
+		{"two hours ahead", now.Add(maxFutureBlockTime), true},
+		{"over two hours ahead", now.Add(maxFutureBlockTime + time.Second), false},
+	} {
+		err := validTimestamp(chain, &Block{timestamp: tt.stamp.UnixNano()}, now)
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+
+	genesis := spacedChain(1, 1, now, 0)
+	if err := validTimestamp(genesis, &Block{timestamp: now.Add(time.Second).UnixNano()}, now); err != nil {
+		t.Errorf("block after the genesis block: %v", err)
+	}
+}
+
+func TestChainWork(t *testing.T) {
+	chain := []*Block{{difficulty: 1}, {difficulty: 2}, {difficulty: 0}}
+	// 16 + 256 + 16, difficulty 0 counts as the minimum.
+	if got := ChainWork(chain); got.Cmp(big.NewInt(288)) != 0 {
+		t.Fatalf("chain work %s, want 288", got)
+	}
+	if ChainWork(chain[:2]).Cmp(ChainWork(chain[1:2])) <= 0 {
+		t.Fatal("a longer chain does not have more work")
+	}
+}
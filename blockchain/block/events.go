@@ -0,0 +1,182 @@
+package block
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Event types published to subscribers.
+const (
+	EventBlock       = "block"
+	EventTransaction = "transaction"
+	EventReorg       = "reorg"
+	EventMining      = "mining"
+)
+
+// subscriberBuffer is how many events a subscriber can fall behind before
+// it starts missing them.
+const subscriberBuffer = 64
+
+// Event is one change to the chain, the pool or the mining state. Data is a
+// *BlockResponse for a new block, a *TransactionStatusResponse for a new
+// pending transaction, a ReorgEvent or a MiningState.
+type Event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data"`
+
+	// addresses are the senders and recipients the event concerns, events
+	// without any concern everyone.
+	addresses []string
+}
+
+// MiningState tells whether the node mines on its own and whether a nonce
+// search is running for the block at Height.
+type MiningState struct {
+	AutoMining bool `json:"auto_mining"`
+	Searching  bool `json:"searching"`
+	Height     int  `json:"height"`
+}
+
+// EventFilter narrows a subscription down to some event types and to the
+// block and transaction events that send to or from some addresses. Empty
+// fields match everything.
+type EventFilter struct {
+	Types     []string
+	Addresses []string
+}
+
+func (f EventFilter) match(e Event) bool {
+	if len(f.Types) > 0 && !slices.Contains(f.Types, e.Type) {
+		return false
+	}
+	if len(f.Addresses) == 0 || len(e.addresses) == 0 {
+		return true
+	}
+	for _, a := range e.addresses {
+		if slices.Contains(f.Addresses, a) {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscription receives the events matching its filter until it is closed.
+// A subscriber that falls more than subscriberBuffer events behind misses
+// the ones that do not fit, publishing never waits for it.
+type Subscription struct {
+	events chan Event
+	filter EventFilter
+	bus    *eventBus
+}
+
+// Events is closed once the subscription is.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+func (s *Subscription) Close() {
+	s.bus.unsubscribe(s)
+}
+
+// eventBus fans events out to the subscriptions. It has its own lock so
+// events can be published with or without Blockchain.mux held.
+type eventBus struct {
+	mux         sync.Mutex
+	subscribers map[*Subscription]struct{}
+	closed      bool
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subscribers: make(map[*Subscription]struct{})}
+}
+
+func (eb *eventBus) subscribe(filter EventFilter) *Subscription {
+	s := &Subscription{events: make(chan Event, subscriberBuffer), filter: filter, bus: eb}
+	eb.mux.Lock()
+	defer eb.mux.Unlock()
+	if eb.closed {
+		close(s.events)
+		return s
+	}
+	eb.subscribers[s] = struct{}{}
+	return s
+}
+
+func (eb *eventBus) unsubscribe(s *Subscription) {
+	eb.mux.Lock()
+	defer eb.mux.Unlock()
+	if _, ok := eb.subscribers[s]; ok {
+		delete(eb.subscribers, s)
+		close(s.events)
+	}
+}
+
+// close ends every subscription, present and future.
+func (eb *eventBus) close() {
+	eb.mux.Lock()
+	defer eb.mux.Unlock()
+	eb.closed = true
+	for s := range eb.subscribers {
+		delete(eb.subscribers, s)
+		close(s.events)
+	}
+}
+
+func (eb *eventBus) publish(e Event) {
+	e.Time = time.Now()
+	eb.mux.Lock()
+	defer eb.mux.Unlock()
+	for s := range eb.subscribers {
+		if !s.filter.match(e) {
+			continue
+		}
+		select {
+		case s.events <- e:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a subscription to the events matching filter, the
+// caller closes it when done.
+func (bc *Blockchain) Subscribe(filter EventFilter) *Subscription {
+	return bc.events.subscribe(filter)
+}
+
+// CloseSubscriptions ends every subscription, so event streams let a
+// shutting down server finish.
+func (bc *Blockchain) CloseSubscriptions() {
+	bc.events.close()
+}
+
+// publishBlock publishes b, appended at height. The caller holds bc.mux.
+func (bc *Blockchain) publishBlock(b *Block, height int) {
+	addresses := []string{}
+	for _, t := range b.transactions {
+		addresses = append(addresses, t.senderBlockchainAddress, t.recipientBlockchainAddress)
+	}
+	bc.events.publish(Event{
+		Type:      EventBlock,
+		Data:      &BlockResponse{Block: b, Height: height, Confirmations: len(bc.chain) - height},
+		addresses: addresses,
+	})
+}
+
+func (bc *Blockchain) publishTransaction(t *Transaction) {
+	bc.events.publish(Event{
+		Type:      EventTransaction,
+		Data:      &TransactionStatusResponse{Transaction: t, Status: TransactionPending},
+		addresses: []string{t.senderBlockchainAddress, t.recipientBlockchainAddress},
+	})
+}
+
+// publishMiningState publishes whether the node is mining. The caller holds
+// bc.mux.
+func (bc *Blockchain) publishMiningState() {
+	bc.events.publish(Event{
+		Type: EventMining,
+		Data: MiningState{AutoMining: bc.autoMining, Searching: bc.cancelSearch != nil, Height: len(bc.chain)},
+	})
+}
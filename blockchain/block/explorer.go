@@ -0,0 +1,151 @@
+package block
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/EmilioCliff/learn-go/blockchain/utils"
+)
+
+// maxTransactionsPerPage caps how many transactions of an address
+// GET /address/:blockchain_address/transactions returns at once.
+const maxTransactionsPerPage = 100
+
+// BlockResponse is a block with its place in the chain.
+type BlockResponse struct {
+	Block         *Block `json:"block"`
+	Height        int    `json:"height"`
+	Confirmations int    `json:"confirmations"`
+}
+
+// AddressTransactionsResponse is one page of the transactions sending to or
+// from an address, the pending ones first, then the confirmed ones newest
+// first. Total counts them all.
+type AddressTransactionsResponse struct {
+	BlockchainAddress string                       `json:"blockchain_address"`
+	Total             int                          `json:"total"`
+	Offset            int                          `json:"offset"`
+	Limit             int                          `json:"limit"`
+	Transactions      []*TransactionStatusResponse `json:"transactions"`
+}
+
+// ChainStats summarizes the chain. TotalSupply is every coin issued by
+// mining rewards minus the fees burned, AverageBlockTime the mean time in
+// seconds between the blocks after genesis.
+type ChainStats struct {
+	ChainID          string       `json:"chain_id"`
+	GenesisHash      string       `json:"genesis_hash"`
+	Height           int          `json:"height"`
+	LastBlockHash    string       `json:"last_block_hash"`
+	LastBlockTime    int64        `json:"last_block_time"`
+	Difficulty       int          `json:"difficulty"`
+	TotalSupply      utils.Amount `json:"total_supply"`
+	Transactions     int          `json:"transactions"`
+	PendingCount     int          `json:"pending_transactions"`
+	AverageBlockTime float64      `json:"average_block_time"`
+	TargetBlockTime  float64      `json:"target_block_time"`
+}
+
+// BlockCount is the number of blocks in the chain, genesis included.
+func (bc *Blockchain) BlockCount() int {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return len(bc.chain)
+}
+
+// BlockByHeight returns the block at height, nil past the tip.
+func (bc *Blockchain) BlockByHeight(height int) *BlockResponse {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	if height < 0 || height >= len(bc.chain) {
+		return nil
+	}
+	return bc.blockResponse(height)
+}
+
+// BlockByHash returns the block with hash, nil when it is not in our chain.
+func (bc *Blockchain) BlockByHash(hash [32]byte) *BlockResponse {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	height, ok := bc.index.height(hash)
+	if !ok {
+		return nil
+	}
+	return bc.blockResponse(height)
+}
+
+func (bc *Blockchain) blockResponse(height int) *BlockResponse {
+	return &BlockResponse{
+		Block:         bc.chain[height],
+		Height:        height,
+		Confirmations: len(bc.chain) - height,
+	}
+}
+
+// AddressTransactions returns up to limit transactions sending to or from
+// blockchainAddress, skipping the first offset of them.
+func (bc *Blockchain) AddressTransactions(blockchainAddress string, offset, limit int) *AddressTransactionsResponse {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	if limit <= 0 || limit > maxTransactionsPerPage {
+		limit = maxTransactionsPerPage
+	}
+	offset = max(offset, 0)
+
+	pending := []*Transaction{}
+	for _, t := range bc.pool.transactions {
+		if t.senderBlockchainAddress == blockchainAddress || t.recipientBlockchainAddress == blockchainAddress {
+			pending = append(pending, t)
+		}
+	}
+	confirmed := bc.index.confirmedTransactions(blockchainAddress)
+
+	page := &AddressTransactionsResponse{
+		BlockchainAddress: blockchainAddress,
+		Total:             len(pending) + len(confirmed),
+		Offset:            offset,
+		Limit:             limit,
+		Transactions:      []*TransactionStatusResponse{},
+	}
+	for i := offset; i < page.Total && len(page.Transactions) < limit; i++ {
+		if i < len(pending) {
+			page.Transactions = append(page.Transactions, &TransactionStatusResponse{Transaction: pending[i], Status: TransactionPending})
+			continue
+		}
+		ref := confirmed[len(confirmed)-1-(i-len(pending))]
+		b := bc.chain[ref.height]
+		page.Transactions = append(page.Transactions, &TransactionStatusResponse{
+			Transaction: b.transactions[ref.position],
+			Status:      TransactionConfirmed,
+			BlockHeight: &ref.height,
+			BlockHash:   fmt.Sprintf("%x", b.Hash()),
+		})
+	}
+	return page
+}
+
+// Stats returns the chain summary.
+func (bc *Blockchain) Stats() ChainStats {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	last := bc.lastBlock()
+	stats := ChainStats{
+		ChainID:         bc.config.CHAIN_ID,
+		GenesisHash:     fmt.Sprintf("%x", bc.genesis.Hash()),
+		Height:          len(bc.chain) - 1,
+		LastBlockHash:   fmt.Sprintf("%x", last.Hash()),
+		LastBlockTime:   last.timestamp,
+		Difficulty:      bc.expectedDifficulty(bc.chain),
+		TotalSupply:     bc.index.supply,
+		Transactions:    len(bc.index.transactions),
+		PendingCount:    len(bc.pool.transactions),
+		TargetBlockTime: bc.config.TARGET_BLOCK_TIME.Seconds(),
+	}
+	// The genesis timestamp is set by the genesis file, so the average runs
+	// from the first mined block.
+	if n := len(bc.chain); n > 2 {
+		elapsed := time.Duration(last.timestamp - bc.chain[1].timestamp)
+		stats.AverageBlockTime = elapsed.Seconds() / float64(n-2)
+	}
+	return stats
+}
@@ -0,0 +1,77 @@
+package block
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/EmilioCliff/learn-go/blockchain/utils"
+)
+
+// selectTransactions picks the pool transactions for the next block, the
+// highest fee rates first, within MAX_BLOCK_SIZE minus reserved bytes.
+// A transaction is only picked after the ones before it from the same
+// sender and when the sender can pay for it from what is spendable at the
+// block timestamp, checked like validTransactions checks the block. The
+// caller holds bc.mux.
+func (bc *Blockchain) selectTransactions(reserved int, timestamp int64) []*Transaction {
+	limit := bc.config.MAX_BLOCK_SIZE - reserved
+	state := newBlockState(bc.index, uint64(len(bc.chain)), time.Unix(0, timestamp).Unix())
+
+	selected := []*Transaction{}
+	taken := make([]bool, len(bc.pool.transactions))
+	size := 0
+	for progress := true; progress; {
+		progress = false
+		for i, t := range bc.pool.transactions {
+			if taken[i] || t.nonce != state.nextNonce(t.senderBlockchainAddress) || size+t.Size() > limit {
+				continue
+			}
+			if err := state.apply(t); err != nil {
+				continue
+			}
+			size += t.Size()
+			taken[i] = true
+			c := *t
+			selected = append(selected, &c)
+			progress = true
+		}
+	}
+	return selected
+}
+
+// validRewards checks that the only transaction from MINING_SENDER in
+// transactions is the first one and that it pays no more than
+// MINING_REWARD plus the fees of the others.
+func (bc *Blockchain) validRewards(transactions []*Transaction) error {
+	var reward, fees utils.Amount
+	for i, t := range transactions {
+		if t.senderBlockchainAddress != bc.config.MINING_SENDER {
+			var err error
+			if fees, err = fees.Add(t.fee); err != nil {
+				return fmt.Errorf("fees: %w", err)
+			}
+			continue
+		}
+		if i > 0 {
+			return fmt.Errorf("mining reward %s is not the first transaction", t.ID())
+		}
+		reward = t.value
+	}
+	limit, err := bc.config.MINING_REWARD.Add(fees)
+	if err != nil {
+		return fmt.Errorf("mining reward: %w", err)
+	}
+	if reward > limit {
+		return fmt.Errorf("mining reward %s exceeds %s plus %s in fees", reward, bc.config.MINING_REWARD, fees)
+	}
+	return nil
+}
+
+// blockSize is the sum of the sizes of transactions.
+func blockSize(transactions []*Transaction) int {
+	size := 0
+	for _, t := range transactions {
+		size += t.Size()
+	}
+	return size
+}
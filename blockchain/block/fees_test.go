@@ -0,0 +1,41 @@
+package block
+
+import (
+	"testing"
+	"time"
+
+	"github.com/EmilioCliff/learn-go/blockchain/utils"
+	"github.com/EmilioCliff/learn-go/blockchain/wallet"
+)
+
+func TestSelectTransactionsSkipsLockedValue(t *testing.T) {
+	bc := newTestBlockchain(t)
+	alice, bob, carol := wallet.NewWallet(), wallet.NewWallet(), wallet.NewWallet()
+	if !receive(bc, nextBlock(t, bc, alice.BlockchainAddress())) {
+		t.Fatal("block funding alice was rejected")
+	}
+	locked := NewTransaction(bc.ChainID(), alice.BlockchainAddress(), carol.BlockchainAddress(), utils.Coin/10, 0)
+	locked.unlockHeight = 100
+	s, _ := locked.canonical().Sign(alice.PrivateKey())
+	locked.witness = NewWitness(alice.PublicKey(), s)
+	if !receive(bc, nextBlock(t, bc, bob.BlockchainAddress(), locked)) {
+		t.Fatal("block locking value for carol was rejected")
+	}
+
+	spendLocked := transfer(bc, carol, bob.BlockchainAddress(), utils.Coin/20, 0)
+	spendFree := transfer(bc, alice, bob.BlockchainAddress(), utils.Coin/20, 1)
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	// The pool admits no transaction spending locked value, so put them
+	// there directly.
+	bc.pool.transactions = append(bc.pool.transactions, spendLocked, spendFree)
+
+	selected := bc.selectTransactions(0, time.Now().UnixNano())
+	if len(selected) != 1 || selected[0].ID() != spendFree.ID() {
+		t.Fatalf("selected %d transactions, want only %s", len(selected), spendFree.ID())
+	}
+	b := NewBlock(&BlockHeader{Timestamp: time.Now().UnixNano()}, selected)
+	if err := bc.validTransactions(bc.index, b, uint64(len(bc.chain))); err != nil {
+		t.Fatalf("selected transactions are invalid: %s", err)
+	}
+}
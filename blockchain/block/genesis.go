@@ -0,0 +1,121 @@
+package block
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/EmilioCliff/learn-go/blockchain/address"
+	"github.com/EmilioCliff/learn-go/blockchain/utils"
+)
+
+// defaultGenesisTime stamps the genesis block of nodes without a
+// GENESIS_FILE.
+var defaultGenesisTime = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// defaultGenesisDifficulty is the difficulty of the genesis block of nodes
+// without a GENESIS_FILE, where retargeting starts from.
+const defaultGenesisDifficulty = 3
+
+// Genesis describes the first block of a chain, read from GENESIS_FILE.
+// Nodes started from the same file build the same genesis block, whose hash
+// identifies the chain: they only accept chains and peers that start from
+// it. The addresses in Allocations start out funded and Difficulty is the
+// one retargeting starts from. ChainID defaults to CHAIN_ID and must match
+// it when set.
+type Genesis struct {
+	Timestamp   time.Time               `json:"timestamp"`
+	ChainID     string                  `json:"chain_id,omitempty"`
+	Difficulty  int                     `json:"difficulty,omitempty"`
+	Allocations map[string]utils.Amount `json:"allocations"`
+}
+
+// DefaultGenesis is the genesis of nodes without a GENESIS_FILE, a block
+// without allocations at a fixed time and difficulty.
+func DefaultGenesis() *Genesis {
+	return &Genesis{Timestamp: defaultGenesisTime, Difficulty: defaultGenesisDifficulty}
+}
+
+func LoadGenesis(path string) (*Genesis, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var g Genesis
+	if err := json.Unmarshal(data, &g); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := g.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &g, nil
+}
+
+func (g *Genesis) Save(path string) error {
+	m, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(m, '\n'), 0o644)
+}
+
+// Validate checks that the genesis has a timestamp and a difficulty in
+// range, and only allocates positive amounts to valid addresses.
+func (g *Genesis) Validate() error {
+	if g.Timestamp.IsZero() {
+		return errors.New("genesis timestamp is missing")
+	}
+	if g.Difficulty == 0 {
+		return errors.New("genesis difficulty is missing")
+	}
+	if g.Difficulty < minDifficulty || g.Difficulty > maxDifficulty {
+		return fmt.Errorf("genesis difficulty %d is not between %d and %d", g.Difficulty, minDifficulty, maxDifficulty)
+	}
+	var total utils.Amount
+	for a, amount := range g.Allocations {
+		if err := address.Validate(a); err != nil {
+			return fmt.Errorf("allocation to %q: %w", a, err)
+		}
+		if amount <= 0 {
+			return fmt.Errorf("allocation to %s: amount %s is not positive", a, amount)
+		}
+		var err error
+		if total, err = total.Add(amount); err != nil {
+			return fmt.Errorf("allocations: %w", err)
+		}
+	}
+	return nil
+}
+
+// withChainID returns a copy of g on the chain CHAIN_ID of config. A
+// genesis naming another chain is an error rather than a node quietly
+// joining a chain it was not configured for.
+func (g *Genesis) withChainID(config utils.Config) (*Genesis, error) {
+	c := *g
+	if c.ChainID == "" {
+		c.ChainID = config.CHAIN_ID
+	}
+	if c.ChainID != config.CHAIN_ID {
+		return nil, fmt.Errorf("genesis is for chain %q but CHAIN_ID is %q: set CHAIN_ID=%s", c.ChainID, config.CHAIN_ID, c.ChainID)
+	}
+	return &c, nil
+}
+
+// block builds the genesis block: the allocations are paid by
+// miningSender, in the order of their addresses so every node lists them
+// the same way, and everything is stamped with the genesis timestamp.
+func (g *Genesis) block(miningSender string) *Block {
+	transactions := []*Transaction{}
+	for i, a := range slices.Sorted(maps.Keys(g.Allocations)) {
+		t := NewTransaction(g.ChainID, miningSender, a, g.Allocations[a], uint64(i))
+		t.timestamp = g.Timestamp.Unix()
+		transactions = append(transactions, t)
+	}
+	header := NewHeader([32]byte{}, g.Difficulty, transactions)
+	header.Timestamp = g.Timestamp.UnixNano()
+	return NewBlock(header, transactions)
+}
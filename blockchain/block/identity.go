@@ -0,0 +1,196 @@
+package block
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/EmilioCliff/learn-go/blockchain/address"
+	"github.com/EmilioCliff/learn-go/blockchain/utils"
+)
+
+// Headers of a signed peer request.
+const (
+	HeaderNodeKey       = "X-Node-Key"
+	HeaderNodeTimestamp = "X-Node-Timestamp"
+	HeaderNodeSignature = "X-Node-Signature"
+)
+
+// maxClockSkew is how far the timestamp of a signed peer request may be
+// from our clock. Signatures are remembered for longer than that, so a
+// request cannot be replayed.
+const maxClockSkew = time.Minute
+
+var ErrUnsignedRequest = errors.New("request is not signed by a node")
+
+// halfOrder is half the order of P-256. (r, s) and (r, n-s) are both valid
+// signatures of a digest, so only the one with s at most halfOrder is
+// accepted, which makes the signature of a request unique.
+var halfOrder = new(big.Int).Rsh(elliptic.P256().Params().N, 1)
+
+// NodeKey is the identity of a node, the key it signs its requests to peers
+// with.
+type NodeKey struct {
+	privateKey *ecdsa.PrivateKey
+}
+
+func NewNodeKey() (*NodeKey, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &NodeKey{privateKey: privateKey}, nil
+}
+
+// LoadNodeKey reads the PEM encoded key at path, generating and saving a new
+// one the first time.
+func LoadNodeKey(path string) (*NodeKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		nk, err := NewNodeKey()
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalECPrivateKey(nk.privateKey)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return nil, err
+		}
+		return nk, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600)
+	}
+	if err != nil {
+		return nil, err
+	}
+	b, _ := pem.Decode(data)
+	if b == nil || b.Type != "EC PRIVATE KEY" {
+		return nil, fmt.Errorf("%s: not a PEM encoded EC private key", path)
+	}
+	privateKey, err := x509.ParseECPrivateKey(b.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &NodeKey{privateKey: privateKey}, nil
+}
+
+// NodeInfo identifies a node: its ID, its node key, the URL it is
+// reachable at and its network.
+type NodeInfo struct {
+	ID        string `json:"node_id"`
+	PublicKey string `json:"public_key"`
+	URL       string `json:"url"`
+	Network
+}
+
+// PublicKey is the public key in the wire format of wallet keys, both
+// coordinates in hex.
+func (nk *NodeKey) PublicKey() string {
+	return fmt.Sprintf("%064x%064x", nk.privateKey.X.Bytes(), nk.privateKey.Y.Bytes())
+}
+
+// ID is the hash of the public key in hex, a short name for the node.
+func (nk *NodeKey) ID() string {
+	return hex.EncodeToString(address.PublicKeyHash(&nk.privateKey.PublicKey))
+}
+
+// SignRequest signs the method, URI, body and the current time of req,
+// which must not have been sent yet.
+func (nk *NodeKey) SignRequest(req *http.Request) error {
+	body, err := requestBody(req)
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+	h := requestDigest(req.Method, req.URL.RequestURI(), timestamp, body)
+	r, s, err := ecdsa.Sign(rand.Reader, nk.privateKey, h[:])
+	if err != nil {
+		return err
+	}
+	if s.Cmp(halfOrder) > 0 {
+		s.Sub(elliptic.P256().Params().N, s)
+	}
+	req.Header.Set(HeaderNodeKey, nk.PublicKey())
+	req.Header.Set(HeaderNodeTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderNodeSignature, (&utils.Signature{R: r, S: s}).String())
+	return nil
+}
+
+// VerifyRequest checks the signature of req, a request received at now, and
+// returns the public key of the node that signed it. The body is read and
+// put back for the handler.
+func VerifyRequest(req *http.Request, now time.Time) (string, error) {
+	publicKey := req.Header.Get(HeaderNodeKey)
+	signature := req.Header.Get(HeaderNodeSignature)
+	timestamp, err := strconv.ParseInt(req.Header.Get(HeaderNodeTimestamp), 10, 64)
+	if err != nil || !utils.IsBigIntTupleString(publicKey) || !utils.IsBigIntTupleString(signature) {
+		return "", ErrUnsignedRequest
+	}
+	if skew := now.Sub(time.Unix(timestamp, 0)); skew > maxClockSkew || skew < -maxClockSkew {
+		return "", fmt.Errorf("request signed %s away from our clock", skew.Round(time.Second))
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return "", err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+
+	key := utils.PublicKeyFromString(publicKey)
+	if _, err := key.ECDH(); err != nil {
+		return "", errors.New("invalid node key")
+	}
+	s := utils.SignatureFromString(signature)
+	if s.S.Cmp(halfOrder) > 0 {
+		return "", errors.New("request signature is not in low-S form")
+	}
+	h := requestDigest(req.Method, req.URL.RequestURI(), timestamp, body)
+	if !ecdsa.Verify(key, h[:], s.R, s.S) {
+		return "", errors.New("invalid request signature")
+	}
+	return publicKey, nil
+}
+
+// requestDigest is the SHA-256 of the method, the URI and the timestamp,
+// each length prefixed, followed by the SHA-256 of the body.
+func requestDigest(method, uri string, timestamp int64, body []byte) [32]byte {
+	b := []byte{}
+	for _, s := range []string{method, uri} {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+		b = append(b, s...)
+	}
+	b = binary.BigEndian.AppendUint64(b, uint64(timestamp))
+	bodyHash := sha256.Sum256(body)
+	return sha256.Sum256(append(b, bodyHash[:]...))
+}
+
+// requestBody returns a copy of the body of an outgoing request.
+func requestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if req.GetBody == nil {
+		return nil, errors.New("request body cannot be read twice")
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
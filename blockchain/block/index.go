@@ -0,0 +1,241 @@
+package block
+
+import (
+	"fmt"
+	"maps"
+	"math"
+
+	"github.com/EmilioCliff/learn-go/blockchain/utils"
+)
+
+// chainIndex keeps per-account state (balance and next nonce), the block
+// height of every confirmed transaction, the height of every block hash, the
+// confirmed transactions of every address and the coin supply up to date as
+// blocks are appended so lookups never walk the chain. Deltas from the
+// transaction pool are tracked apart from confirmed state and dropped
+// whenever the pool is swept into a block or cleared. Time locked
+// transactions are kept by recipient, their value counts towards the balance
+// but cannot be spent before they unlock.
+type chainIndex struct {
+	confirmed     map[string]utils.Amount
+	pending       map[string]utils.Amount
+	nonces        map[string]uint64
+	pendingNonces map[string]uint64
+	locks         map[string][]*Transaction
+	pendingLocks  map[string][]*Transaction
+	transactions  map[string]int
+	heights       map[[32]byte]int
+	history       map[string][]txRef
+	supply        utils.Amount
+
+	miningSender string
+}
+
+// txRef is the position of a confirmed transaction in the chain.
+type txRef struct {
+	height   int
+	position int
+}
+
+// newChainIndex counts the value sent by miningSender as newly issued coins.
+func newChainIndex(miningSender string) *chainIndex {
+	ci := &chainIndex{miningSender: miningSender}
+	ci.rebuild(nil, nil)
+	return ci
+}
+
+// applyBlock adds b, the block at height, to the index. A block that would
+// overflow a balance or the supply is an error and leaves the index as it
+// was.
+func (ci *chainIndex) applyBlock(b *Block, height int) error {
+	balances := map[string]utils.Amount{}
+	balance := func(blockchainAddress string) utils.Amount {
+		if amount, ok := balances[blockchainAddress]; ok {
+			return amount
+		}
+		return ci.confirmed[blockchainAddress]
+	}
+	supply := ci.supply
+	for _, t := range b.transactions {
+		cost, err := t.cost()
+		if err != nil {
+			return fmt.Errorf("transaction %s: %w", t.ID(), err)
+		}
+		if balances[t.senderBlockchainAddress], err = balance(t.senderBlockchainAddress).Sub(cost); err != nil {
+			return fmt.Errorf("transaction %s: sender balance: %w", t.ID(), err)
+		}
+		if balances[t.recipientBlockchainAddress], err = balance(t.recipientBlockchainAddress).Add(t.value); err != nil {
+			return fmt.Errorf("transaction %s: recipient balance: %w", t.ID(), err)
+		}
+		// Fees are burned by the sender and issued again in the reward.
+		if t.senderBlockchainAddress == ci.miningSender {
+			if supply, err = supply.Add(t.value); err != nil {
+				return fmt.Errorf("transaction %s: supply: %w", t.ID(), err)
+			}
+		}
+		if supply, err = supply.Sub(t.fee); err != nil {
+			return fmt.Errorf("transaction %s: supply: %w", t.ID(), err)
+		}
+	}
+
+	maps.Copy(ci.confirmed, balances)
+	ci.supply = supply
+	ci.heights[b.Hash()] = height
+	for i, t := range b.transactions {
+		if t.nonce+1 > ci.nonces[t.senderBlockchainAddress] {
+			ci.nonces[t.senderBlockchainAddress] = t.nonce + 1
+		}
+		if t.unlockHeight > 0 || t.unlockTime > 0 {
+			ci.locks[t.recipientBlockchainAddress] = append(ci.locks[t.recipientBlockchainAddress], t)
+		}
+		ci.transactions[t.ID()] = height
+
+		ref := txRef{height: height, position: i}
+		ci.history[t.senderBlockchainAddress] = append(ci.history[t.senderBlockchainAddress], ref)
+		if t.recipientBlockchainAddress != t.senderBlockchainAddress {
+			ci.history[t.recipientBlockchainAddress] = append(ci.history[t.recipientBlockchainAddress], ref)
+		}
+	}
+	return nil
+}
+
+// applyPending adds the deltas of t, admitted to the pool. A transaction
+// that would overflow them is an error and leaves the index as it was.
+func (ci *chainIndex) applyPending(t *Transaction) error {
+	cost, err := t.cost()
+	if err != nil {
+		return err
+	}
+	sent, err := ci.pending[t.senderBlockchainAddress].Sub(cost)
+	if err != nil {
+		return fmt.Errorf("sender balance: %w", err)
+	}
+	before := ci.pending[t.recipientBlockchainAddress]
+	if t.recipientBlockchainAddress == t.senderBlockchainAddress {
+		before = sent
+	}
+	received, err := before.Add(t.value)
+	if err != nil {
+		return fmt.Errorf("recipient balance: %w", err)
+	}
+	ci.pending[t.senderBlockchainAddress] = sent
+	ci.pending[t.recipientBlockchainAddress] = received
+	if t.nonce+1 > ci.pendingNonces[t.senderBlockchainAddress] {
+		ci.pendingNonces[t.senderBlockchainAddress] = t.nonce + 1
+	}
+	if t.unlockHeight > 0 || t.unlockTime > 0 {
+		ci.pendingLocks[t.recipientBlockchainAddress] = append(ci.pendingLocks[t.recipientBlockchainAddress], t)
+	}
+	return nil
+}
+
+// resetPending recomputes the pool deltas after part of the pool was
+// confirmed in a block.
+func (ci *chainIndex) resetPending(pool []*Transaction) error {
+	ci.clearPending()
+	for _, t := range pool {
+		if err := ci.applyPending(t); err != nil {
+			return fmt.Errorf("transaction %s: %w", t.ID(), err)
+		}
+	}
+	return nil
+}
+
+func (ci *chainIndex) clearPending() {
+	ci.pending = make(map[string]utils.Amount)
+	ci.pendingNonces = make(map[string]uint64)
+	ci.pendingLocks = make(map[string][]*Transaction)
+}
+
+// rebuild recomputes the index from scratch, used after the chain was
+// loaded from the store or replaced by consensus.
+func (ci *chainIndex) rebuild(chain []*Block, pool []*Transaction) error {
+	ci.confirmed = make(map[string]utils.Amount)
+	ci.nonces = make(map[string]uint64)
+	ci.locks = make(map[string][]*Transaction)
+	ci.transactions = make(map[string]int)
+	ci.heights = make(map[[32]byte]int)
+	ci.history = make(map[string][]txRef)
+	ci.supply = 0
+	for i, b := range chain {
+		if err := ci.applyBlock(b, i); err != nil {
+			return fmt.Errorf("block %d: %w", i, err)
+		}
+	}
+	return ci.resetPending(pool)
+}
+
+func (ci *chainIndex) balance(blockchainAddress string) utils.Amount {
+	return ci.confirmed[blockchainAddress] + ci.pending[blockchainAddress]
+}
+
+// confirmedBalance leaves out the pool.
+func (ci *chainIndex) confirmedBalance(blockchainAddress string) utils.Amount {
+	return ci.confirmed[blockchainAddress]
+}
+
+// confirmedNonce is the nonce of the next transaction from
+// blockchainAddress in a block.
+func (ci *chainIndex) confirmedNonce(blockchainAddress string) uint64 {
+	return ci.nonces[blockchainAddress]
+}
+
+// locked is the value blockchainAddress received that is still locked in
+// the block at height with block time unixTime.
+func (ci *chainIndex) locked(blockchainAddress string, height uint64, unixTime int64) utils.Amount {
+	return lockedValue(height, unixTime, ci.locks[blockchainAddress], ci.pendingLocks[blockchainAddress])
+}
+
+// confirmedLocked leaves out the pool.
+func (ci *chainIndex) confirmedLocked(blockchainAddress string, height uint64, unixTime int64) utils.Amount {
+	return lockedValue(height, unixTime, ci.locks[blockchainAddress])
+}
+
+// lockedValue sums the value of the transactions in locks that are still
+// locked in the block at height with block time unixTime. A sum too large
+// for an Amount locks everything.
+func lockedValue(height uint64, unixTime int64, locks ...[]*Transaction) utils.Amount {
+	var amount utils.Amount
+	for _, ts := range locks {
+		for _, t := range ts {
+			if !t.Locked(height, unixTime) {
+				continue
+			}
+			var err error
+			if amount, err = amount.Add(t.value); err != nil {
+				return math.MaxInt64
+			}
+		}
+	}
+	return amount
+}
+
+func (ci *chainIndex) used(blockchainAddress string) bool {
+	_, confirmed := ci.confirmed[blockchainAddress]
+	_, pending := ci.pending[blockchainAddress]
+	return confirmed || pending
+}
+
+// nextNonce is the nonce the next transaction from blockchainAddress must
+// carry, counting the ones already waiting in the pool.
+func (ci *chainIndex) nextNonce(blockchainAddress string) uint64 {
+	return max(ci.nonces[blockchainAddress], ci.pendingNonces[blockchainAddress])
+}
+
+// blockHeight returns the height of the block confirming transaction id.
+func (ci *chainIndex) blockHeight(id string) (int, bool) {
+	h, ok := ci.transactions[id]
+	return h, ok
+}
+
+// height returns the height of the block with hash.
+func (ci *chainIndex) height(hash [32]byte) (int, bool) {
+	h, ok := ci.heights[hash]
+	return h, ok
+}
+
+// confirmedTransactions returns where the transactions sending to or from
+// blockchainAddress are, oldest first.
+func (ci *chainIndex) confirmedTransactions(blockchainAddress string) []txRef {
+	return ci.history[blockchainAddress]
+}
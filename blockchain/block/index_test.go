@@ -0,0 +1,80 @@
+package block
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/EmilioCliff/learn-go/blockchain/utils"
+	"github.com/EmilioCliff/learn-go/blockchain/wallet"
+)
+
+func TestIndexRebuildMatchesIncremental(t *testing.T) {
+	bc := newTestBlockchain(t)
+	alice, bob, carol := wallet.NewWallet(), wallet.NewWallet(), wallet.NewWallet()
+	if !receive(bc, nextBlock(t, bc, alice.BlockchainAddress())) {
+		t.Fatal("block funding alice was rejected")
+	}
+
+	locked := NewTransaction(bc.ChainID(), alice.BlockchainAddress(), carol.BlockchainAddress(), utils.Coin/10, 1)
+	locked.unlockHeight = 100
+	s, _ := locked.canonical().Sign(alice.PrivateKey())
+	locked.witness = NewWitness(alice.PublicKey(), s)
+	if !receive(bc, nextBlock(t, bc, bob.BlockchainAddress(),
+		transfer(bc, alice, bob.BlockchainAddress(), utils.Coin/2, 0), locked)) {
+		t.Fatal("block with transfers was rejected")
+	}
+
+	for _, tx := range []*Transaction{
+		transfer(bc, alice, carol.BlockchainAddress(), utils.Coin/10, 2),
+		transfer(bc, bob, carol.BlockchainAddress(), utils.Coin/4, 0),
+		transfer(bc, bob, alice.BlockchainAddress(), utils.Coin/4, 1),
+	} {
+		if !bc.AddTransaction(tx, tx.witness) {
+			t.Fatalf("transaction %s was rejected", tx.ID())
+		}
+	}
+
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	rebuilt := newChainIndex(bc.config.MINING_SENDER)
+	if err := rebuilt.rebuild(bc.chain, bc.pool.transactions); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rebuilt, bc.index) {
+		t.Fatalf("rebuilt index differs from the incremental one:\n%+v\n%+v", rebuilt, bc.index)
+	}
+	if got := bc.index.locked(carol.BlockchainAddress(), uint64(len(bc.chain)), 0); got != utils.Coin/10 {
+		t.Fatalf("carol has %s locked, want %s", got, utils.Coin/10)
+	}
+}
+
+func TestIndexApplyBlockOverflow(t *testing.T) {
+	bc := newTestBlockchain(t)
+	rich := wallet.NewWallet().BlockchainAddress()
+	sender := bc.config.MINING_SENDER
+	b := NewBlock(&BlockHeader{}, []*Transaction{
+		NewTransaction(bc.ChainID(), sender, rich, math.MaxInt64, 1),
+		NewTransaction(bc.ChainID(), sender, rich, 1, 1),
+	})
+
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	if err := bc.index.applyBlock(b, len(bc.chain)); err == nil {
+		t.Fatal("block overflowing a balance was applied")
+	}
+	rebuilt := newChainIndex(sender)
+	rebuilt.rebuild(bc.chain, bc.pool.transactions)
+	if !reflect.DeepEqual(rebuilt, bc.index) {
+		t.Fatal("failed block was partly applied")
+	}
+
+	pending := NewTransaction(bc.ChainID(), rich, rich, math.MaxInt64, 0)
+	pending.fee = 1
+	if err := bc.index.applyPending(pending); err == nil {
+		t.Fatal("transaction overflowing its cost was applied")
+	}
+	if bc.index.used(rich) {
+		t.Fatal("failed transaction was partly applied")
+	}
+}
@@ -0,0 +1,271 @@
+package block
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"time"
+)
+
+// MempoolStats is a snapshot of the pool and of its counters since the
+// node started. Evicted counts transactions pushed out of a full pool,
+// Dropped the ones a new block, a reorg or an eviction made invalid.
+type MempoolStats struct {
+	Size     int    `json:"size"`
+	Bytes    int    `json:"bytes"`
+	Admitted uint64 `json:"admitted"`
+	Rejected uint64 `json:"rejected"`
+	Replaced uint64 `json:"replaced"`
+	Expired  uint64 `json:"expired"`
+	Evicted  uint64 `json:"evicted"`
+	Dropped  uint64 `json:"dropped"`
+	Cleared  uint64 `json:"cleared"`
+}
+
+// mempool holds the transactions waiting for a block, ordered by fee rate,
+// highest first, with the ones paying the same rate in arrival order. It
+// only keeps the transactions and their bookkeeping, the admission rules
+// that need the chain live on Blockchain, and like the index it is guarded
+// by Blockchain.mux.
+type mempool struct {
+	transactions []*Transaction
+	added        map[string]time.Time
+	senders      map[string]int
+	bytes        int
+	stats        MempoolStats
+
+	maxCount     int
+	maxBytes     int
+	maxPerSender int
+	ttl          time.Duration
+	rbfBump      int
+}
+
+func newMempool(maxCount, maxBytes, maxPerSender int, ttl time.Duration, rbfBump int) *mempool {
+	mp := &mempool{
+		maxCount:     maxCount,
+		maxBytes:     maxBytes,
+		maxPerSender: maxPerSender,
+		ttl:          ttl,
+		rbfBump:      rbfBump,
+	}
+	mp.reset()
+	return mp
+}
+
+// reset empties the pool and returns what it held with the time each
+// transaction was first admitted.
+func (mp *mempool) reset() ([]*Transaction, map[string]time.Time) {
+	transactions, added := mp.transactions, mp.added
+	mp.transactions = []*Transaction{}
+	mp.added = make(map[string]time.Time)
+	mp.senders = make(map[string]int)
+	mp.bytes = 0
+	return transactions, added
+}
+
+// insert puts t at the place its fee rate ranks it, added is when it first
+// entered the pool and where its TTL runs from.
+func (mp *mempool) insert(t *Transaction, added time.Time) {
+	rate := t.FeeRate()
+	i := len(mp.transactions)
+	for i > 0 && mp.transactions[i-1].FeeRate() < rate {
+		i--
+	}
+	mp.transactions = slices.Insert(mp.transactions, i, t)
+	mp.added[t.ID()] = added
+	mp.senders[t.senderBlockchainAddress]++
+	mp.bytes += t.Size()
+}
+
+func (mp *mempool) get(id string) *Transaction {
+	if _, ok := mp.added[id]; !ok {
+		return nil
+	}
+	for _, t := range mp.transactions {
+		if t.ID() == id {
+			return t
+		}
+	}
+	return nil
+}
+
+// pending returns the transaction from sender with nonce, if any.
+func (mp *mempool) pending(sender string, nonce uint64) *Transaction {
+	if mp.senders[sender] == 0 {
+		return nil
+	}
+	for _, t := range mp.transactions {
+		if t.senderBlockchainAddress == sender && t.nonce == nonce {
+			return t
+		}
+	}
+	return nil
+}
+
+// without returns the transactions but the ones in drop, in pool order.
+func (mp *mempool) without(drop ...*Transaction) []*Transaction {
+	return slices.DeleteFunc(slices.Clone(mp.transactions), func(t *Transaction) bool {
+		return slices.Contains(drop, t)
+	})
+}
+
+// expired returns the transactions older than the TTL at now.
+func (mp *mempool) expired(now time.Time) []*Transaction {
+	if mp.ttl <= 0 {
+		return nil
+	}
+	expired := []*Transaction{}
+	for _, t := range mp.transactions {
+		if now.Sub(mp.added[t.ID()]) > mp.ttl {
+			expired = append(expired, t)
+		}
+	}
+	return expired
+}
+
+// full reports whether t does not fit next to the transactions already in
+// the pool.
+func (mp *mempool) full(t *Transaction) bool {
+	return (mp.maxCount > 0 && len(mp.transactions)+1 > mp.maxCount) ||
+		(mp.maxBytes > 0 && mp.bytes+t.Size() > mp.maxBytes)
+}
+
+// senderFull reports whether sender has as many transactions pending as a
+// single sender may.
+func (mp *mempool) senderFull(sender string) bool {
+	return mp.maxPerSender > 0 && mp.senders[sender] >= mp.maxPerSender
+}
+
+// replaces reports whether t pays enough to replace old, the same sender
+// and nonce: a fee at least rbfBump percent higher and a higher fee rate.
+// Fees too large to scale by the bump never replace anything.
+func (mp *mempool) replaces(t, old *Transaction) bool {
+	paid, err := t.fee.Mul(100)
+	if err != nil {
+		return false
+	}
+	required, err := old.fee.Mul(int64(100 + mp.rbfBump))
+	if err != nil {
+		return false
+	}
+	return paid >= required && t.fee > old.fee && t.FeeRate() > old.FeeRate()
+}
+
+func (mp *mempool) snapshot() MempoolStats {
+	stats := mp.stats
+	stats.Size = len(mp.transactions)
+	stats.Bytes = mp.bytes
+	return stats
+}
+
+// admit runs t through the pool's admission policies and puts it in the
+// pool: a transaction with the nonce of a pending one from the same sender
+// replaces it or is rejected, a sender may not have more than
+// MEMPOOL_MAX_PER_SENDER transactions pending, and a full pool makes room
+// for t or rejects it. The caller holds bc.mux.
+func (bc *Blockchain) admit(t *Transaction, w *Witness) error {
+	if old := bc.pool.pending(t.senderBlockchainAddress, t.nonce); old != nil && old.ID() != t.ID() {
+		return bc.replace(old, t, w)
+	}
+	if err := bc.admissible(t); err != nil {
+		return err
+	}
+	if !bc.VerifyTransactionSignature(w, t) {
+		return fmt.Errorf("invalid witness")
+	}
+	if bc.pool.senderFull(t.senderBlockchainAddress) {
+		return fmt.Errorf("sender has %d transactions pending", bc.pool.maxPerSender)
+	}
+	if err := bc.makeRoom(t); err != nil {
+		return err
+	}
+	if err := bc.index.applyPending(t); err != nil {
+		return err
+	}
+	t.witness = w
+	bc.pool.insert(t, time.Now())
+	return nil
+}
+
+// replace swaps old for t, which has the same sender and nonce and pays a
+// fee at least MEMPOOL_RBF_BUMP percent higher. The sender's transactions
+// after old stay in the pool as long as the sender can still pay for them.
+func (bc *Blockchain) replace(old, t *Transaction, w *Witness) error {
+	if !bc.pool.replaces(t, old) {
+		return fmt.Errorf("replacing %s takes a fee %d%% higher", old.ID(), bc.pool.rbfBump)
+	}
+	if err := bc.wellFormed(t); err != nil {
+		return err
+	}
+	cost, err := t.cost()
+	if err != nil {
+		return fmt.Errorf("cost: %w", err)
+	}
+	oldCost, _ := old.cost()
+	available, err := bc.spendable(t.senderBlockchainAddress).Add(oldCost)
+	if err != nil {
+		return fmt.Errorf("balance: %w", err)
+	}
+	if available < cost {
+		return fmt.Errorf("not enough balance in a wallet")
+	}
+	if !bc.VerifyTransactionSignature(w, t) {
+		return fmt.Errorf("invalid witness")
+	}
+	t.witness = w
+	bc.resetPool(append(bc.pool.without(old), t))
+	if bc.pool.get(t.ID()) == nil {
+		return fmt.Errorf("replacement does not fit the pool")
+	}
+	bc.pool.stats.Replaced++
+	log.Printf("INFO: Replaced transaction %s with %s\n", old.ID(), t.ID())
+	return nil
+}
+
+// makeRoom evicts the transactions paying the lowest fee rates below t's
+// until t fits in the pool. Only the last pending transaction of a sender
+// can go, so no nonce gap is left behind, and never one of t's own sender.
+// Transactions that relied on the funds of an evicted one go with it.
+func (bc *Blockchain) makeRoom(t *Transaction) error {
+	for bc.pool.full(t) {
+		var victim *Transaction
+		for i := len(bc.pool.transactions) - 1; i >= 0 && victim == nil; i-- {
+			c := bc.pool.transactions[i]
+			if c.FeeRate() >= t.FeeRate() {
+				break
+			}
+			if c.senderBlockchainAddress != t.senderBlockchainAddress && c.nonce+1 == bc.index.nextNonce(c.senderBlockchainAddress) {
+				victim = c
+			}
+		}
+		if victim == nil {
+			return fmt.Errorf("transaction pool is full")
+		}
+		log.Printf("WARN: Evicted transaction %s from the full pool\n", victim.ID())
+		bc.pool.stats.Evicted++
+		bc.resetPool(bc.pool.without(victim))
+	}
+	return bc.admissible(t)
+}
+
+// expirePool drops the transactions that waited longer than MEMPOOL_TTL,
+// and the ones depending on them.
+func (bc *Blockchain) expirePool(now time.Time) {
+	expired := bc.pool.expired(now)
+	if len(expired) == 0 {
+		return
+	}
+	for _, t := range expired {
+		log.Printf("INFO: Transaction %s expired\n", t.ID())
+	}
+	bc.pool.stats.Expired += uint64(len(expired))
+	bc.resetPool(bc.pool.without(expired...))
+}
+
+// MempoolStats returns the pool's size and counters.
+func (bc *Blockchain) MempoolStats() MempoolStats {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.pool.snapshot()
+}
@@ -0,0 +1,176 @@
+package block
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// Leaves and inner nodes of the Merkle trees are hashed with a different
+// prefix byte, so the hash of an inner node can never pass for a leaf and a
+// shortened proof for an inner node does not verify as a transaction.
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+// MerkleRoot hashes the transaction IDs pairwise up to a single root. A
+// level with an odd number of nodes pairs its last node with itself, and an
+// empty block has the zero root. Pairing a node with itself means a list
+// whose tail is repeated, [a b c] and [a b c c], has the same root, which
+// is why blocks may not carry a transaction twice, see uniqueTransactions.
+func MerkleRoot(transactions []*Transaction) [32]byte {
+	level := merkleLeaves(transactions)
+	if len(level) == 0 {
+		return [32]byte{}
+	}
+	for len(level) > 1 {
+		level = merkleParents(level)
+	}
+	return level[0]
+}
+
+// WitnessRoot hashes the witnesses of transactions pairwise like
+// MerkleRoot, which binds them to the block header without changing the
+// transaction IDs. Transactions without a witness count as the zero hash.
+func WitnessRoot(transactions []*Transaction) [32]byte {
+	level := make([][32]byte, len(transactions))
+	for i, t := range transactions {
+		level[i] = merkleLeaf(t.witness.hash())
+	}
+	if len(level) == 0 {
+		return [32]byte{}
+	}
+	for len(level) > 1 {
+		level = merkleParents(level)
+	}
+	return level[0]
+}
+
+// ProofStep is one sibling hash on the way from a leaf to the root, Left
+// tells whether the sibling sits on the left of the running hash.
+type ProofStep struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left"`
+}
+
+// MerkleProof shows that a transaction is part of the block with the given
+// hash without shipping the other transactions of that block.
+type MerkleProof struct {
+	TransactionID string      `json:"transaction_id"`
+	BlockHeight   int         `json:"block_height"`
+	BlockHash     string      `json:"block_hash"`
+	MerkleRoot    string      `json:"merkle_root"`
+	Index         int         `json:"index"`
+	Path          []ProofStep `json:"path"`
+}
+
+// NewMerkleProof builds the inclusion proof of the transaction at index in
+// the transactions of b.
+func NewMerkleProof(b *Block, height int, index int) (*MerkleProof, error) {
+	if index < 0 || index >= len(b.transactions) {
+		return nil, fmt.Errorf("transaction index %d out of range", index)
+	}
+	proof := &MerkleProof{
+		TransactionID: b.transactions[index].ID(),
+		BlockHeight:   height,
+		BlockHash:     fmt.Sprintf("%x", b.Hash()),
+		MerkleRoot:    fmt.Sprintf("%x", b.merkleRoot),
+		Index:         index,
+		Path:          []ProofStep{},
+	}
+
+	level := merkleLeaves(b.transactions)
+	i := index
+	for len(level) > 1 {
+		sibling := i ^ 1
+		if sibling >= len(level) {
+			sibling = i
+		}
+		proof.Path = append(proof.Path, ProofStep{
+			Hash: fmt.Sprintf("%x", level[sibling]),
+			Left: sibling < i,
+		})
+		level = merkleParents(level)
+		i /= 2
+	}
+	return proof, nil
+}
+
+// VerifyMerkleProof recomputes the root from transactionID and path and
+// compares it with merkleRoot, which the caller takes from a block header
+// it already trusts.
+func VerifyMerkleProof(transactionID string, path []ProofStep, merkleRoot [32]byte) bool {
+	id, err := hex.DecodeString(transactionID)
+	if err != nil || len(id) != 32 {
+		return false
+	}
+	var leaf [32]byte
+	copy(leaf[:], id)
+	h := merkleLeaf(leaf)
+	for _, step := range path {
+		s, err := hex.DecodeString(step.Hash)
+		if err != nil || len(s) != 32 {
+			return false
+		}
+		var sibling [32]byte
+		copy(sibling[:], s)
+		if step.Left {
+			h = merkleHashPair(sibling, h)
+		} else {
+			h = merkleHashPair(h, sibling)
+		}
+	}
+	return h == merkleRoot
+}
+
+// uniqueTransactions checks that no transaction appears twice, so the list
+// is the only one its Merkle root commits to.
+func uniqueTransactions(transactions []*Transaction) error {
+	seen := make(map[[32]byte]bool, len(transactions))
+	for _, t := range transactions {
+		h := t.hash()
+		if seen[h] {
+			return fmt.Errorf("transaction %s appears twice", t.ID())
+		}
+		seen[h] = true
+	}
+	return nil
+}
+
+func merkleLeaves(transactions []*Transaction) [][32]byte {
+	leaves := make([][32]byte, len(transactions))
+	for i, t := range transactions {
+		leaves[i] = merkleLeaf(t.hash())
+	}
+	return leaves
+}
+
+func merkleParents(level [][32]byte) [][32]byte {
+	parents := make([][32]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		right := level[i]
+		if i+1 < len(level) {
+			right = level[i+1]
+		}
+		parents = append(parents, merkleHashPair(level[i], right))
+	}
+	return parents
+}
+
+// merkleLeaf is the hash of a leaf, H(0x00 || id).
+func merkleLeaf(id [32]byte) [32]byte {
+	var buf [33]byte
+	buf[0] = merkleLeafPrefix
+	copy(buf[1:], id[:])
+	return sha256.Sum256(buf[:])
+}
+
+// merkleHashPair is the hash of an inner node, H(0x01 || left || right).
+func merkleHashPair(left, right [32]byte) [32]byte {
+	var buf [65]byte
+	buf[0] = merkleNodePrefix
+	copy(buf[1:33], left[:])
+	copy(buf[33:], right[:])
+	return sha256.Sum256(buf[:])
+}
@@ -0,0 +1,109 @@
+package block
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/EmilioCliff/learn-go/blockchain/utils"
+	"github.com/EmilioCliff/learn-go/blockchain/wallet"
+)
+
+func testTransactions(n int) []*Transaction {
+	transactions := make([]*Transaction, n)
+	for i := range transactions {
+		transactions[i] = NewTransaction("test", "sender", "recipient", utils.Amount(i+1), uint64(i))
+	}
+	return transactions
+}
+
+func TestMerkleProof(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		transactions := testTransactions(n)
+		b := NewBlock(NewHeader([32]byte{}, 0, transactions), transactions)
+		root := MerkleRoot(transactions)
+		for i := range transactions {
+			proof, err := NewMerkleProof(b, 1, i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if proof.MerkleRoot != fmt.Sprintf("%x", root) || !VerifyMerkleProof(proof.TransactionID, proof.Path, root) {
+				t.Fatalf("proof of transaction %d of %d does not verify", i, n)
+			}
+			if n > 1 && VerifyMerkleProof(transactions[(i+1)%n].ID(), proof.Path, root) {
+				t.Fatalf("proof of transaction %d of %d verifies another transaction", i, n)
+			}
+			if len(proof.Path) == 0 {
+				continue
+			}
+			// A last node paired with itself has no side to flip.
+			flipped := append([]ProofStep{}, proof.Path...)
+			flipped[0].Left = !flipped[0].Left
+			if (n%2 == 0 || i < n-1) && VerifyMerkleProof(proof.TransactionID, flipped, root) {
+				t.Fatalf("proof of transaction %d of %d verifies with a flipped side", i, n)
+			}
+			if VerifyMerkleProof(proof.TransactionID, proof.Path[1:], root) {
+				t.Fatalf("proof of transaction %d of %d verifies without its first step", i, n)
+			}
+			bad := append([]ProofStep{}, proof.Path...)
+			bad[0].Hash = "zz" + bad[0].Hash[2:]
+			if VerifyMerkleProof(proof.TransactionID, bad, root) {
+				t.Fatalf("proof of transaction %d of %d verifies with a malformed hash", i, n)
+			}
+		}
+		if _, err := NewMerkleProof(b, 1, n); err == nil {
+			t.Fatalf("proof of transaction %d of %d was built", n, n)
+		}
+	}
+	if VerifyMerkleProof("abc", nil, [32]byte{}) {
+		t.Fatal("malformed transaction ID verified")
+	}
+}
+
+// TestMerkleInnerNodeProof checks that an inner node of the tree does not
+// pass for a transaction with the rest of a proof that runs through it.
+func TestMerkleInnerNodeProof(t *testing.T) {
+	transactions := testTransactions(4)
+	b := NewBlock(NewHeader([32]byte{}, 0, transactions), transactions)
+	root := MerkleRoot(transactions)
+	proof, err := NewMerkleProof(b, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !VerifyMerkleProof(proof.TransactionID, proof.Path, root) {
+		t.Fatal("proof of the first transaction does not verify")
+	}
+	leaves := merkleLeaves(transactions)
+	inner := merkleHashPair(leaves[0], leaves[1])
+	if VerifyMerkleProof(fmt.Sprintf("%x", inner), proof.Path[1:], root) {
+		t.Fatal("inner node verified as a transaction")
+	}
+}
+
+// TestMerkleDuplicateTail covers CVE-2012-2459: a transaction list whose
+// tail is repeated has the same root as the list without it.
+func TestMerkleDuplicateTail(t *testing.T) {
+	transactions := testTransactions(3)
+	padded := append(append([]*Transaction{}, transactions...), transactions[2])
+	if MerkleRoot(transactions) != MerkleRoot(padded) {
+		t.Fatal("repeating the tail changed the root")
+	}
+	if err := uniqueTransactions(transactions); err != nil {
+		t.Fatal(err)
+	}
+	if err := uniqueTransactions(padded); err == nil {
+		t.Fatal("list with a repeated transaction was accepted")
+	}
+
+	bc := newTestBlockchain(t)
+	alice, bob := wallet.NewWallet(), wallet.NewWallet()
+	if !receive(bc, nextBlock(t, bc, alice.BlockchainAddress())) {
+		t.Fatal("block funding alice was rejected")
+	}
+	tx := transfer(bc, alice, bob.BlockchainAddress(), utils.Coin/10, 0)
+	if receive(bc, nextBlock(t, bc, bob.BlockchainAddress(), tx, tx)) {
+		t.Fatal("block with a repeated transaction was accepted")
+	}
+	if !receive(bc, nextBlock(t, bc, bob.BlockchainAddress(), tx)) {
+		t.Fatal("block with the transaction once was rejected")
+	}
+}
@@ -0,0 +1,134 @@
+package block
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MinerStats is a snapshot of the miner's counters. HashRate is measured
+// over the running search, or the last one when the miner is idle.
+type MinerStats struct {
+	Workers     int     `json:"workers"`
+	Searching   bool    `json:"searching"`
+	HashRate    float64 `json:"hash_rate"`
+	TotalHashes uint64  `json:"total_hashes"`
+	BlocksFound uint64  `json:"blocks_found"`
+	Cancelled   uint64  `json:"cancelled"`
+}
+
+// Miner runs the nonce search of a header on several goroutines, worker i
+// trying nonces i, i+workers, i+2*workers and so on.
+type Miner struct {
+	workers int
+
+	totalHashes  atomic.Uint64
+	searchHashes atomic.Uint64
+	blocksFound  atomic.Uint64
+	cancelled    atomic.Uint64
+
+	mux          sync.Mutex
+	searching    bool
+	searchStart  time.Time
+	lastHashRate float64
+}
+
+// NewMiner returns a miner with the given number of workers, one per CPU
+// when workers is not positive.
+func NewMiner(workers int) *Miner {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	return &Miner{workers: workers}
+}
+
+// Search looks for a nonce that makes header meet its difficulty. It stops
+// with ctx.Err() as soon as ctx is cancelled, for instance because the
+// chain tip moved and the header is stale.
+func (m *Miner) Search(ctx context.Context, header BlockHeader) (*BlockHeader, error) {
+	m.startSearch()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	found := make(chan *BlockHeader, m.workers)
+	var wg sync.WaitGroup
+	for i := 0; i < m.workers; i++ {
+		start := header
+		start.Nonce += i
+		wg.Add(1)
+		go func(h BlockHeader) {
+			defer wg.Done()
+			for ; ; h.Nonce += m.workers {
+				select {
+				case <-ctx.Done():
+					return
+				default:
+				}
+				m.totalHashes.Add(1)
+				m.searchHashes.Add(1)
+				if h.MeetsDifficulty() {
+					found <- &h
+					return
+				}
+			}
+		}(start)
+	}
+
+	var result *BlockHeader
+	select {
+	case result = <-found:
+		m.blocksFound.Add(1)
+	case <-ctx.Done():
+		m.cancelled.Add(1)
+	}
+	cancel()
+	wg.Wait()
+	m.endSearch()
+
+	if result == nil {
+		return nil, ctx.Err()
+	}
+	return result, nil
+}
+
+func (m *Miner) startSearch() {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.searching = true
+	m.searchStart = time.Now()
+	m.searchHashes.Store(0)
+}
+
+func (m *Miner) endSearch() {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.searching = false
+	m.lastHashRate = m.hashRateLocked()
+}
+
+func (m *Miner) hashRateLocked() float64 {
+	elapsed := time.Since(m.searchStart).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(m.searchHashes.Load()) / elapsed
+}
+
+func (m *Miner) Stats() MinerStats {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	hashRate := m.lastHashRate
+	if m.searching {
+		hashRate = m.hashRateLocked()
+	}
+	return MinerStats{
+		Workers:     m.workers,
+		Searching:   m.searching,
+		HashRate:    hashRate,
+		TotalHashes: m.totalHashes.Load(),
+		BlocksFound: m.blocksFound.Load(),
+		Cancelled:   m.cancelled.Load(),
+	}
+}
@@ -0,0 +1,76 @@
+package block
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/EmilioCliff/learn-go/blockchain/wallet"
+)
+
+func TestMinerSearch(t *testing.T) {
+	m := NewMiner(4)
+	transactions := testTransactions(3)
+	header := NewHeader([32]byte{1}, 2, transactions)
+	sealed, err := m.Search(context.Background(), *header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sealed.MeetsDifficulty() || sealed.MerkleRoot != header.MerkleRoot || sealed.PreviousHash != header.PreviousHash {
+		t.Fatalf("sealed header %+v does not match %+v", sealed, header)
+	}
+	if stats := m.Stats(); stats.BlocksFound != 1 || stats.Searching || stats.TotalHashes == 0 {
+		t.Fatalf("stats after a search: %+v", stats)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := m.Search(ctx, *NewHeader([32]byte{}, maxDifficulty, transactions)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("search past its deadline: %v", err)
+	}
+	if stats := m.Stats(); stats.Cancelled != 1 || stats.Searching {
+		t.Fatalf("stats after a cancelled search: %+v", stats)
+	}
+}
+
+func TestMiningCancelledOnTipChange(t *testing.T) {
+	bc := newTestBlockchain(t)
+	// Nothing is found at the top difficulty, the search only ends when the
+	// tip moves.
+	bc.mux.Lock()
+	bc.config.RETARGET_INTERVAL = 0
+	bc.chain[0].difficulty = maxDifficulty
+	genesis := bc.chain[0]
+	bc.mux.Unlock()
+
+	done := make(chan bool)
+	go func() { done <- bc.Mining() }()
+	for deadline := time.Now().Add(5 * time.Second); !bc.MinerStats().Searching; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("search did not start")
+		}
+	}
+
+	// A heavier chain from a peer, its proof of work is not checked here.
+	transactions := []*Transaction{NewTransaction(bc.ChainID(), bc.config.MINING_SENDER, wallet.NewWallet().BlockchainAddress(), bc.config.MINING_REWARD, 1)}
+	tip := NewBlock(NewHeader(genesis.Hash(), maxDifficulty, transactions), transactions)
+	if !bc.replaceChain([]*Block{genesis, tip}) {
+		t.Fatal("heavier chain was not taken")
+	}
+
+	select {
+	case mined := <-done:
+		if mined {
+			t.Fatal("search on a stale tip reported a block")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("search was not cancelled when the tip changed")
+	}
+	if stats := bc.MinerStats(); stats.Cancelled != 1 || stats.Searching {
+		t.Fatalf("miner stats: %+v", stats)
+	}
+	if chain := bc.Chain(); len(chain) != 2 || chain[1].Hash() != tip.Hash() {
+		t.Fatalf("chain after the cancelled search has %d blocks", len(chain))
+	}
+}
@@ -0,0 +1,561 @@
+package block
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/EmilioCliff/learn-go/blockchain/utils"
+)
+
+const (
+	// maxPeerFailures consecutive failed requests evict a peer.
+	maxPeerFailures = 3
+	// gossipTTL is how long a gossiped message ID is remembered, long
+	// enough for a message to die out on any reasonably sized network.
+	gossipTTL = 10 * time.Minute
+)
+
+// PeerInfo is what a node knows about one of its peers. PublicKey is the
+// node key its requests are signed with.
+type PeerInfo struct {
+	URL       string    `json:"url"`
+	PublicKey string    `json:"public_key,omitempty"`
+	LastSeen  time.Time `json:"last_seen"`
+	Failures  int       `json:"failures"`
+}
+
+// Network is the chain a node is on, its chain ID and the hash of its
+// genesis block. Nodes only peer with nodes on the same network.
+type Network struct {
+	ChainID     string `json:"chain_id"`
+	GenesisHash string `json:"genesis_hash"`
+}
+
+// PeerRequest is the body of POST /peers, a node introducing itself with
+// the URL it can be reached at and its network. The request is signed with
+// its node key.
+type PeerRequest struct {
+	URL         *string `json:"url"`
+	ChainID     *string `json:"chain_id"`
+	GenesisHash *string `json:"genesis_hash"`
+}
+
+// HandshakeResponse answers POST /peers with the node key and the network
+// of the node handshaked and its peers.
+type HandshakeResponse struct {
+	PublicKey string `json:"public_key"`
+	Network
+	Peers []PeerInfo `json:"peers"`
+}
+
+func (pr *PeerRequest) Validate() bool {
+	return pr.URL != nil && utils.NormalizeURL(*pr.URL) != "" && pr.ChainID != nil && pr.GenesisHash != nil
+}
+
+func (pr *PeerRequest) Network() Network {
+	return Network{ChainID: *pr.ChainID, GenesisHash: *pr.GenesisHash}
+}
+
+// PeerManager keeps the set of peers a node gossips with. It starts from
+// the configured seeds, learns new peers from every handshake and health
+// check, and evicts peers that stop answering. Seeds are never forgotten:
+// they are handshaked again whenever they are missing from the set.
+//
+// Every request to a peer is signed with the node key. When PEER_KEYS lists
+// the keys of the network's nodes only those can become peers, otherwise
+// any node that handshakes does.
+type PeerManager struct {
+	self     string
+	network  Network
+	seeds    []string
+	maxPeers int
+	fanout   int
+	interval time.Duration
+	client   *http.Client
+	key      *NodeKey
+	allowed  map[string]bool
+
+	mux     sync.RWMutex
+	peers   map[string]*PeerInfo
+	dialing map[string]bool
+	seen    map[string]time.Time
+	// pruned is when seen was last swept of IDs older than gossipTTL.
+	pruned  time.Time
+	aliases map[string]bool
+
+	cancel context.CancelFunc
+}
+
+// NewPeerManager returns a manager for the node reachable at self on
+// network, signing its requests with key. fanout bounds how many peers a
+// broadcast talks to at once and timeout bounds every request to a peer.
+// allowed are the node keys that may become peers, any when empty.
+func NewPeerManager(self string, network Network, seeds []string, maxPeers, fanout int, timeout, interval time.Duration, key *NodeKey, allowed []string) *PeerManager {
+	pm := &PeerManager{
+		self:     utils.NormalizeURL(self),
+		network:  network,
+		maxPeers: maxPeers,
+		fanout:   max(fanout, 1),
+		interval: interval,
+		client:   &http.Client{Timeout: timeout, Transport: &peerTransport{key: key}},
+		key:      key,
+		allowed:  make(map[string]bool),
+		peers:    make(map[string]*PeerInfo),
+		dialing:  make(map[string]bool),
+		seen:     make(map[string]time.Time),
+		aliases:  make(map[string]bool),
+	}
+	for _, s := range seeds {
+		if s = utils.NormalizeURL(s); s != "" && s != pm.self {
+			pm.seeds = append(pm.seeds, s)
+		}
+	}
+	for _, k := range allowed {
+		if k = strings.TrimSpace(k); k != "" {
+			pm.allowed[k] = true
+		}
+	}
+	return pm
+}
+
+// peerTransport signs requests to peers with the node key.
+type peerTransport struct {
+	key *NodeKey
+}
+
+func (pt *peerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	if err := pt.key.SignRequest(req); err != nil {
+		return nil, err
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func (pm *PeerManager) Self() string {
+	return pm.self
+}
+
+// isSelf reports whether u is one of our own URLs: the advertised one or
+// another that turned out to lead back to us, say a seed listing us by an
+// IP address where we advertise a host name.
+func (pm *PeerManager) isSelf(u string) bool {
+	if u == pm.self {
+		return true
+	}
+	pm.mux.RLock()
+	defer pm.mux.RUnlock()
+	return pm.aliases[u]
+}
+
+func (pm *PeerManager) Key() *NodeKey {
+	return pm.key
+}
+
+func (pm *PeerManager) Node() NodeInfo {
+	return NodeInfo{ID: pm.key.ID(), PublicKey: pm.key.PublicKey(), URL: pm.self, Network: pm.network}
+}
+
+func (pm *PeerManager) Network() Network {
+	return pm.network
+}
+
+// Authenticate checks that req is signed by a node and was not seen before,
+// and returns the node's key.
+func (pm *PeerManager) Authenticate(req *http.Request) (string, error) {
+	publicKey, err := VerifyRequest(req, time.Now())
+	if err != nil {
+		return "", err
+	}
+	// Low-S signatures are unique but for the case of their hex.
+	if !pm.MarkSeen("request:" + strings.ToLower(req.Header.Get(HeaderNodeSignature))) {
+		return "", fmt.Errorf("replayed request")
+	}
+	return publicKey, nil
+}
+
+// Allowed reports whether the node with publicKey may become a peer.
+func (pm *PeerManager) Allowed(publicKey string) bool {
+	return len(pm.allowed) == 0 || pm.allowed[publicKey]
+}
+
+// Registered reports whether publicKey belongs to a node of the network:
+// one listed in PEER_KEYS or, without such a list, one of our peers.
+func (pm *PeerManager) Registered(publicKey string) bool {
+	if len(pm.allowed) > 0 {
+		return pm.allowed[publicKey]
+	}
+	pm.mux.RLock()
+	defer pm.mux.RUnlock()
+	for _, p := range pm.peers {
+		if p.PublicKey == publicKey {
+			return true
+		}
+	}
+	return false
+}
+
+// PeerURL returns the URL the peer with publicKey registered at, the lowest
+// one if it registered more than one.
+func (pm *PeerManager) PeerURL(publicKey string) (string, bool) {
+	pm.mux.RLock()
+	defer pm.mux.RUnlock()
+	found := ""
+	for u, p := range pm.peers {
+		if p.PublicKey == publicKey && (found == "" || u < found) {
+			found = u
+		}
+	}
+	return found, found != ""
+}
+
+// Peers returns the URLs of the known peers in a stable order.
+func (pm *PeerManager) Peers() []string {
+	pm.mux.RLock()
+	defer pm.mux.RUnlock()
+	urls := make([]string, 0, len(pm.peers))
+	for u := range pm.peers {
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+func (pm *PeerManager) PeerInfos() []PeerInfo {
+	pm.mux.RLock()
+	defer pm.mux.RUnlock()
+	infos := make([]PeerInfo, 0, len(pm.peers))
+	for _, p := range pm.peers {
+		infos = append(infos, *p)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].URL < infos[j].URL })
+	return infos
+}
+
+// AddPeer adds u, signing with publicKey, to the peer set and reports
+// whether it was new. A known peer that comes back with another key keeps
+// the new one. Our own URL, malformed URLs, keys that are not allowed and
+// peers beyond MAX_PEERS are ignored.
+func (pm *PeerManager) AddPeer(u, publicKey string) bool {
+	u = utils.NormalizeURL(u)
+	if u == "" || pm.isSelf(u) || publicKey == pm.key.PublicKey() || !pm.Allowed(publicKey) {
+		return false
+	}
+	pm.mux.Lock()
+	defer pm.mux.Unlock()
+	if p, ok := pm.peers[u]; ok {
+		if p.PublicKey != publicKey {
+			log.Printf("WARN: Peer %s changed its node key\n", u)
+			p.PublicKey = publicKey
+		}
+		return false
+	}
+	if pm.maxPeers > 0 && len(pm.peers) >= pm.maxPeers {
+		log.Printf("WARN: Peer limit reached, ignoring %s\n", u)
+		return false
+	}
+	pm.peers[u] = &PeerInfo{URL: u, PublicKey: publicKey, LastSeen: time.Now()}
+	log.Printf("INFO: Added peer %s\n", u)
+	return true
+}
+
+func (pm *PeerManager) RemovePeer(u string) {
+	pm.mux.Lock()
+	defer pm.mux.Unlock()
+	delete(pm.peers, utils.NormalizeURL(u))
+}
+
+// MarkSeen records a gossiped message ID and reports whether it is the
+// first time this node sees it, so messages are relayed at most once. IDs
+// older than gossipTTL are forgotten along the way, so seen holds at most
+// the IDs of two TTLs whether or not health checks run.
+func (pm *PeerManager) MarkSeen(id string) bool {
+	pm.mux.Lock()
+	defer pm.mux.Unlock()
+	now := time.Now()
+	if now.Sub(pm.pruned) > gossipTTL {
+		for id, t := range pm.seen {
+			if now.Sub(t) > gossipTTL {
+				delete(pm.seen, id)
+			}
+		}
+		pm.pruned = now
+	}
+	if _, ok := pm.seen[id]; ok {
+		return false
+	}
+	pm.seen[id] = now
+	return true
+}
+
+// Seen reports whether id was already marked without marking it.
+func (pm *PeerManager) Seen(id string) bool {
+	pm.mux.RLock()
+	defer pm.mux.RUnlock()
+	_, ok := pm.seen[id]
+	return ok
+}
+
+func (pm *PeerManager) recordSuccess(u string) {
+	pm.mux.Lock()
+	defer pm.mux.Unlock()
+	if p, ok := pm.peers[u]; ok {
+		p.LastSeen = time.Now()
+		p.Failures = 0
+	}
+}
+
+func (pm *PeerManager) recordFailure(u string) {
+	pm.mux.Lock()
+	defer pm.mux.Unlock()
+	p, ok := pm.peers[u]
+	if !ok {
+		return
+	}
+	p.Failures++
+	if p.Failures >= maxPeerFailures {
+		delete(pm.peers, u)
+		log.Printf("WARN: Evicted peer %s after %d failures\n", u, p.Failures)
+	}
+}
+
+// Broadcast sends body to path on every peer, at most fanout requests at a
+// time, and waits for all of them. A peer that cannot be reached counts a
+// failure towards its eviction.
+func (pm *PeerManager) Broadcast(method, path string, body []byte) {
+	sem := make(chan struct{}, pm.fanout)
+	var wg sync.WaitGroup
+	for _, p := range pm.Peers() {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(p string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			pm.send(method, p, path, body)
+		}(p)
+	}
+	wg.Wait()
+}
+
+func (pm *PeerManager) send(method, peer, path string, body []byte) {
+	endpoint := fmt.Sprintf("%s%s", peer, path)
+	req, _ := http.NewRequest(method, endpoint, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := pm.client.Do(req)
+	if err != nil {
+		log.Printf("ERROR: %s %s : %s\n", method, endpoint, err.Error())
+		pm.recordFailure(peer)
+		return
+	}
+	resp.Body.Close()
+	pm.recordSuccess(peer)
+	if resp.StatusCode == http.StatusOK {
+		log.Printf("INFO: %s %s\n", method, endpoint)
+	} else {
+		log.Printf("ERROR: %s %s %d\n", method, endpoint, resp.StatusCode)
+	}
+}
+
+// Get requests path from peer with the peer timeout, counting a failure
+// towards its eviction when the peer cannot be reached.
+func (pm *PeerManager) Get(peer, path string) (*http.Response, error) {
+	resp, err := pm.client.Get(fmt.Sprintf("%s%s", peer, path))
+	if err != nil {
+		pm.recordFailure(peer)
+		return nil, err
+	}
+	pm.recordSuccess(peer)
+	return resp, nil
+}
+
+// Handshake introduces this node to the node at u and adds u and the peers
+// it answers with to the peer set, provided u's node key is allowed and u
+// is on our network.
+func (pm *PeerManager) Handshake(u string) error {
+	u = utils.NormalizeURL(u)
+	if u == "" || pm.isSelf(u) {
+		return fmt.Errorf("invalid peer %q", u)
+	}
+	m, _ := json.Marshal(&PeerRequest{URL: &pm.self, ChainID: &pm.network.ChainID, GenesisHash: &pm.network.GenesisHash})
+	resp, err := pm.client.Post(fmt.Sprintf("%s/peers", u), "application/json", bytes.NewReader(m))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("handshake with %s: status %d", u, resp.StatusCode)
+	}
+	var hr HandshakeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&hr); err != nil {
+		return fmt.Errorf("handshake with %s: %w", u, err)
+	}
+	if hr.PublicKey == pm.key.PublicKey() {
+		pm.mux.Lock()
+		pm.aliases[u] = true
+		pm.mux.Unlock()
+		return fmt.Errorf("handshake with %s: it is this node", u)
+	}
+	if !utils.IsBigIntTupleString(hr.PublicKey) || !pm.Allowed(hr.PublicKey) {
+		return fmt.Errorf("handshake with %s: node key not allowed", u)
+	}
+	if hr.Network != pm.network {
+		return fmt.Errorf("handshake with %s: on chain %q with genesis %s", u, hr.ChainID, hr.GenesisHash)
+	}
+	pm.AddPeer(u, hr.PublicKey)
+	pm.recordSuccess(u)
+	pm.discover(hr.Peers)
+	return nil
+}
+
+// discover handshakes the peers we did not know yet, so they learn about
+// us as well. A peer is only added once its handshake succeeds, otherwise a
+// dead peer evicted here would come straight back from another peer's list.
+func (pm *PeerManager) discover(infos []PeerInfo) {
+	for _, info := range infos {
+		u := utils.NormalizeURL(info.URL)
+		if u == "" || pm.isSelf(u) || info.Failures > 0 || !pm.startDial(u) {
+			continue
+		}
+		go func(u string) {
+			defer pm.endDial(u)
+			if err := pm.Handshake(u); err != nil {
+				log.Printf("ERROR: Handshake with %s : %s\n", u, err.Error())
+			}
+		}(u)
+	}
+}
+
+// startDial reports whether u is neither a peer nor being handshaked
+// already, and marks it as being handshaked.
+func (pm *PeerManager) startDial(u string) bool {
+	pm.mux.Lock()
+	defer pm.mux.Unlock()
+	if _, ok := pm.peers[u]; ok || pm.dialing[u] {
+		return false
+	}
+	pm.dialing[u] = true
+	return true
+}
+
+func (pm *PeerManager) endDial(u string) {
+	pm.mux.Lock()
+	defer pm.mux.Unlock()
+	delete(pm.dialing, u)
+}
+
+// CheckHealth asks every peer for its peer list, evicting the ones that do
+// not answer and learning about new ones from those that do. Seeds missing
+// from the set are handshaked again.
+func (pm *PeerManager) CheckHealth() {
+	sem := make(chan struct{}, pm.fanout)
+	var wg sync.WaitGroup
+	for _, p := range pm.Peers() {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(p string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			resp, err := pm.client.Get(fmt.Sprintf("%s/peers", p))
+			if err != nil {
+				pm.recordFailure(p)
+				return
+			}
+			defer resp.Body.Close()
+			var infos []PeerInfo
+			if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&infos) != nil {
+				pm.recordFailure(p)
+				return
+			}
+			pm.recordSuccess(p)
+			pm.discover(infos)
+		}(p)
+	}
+	wg.Wait()
+
+	pm.Bootstrap()
+}
+
+// VerifyPeer asks the node at u who it is and checks that it answers with
+// publicKey on our network. A node that handshakes can only register a URL
+// it serves with its own key, not have us send gossip anywhere it likes.
+func (pm *PeerManager) VerifyPeer(u, publicKey string) error {
+	u = utils.NormalizeURL(u)
+	if u == "" || pm.isSelf(u) {
+		return fmt.Errorf("invalid peer %q", u)
+	}
+	resp, err := pm.client.Get(fmt.Sprintf("%s/node", u))
+	if err != nil {
+		return fmt.Errorf("node at %s: %w", u, err)
+	}
+	defer resp.Body.Close()
+	var info NodeInfo
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&info) != nil {
+		return fmt.Errorf("node at %s: no node info", u)
+	}
+	if info.PublicKey != publicKey {
+		return fmt.Errorf("node at %s has another node key", u)
+	}
+	if info.Network != pm.network {
+		return fmt.Errorf("node at %s is on chain %q with genesis %s", u, info.ChainID, info.GenesisHash)
+	}
+	return nil
+}
+
+// Bootstrap handshakes every seed that is not a peer yet.
+func (pm *PeerManager) Bootstrap() {
+	known := make(map[string]bool)
+	for _, p := range pm.Peers() {
+		known[p] = true
+	}
+	for _, s := range pm.seeds {
+		if known[s] || pm.isSelf(s) {
+			continue
+		}
+		if err := pm.Handshake(s); err != nil {
+			log.Printf("ERROR: Handshake with seed %s : %s\n", s, err.Error())
+		}
+	}
+}
+
+// Start bootstraps from the seeds and runs the health checks every
+// PEER_HEALTH_INTERVAL until Stop.
+func (pm *PeerManager) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	pm.mux.Lock()
+	pm.cancel = cancel
+	pm.mux.Unlock()
+
+	pm.Bootstrap()
+	if pm.interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(pm.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				pm.CheckHealth()
+			}
+		}
+	}()
+}
+
+func (pm *PeerManager) Stop() {
+	pm.mux.Lock()
+	defer pm.mux.Unlock()
+	if pm.cancel != nil {
+		pm.cancel()
+		pm.cancel = nil
+	}
+}
@@ -0,0 +1,91 @@
+package block
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// maxReorgHistory is how many reorg events a node remembers.
+const maxReorgHistory = 50
+
+// ReorgEvent describes one switch to a competing chain. Depth is the number
+// of our blocks that were abandoned above the fork point.
+type ReorgEvent struct {
+	Time       time.Time `json:"time"`
+	ForkHeight int       `json:"fork_height"`
+	Depth      int       `json:"depth"`
+	OldTip     string    `json:"old_tip"`
+	NewTip     string    `json:"new_tip"`
+	Reinjected int       `json:"reinjected"`
+	Evicted    int       `json:"evicted"`
+}
+
+// reorganize replaces our chain with chain, which shares its first fork
+// blocks with ours. Transactions that were only confirmed in the abandoned
+// blocks go back to the pool, unless the new chain confirmed them too or
+// made them invalid, and the pool is re-validated against the new chain.
+// A chain that cannot be indexed or persisted is an error and leaves ours
+// in place. The caller holds bc.mux.
+func (bc *Blockchain) reorganize(chain []*Block, fork int) (ReorgEvent, error) {
+	index := newChainIndex(bc.config.MINING_SENDER)
+	if err := index.rebuild(chain, nil); err != nil {
+		return ReorgEvent{}, err
+	}
+
+	old := bc.chain
+	orphaned := []*Transaction{}
+	for _, b := range old[fork:] {
+		for _, t := range b.transactions {
+			if t.senderBlockchainAddress != bc.config.MINING_SENDER {
+				orphaned = append(orphaned, t)
+			}
+		}
+	}
+
+	if err := bc.store.Replace(chain); err != nil {
+		return ReorgEvent{}, fmt.Errorf("persist replaced chain: %w", err)
+	}
+	bc.chain = chain
+	bc.index = index
+	evicted := bc.resetPool(append(orphaned, bc.pool.transactions...))
+
+	pooled := make(map[string]bool, len(bc.pool.transactions))
+	for _, t := range bc.pool.transactions {
+		pooled[t.ID()] = true
+	}
+	reinjected := 0
+	for _, t := range orphaned {
+		if pooled[t.ID()] {
+			reinjected++
+		}
+	}
+
+	event := ReorgEvent{
+		Time:       time.Now(),
+		ForkHeight: fork - 1,
+		Depth:      len(old) - fork,
+		OldTip:     fmt.Sprintf("%x", old[len(old)-1].Hash()),
+		NewTip:     fmt.Sprintf("%x", chain[len(chain)-1].Hash()),
+		Reinjected: reinjected,
+		Evicted:    len(evicted),
+	}
+	bc.reorgs = append(bc.reorgs, event)
+	if len(bc.reorgs) > maxReorgHistory {
+		bc.reorgs = bc.reorgs[len(bc.reorgs)-maxReorgHistory:]
+	}
+	log.Printf("INFO: Reorg at height %d, depth %d, %d transactions back to the pool, %d evicted\n",
+		event.ForkHeight, event.Depth, event.Reinjected, event.Evicted)
+	bc.events.publish(Event{Type: EventReorg, Data: event})
+	for height := fork; height < len(chain); height++ {
+		bc.publishBlock(chain[height], height)
+	}
+	return event, nil
+}
+
+// Reorgs returns the most recent reorg events, oldest first.
+func (bc *Blockchain) Reorgs() []ReorgEvent {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return append([]ReorgEvent{}, bc.reorgs...)
+}
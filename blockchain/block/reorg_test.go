@@ -0,0 +1,81 @@
+package block
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/EmilioCliff/learn-go/blockchain/utils"
+	"github.com/EmilioCliff/learn-go/blockchain/wallet"
+)
+
+func TestReorgReinjectsTransactions(t *testing.T) {
+	local, remote := newTestBlockchain(t), newTestBlockchain(t)
+	peer := newTestPeer(t, local, remote)
+	alice, bob, carol, dave := wallet.NewWallet(), wallet.NewWallet(), wallet.NewWallet(), wallet.NewWallet()
+	funding := nextBlock(t, remote, alice.BlockchainAddress())
+	if !receive(remote, funding) || !receive(local, funding) {
+		t.Fatal("block funding alice was rejected")
+	}
+
+	// Our fork confirms three transfers.
+	toBob := transfer(local, alice, bob.BlockchainAddress(), utils.Coin/2, 0)
+	toCarol := transfer(local, alice, carol.BlockchainAddress(), utils.Coin/4, 1)
+	fromBob := transfer(local, bob, carol.BlockchainAddress(), utils.Coin/10, 0)
+	miner := wallet.NewWallet().BlockchainAddress()
+	for _, transactions := range [][]*Transaction{{toBob, toCarol}, {fromBob}} {
+		if !receive(local, nextBlock(t, local, miner, transactions...)) {
+			t.Fatal("block of our fork was rejected")
+		}
+	}
+	oldTip := local.Chain()[3].Hash()
+
+	// The heavier fork confirms the first one too but spends alice's next
+	// nonce elsewhere.
+	toDave := transfer(remote, alice, dave.BlockchainAddress(), utils.Coin/4, 1)
+	for _, transactions := range [][]*Transaction{{toBob}, {toDave}, {}} {
+		if !receive(remote, nextBlock(t, remote, miner, transactions...)) {
+			t.Fatal("block of the heavier fork was rejected")
+		}
+	}
+	if !local.syncFrom(peer.URL, -1) {
+		t.Fatal("did not switch to the heavier fork")
+	}
+
+	reorgs := local.Reorgs()
+	if len(reorgs) != 1 {
+		t.Fatalf("%d reorgs, want 1", len(reorgs))
+	}
+	event := reorgs[0]
+	if event.ForkHeight != 1 || event.Depth != 2 || event.Reinjected != 1 || event.Evicted != 1 {
+		t.Fatalf("reorg event %+v", event)
+	}
+	if event.OldTip != fmt.Sprintf("%x", oldTip) {
+		t.Fatalf("old tip %s, want %x", event.OldTip, oldTip)
+	}
+
+	// Only bob's transfer is left to confirm: alice's first one is in the
+	// new chain and her second one lost its nonce.
+	pool := local.TransactionsPool()
+	if len(pool) != 1 || pool[0].ID() != fromBob.ID() {
+		t.Fatalf("pool after the reorg holds %d transactions", len(pool))
+	}
+	for _, tt := range []struct {
+		name string
+		w    *wallet.Wallet
+		want utils.Amount
+	}{
+		{"alice", alice, local.config.MINING_REWARD - utils.Coin/2 - utils.Coin/4},
+		{"bob", bob, utils.Coin/2 - utils.Coin/10},
+		{"carol", carol, utils.Coin / 10},
+		{"dave", dave, utils.Coin / 4},
+	} {
+		if got := local.CalculateTotalAmount(tt.w.BlockchainAddress()); got != tt.want {
+			t.Errorf("%s balance = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	// The re-injected transaction makes it into the next block.
+	if !receive(local, nextBlock(t, local, miner, fromBob)) || len(local.TransactionsPool()) != 0 {
+		t.Fatal("re-injected transaction was not confirmed")
+	}
+}
@@ -0,0 +1,234 @@
+package block
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Store persists the blocks of a chain. Blocks are only ever appended one
+// at a time, except when consensus swaps the whole chain via Replace.
+type Store interface {
+	Load() ([]*Block, error)
+	Append(b *Block) error
+	Replace(chain []*Block) error
+	Close() error
+}
+
+// MemoryStore keeps blocks in memory only, nothing survives a restart.
+type MemoryStore struct {
+	mux    sync.Mutex
+	blocks []*Block
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{blocks: []*Block{}}
+}
+
+func (ms *MemoryStore) Load() ([]*Block, error) {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	return append([]*Block{}, ms.blocks...), nil
+}
+
+func (ms *MemoryStore) Append(b *Block) error {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	ms.blocks = append(ms.blocks, b)
+	return nil
+}
+
+func (ms *MemoryStore) Replace(chain []*Block) error {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	ms.blocks = append([]*Block{}, chain...)
+	return nil
+}
+
+func (ms *MemoryStore) Close() error {
+	return nil
+}
+
+const (
+	// recordHeaderSize is the length prefix plus the CRC32 checksum written
+	// in front of every block record.
+	recordHeaderSize = 8
+	// maxRecordSize guards against allocating garbage lengths read from a
+	// corrupted header.
+	maxRecordSize = 64 << 20
+)
+
+// FileStore is an append-only file of length prefixed, checksummed JSON
+// blocks. A record that was only partially written when the process died is
+// detected on Load and cut off the end of the file.
+type FileStore struct {
+	mux  sync.Mutex
+	path string
+	file *os.File
+}
+
+func NewFileStore(path string) (*FileStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create data dir: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open block store: %w", err)
+	}
+	return &FileStore{path: path, file: f}, nil
+}
+
+func (fs *FileStore) Load() ([]*Block, error) {
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+
+	if _, err := fs.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	chain := []*Block{}
+	r := bufio.NewReader(fs.file)
+	var offset int64
+	for {
+		b, n, err := readRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("WARN: Drop corrupted block store tail at offset %d: %s\n", offset, err.Error())
+			if err := fs.file.Truncate(offset); err != nil {
+				return nil, fmt.Errorf("failed to truncate block store: %w", err)
+			}
+			break
+		}
+		chain = append(chain, b)
+		offset += n
+	}
+
+	if _, err := fs.file.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return chain, nil
+}
+
+// Append writes b at the end of the file. A record that failed half way is
+// cut off again so the next one does not land behind it.
+func (fs *FileStore) Append(b *Block) error {
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+
+	offset, err := fs.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	err = writeRecord(fs.file, b)
+	if err == nil {
+		err = fs.file.Sync()
+	}
+	if err != nil {
+		if terr := fs.file.Truncate(offset); terr != nil {
+			return errors.Join(err, fmt.Errorf("cut off the partial record: %w", terr))
+		}
+		return err
+	}
+	return nil
+}
+
+// Replace rewrites the store into a temporary file and renames it over the
+// old one so a crash never leaves a mix of the old and new chain behind. The
+// temporary file is removed again when that fails.
+func (fs *FileStore) Replace(chain []*Block) error {
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+
+	tmpPath := fs.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	err = writeChain(tmp, chain)
+	if err == nil {
+		err = os.Rename(tmpPath, fs.path)
+	}
+	if err != nil {
+		tmp.Close()
+		if rerr := os.Remove(tmpPath); rerr != nil && !errors.Is(rerr, os.ErrNotExist) {
+			return errors.Join(err, rerr)
+		}
+		return err
+	}
+
+	fs.file.Close()
+	fs.file = tmp
+	_, err = fs.file.Seek(0, io.SeekEnd)
+	return err
+}
+
+func (fs *FileStore) Close() error {
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+	return fs.file.Close()
+}
+
+// writeChain writes the records of chain to f and syncs it.
+func writeChain(f *os.File, chain []*Block) error {
+	w := bufio.NewWriter(f)
+	for _, b := range chain {
+		if err := writeRecord(w, b); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func writeRecord(w io.Writer, b *Block) error {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, recordHeaderSize)
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(header[4:], crc32.ChecksumIEEE(data))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func readRecord(r io.Reader) (*Block, int64, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, 0, err
+	}
+	size := binary.BigEndian.Uint32(header[:4])
+	if size > maxRecordSize {
+		return nil, 0, fmt.Errorf("record size %d too large", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, 0, errors.New("checksum mismatch")
+	}
+	b := new(Block)
+	if err := json.Unmarshal(data, b); err != nil {
+		return nil, 0, err
+	}
+	return b, int64(recordHeaderSize) + int64(size), nil
+}
@@ -0,0 +1,172 @@
+package block
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/EmilioCliff/learn-go/blockchain/wallet"
+)
+
+// testChain returns a chain of n blocks past the genesis block.
+func testChain(t *testing.T, n int) []*Block {
+	t.Helper()
+	bc := newTestBlockchain(t)
+	miner := wallet.NewWallet().BlockchainAddress()
+	for range n {
+		if !receive(bc, nextBlock(t, bc, miner)) {
+			t.Fatal("block was rejected")
+		}
+	}
+	return bc.Chain()
+}
+
+func loadFileStore(t *testing.T, path string) []*Block {
+	t.Helper()
+	fs, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	chain, err := fs.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chain
+}
+
+func TestFileStoreTruncatesTail(t *testing.T) {
+	chain := testChain(t, 2)
+	path := filepath.Join(t.TempDir(), "blocks.dat")
+	fs, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Replace(chain); err != nil {
+		t.Fatal(err)
+	}
+	fs.Close()
+	info, _ := os.Stat(path)
+	size := info.Size()
+
+	for name, tail := range map[string]func([]byte) []byte{
+		// The process died while writing the header or the body of the
+		// next record.
+		"partial header": func(data []byte) []byte { return append(data, 0, 0, 1) },
+		"partial body":   func(data []byte) []byte { return append(data, 0, 0, 1, 0, 1, 2, 3, 4, '{') },
+		// The last record was flushed with a torn sector.
+		"bad checksum": func(data []byte) []byte {
+			data[len(data)-2] ^= 0xff
+			return data
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			fs, _ := NewFileStore(path)
+			fs.Replace(chain)
+			fs.Close()
+			data, _ := os.ReadFile(path)
+			os.WriteFile(path, tail(data), 0o644)
+
+			want := len(chain)
+			if name == "bad checksum" {
+				want--
+			}
+			loaded := loadFileStore(t, path)
+			if len(loaded) != want {
+				t.Fatalf("loaded %d blocks, want %d", len(loaded), want)
+			}
+			for i, b := range loaded {
+				if b.Hash() != chain[i].Hash() {
+					t.Fatalf("block %d hash %x, want %x", i, b.Hash(), chain[i].Hash())
+				}
+			}
+			if info, _ := os.Stat(path); want == len(chain) && info.Size() != size {
+				t.Fatalf("store is %d bytes after the truncation, want %d", info.Size(), size)
+			}
+
+			// Appending after the truncation lands right behind the last
+			// good record.
+			fs, _ = NewFileStore(path)
+			fs.Load()
+			if err := fs.Append(chain[len(chain)-1]); err != nil {
+				t.Fatal(err)
+			}
+			fs.Close()
+			if loaded := loadFileStore(t, path); len(loaded) != want+1 {
+				t.Fatalf("loaded %d blocks after an append, want %d", len(loaded), want+1)
+			}
+		})
+	}
+}
+
+func TestFileStoreReplaceCleansUp(t *testing.T) {
+	chain := testChain(t, 1)
+	path := filepath.Join(t.TempDir(), "blocks.dat")
+	fs, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	// The store cannot be renamed over a directory.
+	os.Remove(path)
+	os.MkdirAll(filepath.Join(path, "taken"), 0o755)
+	if err := fs.Replace(chain); err == nil {
+		t.Fatal("replace over a directory succeeded")
+	}
+	if _, err := os.Stat(path + ".tmp"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("temporary file left behind: %v", err)
+	}
+}
+
+// failingStore is a MemoryStore whose writes fail while fail is set.
+type failingStore struct {
+	*MemoryStore
+	fail bool
+}
+
+var errStoreFull = errors.New("disk full")
+
+func (fs *failingStore) Append(b *Block) error {
+	if fs.fail {
+		return errStoreFull
+	}
+	return fs.MemoryStore.Append(b)
+}
+
+func (fs *failingStore) Replace(chain []*Block) error {
+	if fs.fail {
+		return errStoreFull
+	}
+	return fs.MemoryStore.Replace(chain)
+}
+
+func TestCreateBlockStoreError(t *testing.T) {
+	store := &failingStore{MemoryStore: NewMemoryStore()}
+	bc := newTestBlockchainWithStore(t, store)
+	miner := wallet.NewWallet().BlockchainAddress()
+	b := nextBlock(t, bc, miner)
+
+	store.fail = true
+	if _, err := bc.CreateBlock(b.Header(), b.transactions); !errors.Is(err, errStoreFull) {
+		t.Fatalf("CreateBlock with a failing store: %v", err)
+	}
+	if n := bc.BlockCount(); n != 1 {
+		t.Fatalf("chain has %d blocks after a failed append, want 1", n)
+	}
+	if got := bc.CalculateTotalAmount(miner); got != 0 {
+		t.Fatalf("miner balance after a failed append = %s, want 0", got)
+	}
+
+	store.fail = false
+	if _, err := bc.CreateBlock(b.Header(), b.transactions); err != nil {
+		t.Fatal(err)
+	}
+	if got := bc.CalculateTotalAmount(miner); got != bc.config.MINING_REWARD {
+		t.Fatalf("miner balance = %s, want %s", got, bc.config.MINING_REWARD)
+	}
+	if stored, _ := store.Load(); len(stored) != 2 {
+		t.Fatalf("store has %d blocks, want 2", len(stored))
+	}
+}
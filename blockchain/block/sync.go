@@ -0,0 +1,231 @@
+package block
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// maxBlocksPerPage caps how many blocks GET /blocks returns at once, a
+// syncing node pages through longer ranges.
+const maxBlocksPerPage = 500
+
+// syncMargin is how many blocks past the height it announced a syncing node
+// downloads from a peer, for the blocks the peer adds in the meantime.
+const syncMargin = 100
+
+// BlockAnnouncement is the body of PUT /blocks, sent to the peers whenever a
+// node mines or accepts a new tip. The node relaying it is known to have
+// every ancestor of the block.
+type BlockAnnouncement struct {
+	Block  *Block `json:"block"`
+	Height *int   `json:"height"`
+}
+
+func (ba *BlockAnnouncement) Validate() bool {
+	if ba.Block == nil || ba.Height == nil || *ba.Height < 1 {
+		return false
+	}
+	return true
+}
+
+func blockGossipID(b *Block) string {
+	return fmt.Sprintf("block:%x", b.Hash())
+}
+
+// announceBlock gossips b, the block at height, to the peers.
+func (bc *Blockchain) announceBlock(b *Block, height int) {
+	bc.peers.MarkSeen(blockGossipID(b))
+	m, _ := json.Marshal(&BlockAnnouncement{Block: b, Height: &height})
+	bc.peers.Broadcast("PUT", "/blocks", m)
+}
+
+// ReceiveBlock handles a block announced by a peer and reports whether our
+// chain changed. A block on top of our tip is checked and appended on the
+// spot; a block we cannot connect means we are behind or on another fork,
+// and we sync from origin, the URL the announcing peer registered at,
+// starting at our common ancestor. Blocks that change our chain are relayed
+// to our own peers.
+func (bc *Blockchain) ReceiveBlock(b *Block, height int, origin string) bool {
+	// A body that does not match its header says nothing about the block
+	// with that hash, it must not keep the real one out as already seen.
+	if !b.consistent() {
+		log.Printf("ERROR: Block %x announced by %s does not match its header\n", b.Hash(), origin)
+		return false
+	}
+	if !bc.peers.MarkSeen(blockGossipID(b)) {
+		return false
+	}
+
+	bc.mux.Lock()
+	if height < len(bc.chain) && bc.chain[height].Hash() == b.Hash() {
+		bc.mux.Unlock()
+		return false
+	}
+	if height == len(bc.chain) && b.previousHash == bc.lastBlock().Hash() {
+		if !bc.validNextBlock(bc.chain, bc.index, b) {
+			bc.mux.Unlock()
+			log.Printf("ERROR: Invalid block %x announced by %s\n", b.Hash(), origin)
+			return false
+		}
+		appended, err := bc.appendBlock(b.Header(), b.transactions)
+		if err != nil {
+			bc.mux.Unlock()
+			log.Printf("ERROR: Invalid block %x announced by %s: %s\n", b.Hash(), origin, err.Error())
+			return false
+		}
+		bc.interruptSearch()
+		bc.mux.Unlock()
+
+		log.Printf("INFO: Accepted block %d from %s\n", height, origin)
+		bc.announceBlock(appended, height)
+		return true
+	}
+	bc.mux.Unlock()
+
+	if !bc.syncFrom(origin, height) {
+		return false
+	}
+	bc.mux.RLock()
+	tip, tipHeight := bc.lastBlock(), len(bc.chain)-1
+	bc.mux.RUnlock()
+	bc.announceBlock(tip, tipHeight)
+	return true
+}
+
+// Blocks returns up to limit blocks starting at height from.
+func (bc *Blockchain) Blocks(from, limit int) []*Block {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	if limit <= 0 || limit > maxBlocksPerPage {
+		limit = maxBlocksPerPage
+	}
+	if from < 0 || from >= len(bc.chain) {
+		return []*Block{}
+	}
+	to := min(from+limit, len(bc.chain))
+	return append([]*Block{}, bc.chain[from:to]...)
+}
+
+// fetchBlocks gets up to limit blocks of peer starting at height from, and
+// the length of its chain from X-Total-Count, -1 when it is missing.
+func (bc *Blockchain) fetchBlocks(peer string, from, limit int) ([]*Block, int, error) {
+	resp, err := bc.peers.Get(peer, fmt.Sprintf("/blocks?from=%d&limit=%d", from, limit))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("get blocks from %s: status %d", peer, resp.StatusCode)
+	}
+	var blocks []*Block
+	if err := json.NewDecoder(resp.Body).Decode(&blocks); err != nil {
+		return nil, 0, fmt.Errorf("get blocks from %s: %w", peer, err)
+	}
+	total, err := strconv.Atoi(resp.Header.Get("X-Total-Count"))
+	if err != nil {
+		total = -1
+	}
+	return blocks, total, nil
+}
+
+// commonAncestor returns the height of the last block chain shares with
+// peer, or -1 when even the genesis blocks differ. Equal blocks at a height
+// mean equal chains below it, so it binary searches with one block probes.
+func (bc *Blockchain) commonAncestor(peer string, chain []*Block) (int, error) {
+	matches := func(height int) (bool, error) {
+		blocks, _, err := bc.fetchBlocks(peer, height, 1)
+		if err != nil {
+			return false, err
+		}
+		return len(blocks) == 1 && blocks[0].Hash() == chain[height].Hash(), nil
+	}
+
+	// Most of the time the peer simply extends our tip.
+	tip := len(chain) - 1
+	if ok, err := matches(tip); err != nil || ok {
+		return tip, err
+	}
+	lo, hi := -1, tip-1
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		ok, err := matches(mid)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return lo, nil
+}
+
+// syncFrom downloads the blocks peer has after our common ancestor and
+// switches to the resulting chain when it is valid and carries more work
+// than ours. height is the tip the peer announced, or -1 to take the length
+// of its chain from the first page. The download stops at syncMargin blocks
+// past that height, and every page is validated as it comes in, so a peer
+// cannot keep us downloading blocks that go nowhere.
+func (bc *Blockchain) syncFrom(peer string, height int) bool {
+	chain := bc.Chain()
+	ancestor, err := bc.commonAncestor(peer, chain)
+	if err != nil {
+		log.Printf("ERROR: Sync with %s : %s\n", peer, err.Error())
+		return false
+	}
+	if ancestor < 0 {
+		log.Printf("ERROR: Chain from %s starts from another genesis block\n", peer)
+		return false
+	}
+
+	candidate := append([]*Block{}, chain[:ancestor+1]...)
+	index := newChainIndex(bc.config.MINING_SENDER)
+	if err := index.rebuild(candidate, nil); err != nil {
+		log.Printf("ERROR: Index chain: %s\n", err.Error())
+		return false
+	}
+	for {
+		blocks, total, err := bc.fetchBlocks(peer, len(candidate), maxBlocksPerPage)
+		if err != nil {
+			log.Printf("ERROR: Sync with %s : %s\n", peer, err.Error())
+			return false
+		}
+		if height < 0 {
+			if total < 0 {
+				log.Printf("ERROR: Sync with %s : no chain length\n", peer)
+				return false
+			}
+			height = total - 1
+		}
+		if len(candidate)+len(blocks) > height+1+syncMargin {
+			log.Printf("ERROR: Chain from %s goes past the announced height %d\n", peer, height)
+			return false
+		}
+		for _, b := range blocks {
+			if !bc.validNextBlock(candidate, index, b) {
+				log.Printf("ERROR: Chain from %s is invalid at block %d\n", peer, len(candidate))
+				return false
+			}
+			if err := index.applyBlock(b, len(candidate)); err != nil {
+				log.Printf("ERROR: Chain from %s is invalid at block %d: %s\n", peer, len(candidate), err.Error())
+				return false
+			}
+			candidate = append(candidate, b)
+		}
+		if len(blocks) < maxBlocksPerPage {
+			break
+		}
+	}
+	if len(candidate) == ancestor+1 || ChainWork(candidate).Cmp(ChainWork(chain)) <= 0 {
+		return false
+	}
+	if !bc.replaceChain(candidate) {
+		return false
+	}
+	log.Printf("INFO: Synced with %s from block %d to %d\n", peer, ancestor+1, len(candidate)-1)
+	return true
+}
@@ -0,0 +1,211 @@
+package block
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/EmilioCliff/learn-go/blockchain/utils"
+	"github.com/EmilioCliff/learn-go/blockchain/wallet"
+)
+
+// testPeer is a peer of the node under test that serves the blocks of
+// remote, when set, and counts the gossip it receives by path.
+type testPeer struct {
+	*httptest.Server
+	remote *Blockchain
+
+	mux      sync.Mutex
+	received map[string]int
+	probes   int
+}
+
+func newTestPeer(t *testing.T, bc, remote *Blockchain) *testPeer {
+	t.Helper()
+	tp := &testPeer{remote: remote, received: map[string]int{}}
+	tp.Server = httptest.NewServer(http.HandlerFunc(tp.serve))
+	t.Cleanup(tp.Close)
+	key, _ := NewNodeKey()
+	if !bc.Peers().AddPeer(tp.URL, key.PublicKey()) {
+		t.Fatalf("peer %s was not added", tp.URL)
+	}
+	return tp
+}
+
+func (tp *testPeer) serve(w http.ResponseWriter, r *http.Request) {
+	tp.mux.Lock()
+	defer tp.mux.Unlock()
+	if r.Method != http.MethodGet {
+		tp.received[r.URL.Path]++
+		return
+	}
+	if r.URL.Path != "/blocks" || tp.remote == nil {
+		http.NotFound(w, r)
+		return
+	}
+	from, _ := strconv.Atoi(r.URL.Query().Get("from"))
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit == 1 {
+		tp.probes++
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(tp.remote.BlockCount()))
+	json.NewEncoder(w).Encode(tp.remote.Blocks(from, limit))
+}
+
+// takeProbes returns how many single block probes were served since the
+// last call.
+func (tp *testPeer) takeProbes() int {
+	tp.mux.Lock()
+	defer tp.mux.Unlock()
+	probes := tp.probes
+	tp.probes = 0
+	return probes
+}
+
+func (tp *testPeer) count(path string) int {
+	tp.mux.Lock()
+	defer tp.mux.Unlock()
+	return tp.received[path]
+}
+
+func TestGossipDedup(t *testing.T) {
+	bc := newTestBlockchain(t)
+	peer := newTestPeer(t, bc, nil)
+	alice, bob := wallet.NewWallet(), wallet.NewWallet()
+
+	b := nextBlock(t, bc, alice.BlockchainAddress())
+	if !receive(bc, b) {
+		t.Fatal("block was rejected")
+	}
+	// The same block coming back from other peers, at its height or
+	// announced as something else, is not relayed again.
+	if receive(bc, b) || bc.ReceiveBlock(b, 7, "http://127.0.0.1:2") {
+		t.Fatal("block was accepted twice")
+	}
+	if n := peer.count("/blocks"); n != 1 {
+		t.Fatalf("block was relayed %d times", n)
+	}
+
+	tx := transfer(bc, alice, bob.BlockchainAddress(), utils.Coin/10, 0)
+	if !bc.CreateTransaction(tx, tx.witness) {
+		t.Fatal("transaction was rejected")
+	}
+	if bc.CreateTransaction(tx, tx.witness) {
+		t.Fatal("transaction was accepted twice")
+	}
+	if n := peer.count("/transactions"); n != 1 {
+		t.Fatalf("transaction was relayed %d times", n)
+	}
+
+	// A mined block is marked seen before it is announced, a peer echoing it
+	// back is ignored.
+	mined := nextBlock(t, bc, bob.BlockchainAddress(), tx)
+	if _, err := bc.CreateBlock(mined.Header(), mined.transactions); err != nil {
+		t.Fatal(err)
+	}
+	if receive(bc, mined) {
+		t.Fatal("announced block echoed back was accepted")
+	}
+	if n := peer.count("/blocks"); n != 2 {
+		t.Fatalf("blocks were relayed %d times, want 2", n)
+	}
+}
+
+// TestSeenPruned checks that old gossip IDs are forgotten as new ones come
+// in, without health checks.
+func TestSeenPruned(t *testing.T) {
+	pm := newTestBlockchain(t).Peers()
+	pm.mux.Lock()
+	pm.seen["old"] = time.Now().Add(-2 * gossipTTL)
+	pm.pruned = time.Now().Add(-2 * gossipTTL)
+	pm.mux.Unlock()
+
+	if !pm.MarkSeen("new") || pm.MarkSeen("new") {
+		t.Fatal("new ID was not marked once")
+	}
+	if pm.Seen("old") {
+		t.Fatal("ID older than the gossip TTL is still remembered")
+	}
+}
+
+// extend mines n blocks on top of bc and hands them to every node in
+// others too.
+func extend(t *testing.T, bc *Blockchain, n int, others ...*Blockchain) {
+	t.Helper()
+	miner := wallet.NewWallet().BlockchainAddress()
+	for range n {
+		b := nextBlock(t, bc, miner)
+		for _, node := range append([]*Blockchain{bc}, others...) {
+			if !receive(node, b) {
+				t.Fatal("block was rejected")
+			}
+		}
+	}
+}
+
+func TestCommonAncestorSync(t *testing.T) {
+	local, remote := newTestBlockchain(t), newTestBlockchain(t)
+	peer := newTestPeer(t, local, remote)
+	extend(t, remote, 20, local)
+
+	// A peer that extends our tip is found with a single probe.
+	extend(t, remote, 3)
+	ancestor, err := local.commonAncestor(peer.URL, local.Chain())
+	if probes := peer.takeProbes(); err != nil || ancestor != 20 || probes != 1 {
+		t.Fatalf("ancestor %d after %d probes: %v, want 20 after 1", ancestor, probes, err)
+	}
+
+	// Forks are found by bisection.
+	extend(t, local, 5)
+	ancestor, err = local.commonAncestor(peer.URL, local.Chain())
+	if probes := peer.takeProbes(); err != nil || ancestor != 20 || probes > 6 {
+		t.Fatalf("ancestor %d after %d probes: %v, want 20", ancestor, probes, err)
+	}
+	// The remote chain is lighter, nothing changes.
+	if local.syncFrom(peer.URL, -1) || local.BlockCount() != 26 {
+		t.Fatalf("synced to a lighter chain, %d blocks", local.BlockCount())
+	}
+
+	extend(t, remote, 4)
+	tip := remote.Chain()[remote.BlockCount()-1]
+	if !local.ReceiveBlock(tip, remote.BlockCount()-1, peer.URL) {
+		t.Fatal("announcement of a heavier fork did not sync")
+	}
+	localChain, remoteChain := local.Chain(), remote.Chain()
+	if len(localChain) != len(remoteChain) || localChain[len(localChain)-1].Hash() != tip.Hash() {
+		t.Fatalf("synced to %d blocks, want %d", len(localChain), len(remoteChain))
+	}
+
+	// A node on another chain shares no block with us.
+	other := newTestBlockchain(t)
+	other.mux.Lock()
+	other.chain[0] = NewBlock(NewHeader([32]byte{1}, 1, nil), nil)
+	other.mux.Unlock()
+	stranger := newTestPeer(t, local, other)
+	if ancestor, err := local.commonAncestor(stranger.URL, local.Chain()); err != nil || ancestor != -1 {
+		t.Fatalf("ancestor with another chain %d: %v", ancestor, err)
+	}
+	if local.syncFrom(stranger.URL, -1) {
+		t.Fatal("synced with another chain")
+	}
+}
+
+// TestSyncCapped checks that a peer cannot make us download past the
+// height it announced.
+func TestSyncCapped(t *testing.T) {
+	t.Setenv("RETARGET_INTERVAL", "0")
+	local, remote := newTestBlockchain(t), newTestBlockchain(t)
+	peer := newTestPeer(t, local, remote)
+	extend(t, remote, syncMargin+2)
+
+	if local.syncFrom(peer.URL, 1) || local.BlockCount() != 1 {
+		t.Fatalf("synced %d blocks past the announced height 1", local.BlockCount())
+	}
+	if !local.syncFrom(peer.URL, -1) || local.BlockCount() != remote.BlockCount() {
+		t.Fatalf("synced to %d blocks, want %d", local.BlockCount(), remote.BlockCount())
+	}
+}
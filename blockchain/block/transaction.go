@@ -1,78 +1,278 @@
 package block
 
 import (
+	"crypto/ecdsa"
 	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
+
+	"github.com/EmilioCliff/learn-go/blockchain/address"
+	"github.com/EmilioCliff/learn-go/blockchain/canonical"
+	"github.com/EmilioCliff/learn-go/blockchain/utils"
 )
 
 type Transaction struct {
+	chainID                    string
 	senderBlockchainAddress    string
 	recipientBlockchainAddress string
 	timestamp                  int64
-	value                      float32
+	value                      utils.Amount
+	fee                        utils.Amount
+	nonce                      uint64
+	unlockHeight               uint64
+	unlockTime                 int64
+	// witness authorizes the transaction, it is not part of what the
+	// sender signs nor of the ID.
+	witness *Witness
+}
+
+func NewTransaction(chainID string, sender string, recipient string, value utils.Amount, nonce uint64) *Transaction {
+	return &Transaction{chainID: chainID, senderBlockchainAddress: sender, recipientBlockchainAddress: recipient, timestamp: time.Now().Unix(), value: value, nonce: nonce}
+}
+
+// ID is the hex SHA-256 of the canonical encoding, identical on every node
+// that holds the transaction.
+func (t *Transaction) ID() string {
+	return fmt.Sprintf("%x", t.hash())
+}
+
+// hash is the raw form of ID, used as the transaction's Merkle leaf.
+func (t *Transaction) hash() [32]byte {
+	return t.canonical().Hash()
+}
+
+func (t *Transaction) Nonce() uint64 {
+	return t.nonce
+}
+
+func (t *Transaction) ChainID() string {
+	return t.chainID
+}
+
+// Fee is what the sender pays the miner on top of the value.
+func (t *Transaction) Fee() utils.Amount {
+	return t.fee
 }
 
-func NewTransaction(sender string, recipient string, value float32) *Transaction {
-	return &Transaction{sender, recipient, time.Now().Unix(), value}
+// Size is the length of the canonical encoding in bytes, what a block
+// spends of MAX_BLOCK_SIZE on t.
+func (t *Transaction) Size() int {
+	return len(t.canonical().Encode())
+}
+
+// FeeRate is the fee in base units per byte of Size, miners pick the
+// transactions paying the highest rate first.
+func (t *Transaction) FeeRate() float64 {
+	return float64(t.fee) / float64(t.Size())
+}
+
+// cost is what t takes from the sender's balance, an error when the value
+// and the fee add up to more than an Amount holds.
+func (t *Transaction) cost() (utils.Amount, error) {
+	return t.value.Add(t.fee)
+}
+
+// Locked reports whether the value of t is still locked for its recipient
+// in the block at height, whose block time is unixTime.
+func (t *Transaction) Locked(height uint64, unixTime int64) bool {
+	return height < t.unlockHeight || unixTime < t.unlockTime
+}
+
+// VerifySignature reports whether s signs t with senderPublicKey, it says
+// nothing about whether the key owns the sender address.
+func (t *Transaction) VerifySignature(senderPublicKey *ecdsa.PublicKey, s *utils.Signature) bool {
+	return t.canonical().Verify(senderPublicKey, s)
+}
+
+// canonical is what the sender signs, see the canonical package.
+func (t *Transaction) canonical() *canonical.Transaction {
+	return &canonical.Transaction{
+		ChainID:   t.chainID,
+		Sender:    t.senderBlockchainAddress,
+		Recipient: t.recipientBlockchainAddress,
+		Value:     t.value,
+		Fee:       t.fee,
+		Nonce:     t.nonce,
+		Timestamp: t.timestamp,
+
+		UnlockHeight: t.unlockHeight,
+		UnlockTime:   t.unlockTime,
+	}
 }
 
+// TransactionRequest is a transaction with its witness: SenderPublicKey and
+// Signature for a single key sender, Policy and Signatures, by public key,
+// for a multisig sender.
 type TransactionRequest struct {
-	SenderBlockchainAddress    *string `json:"sender_blockchain_address"`
-	RecipientBlockchainAddress *string `json:"recipient_blockchain_address"`
-	SenderPublicKey            *string `json:"sender_public_key"`
-	Value                      float32 `json:"value"`
-	Signature                  *string `json:"signature"`
+	ChainID                    *string           `json:"chain_id"`
+	SenderBlockchainAddress    *string           `json:"sender_blockchain_address"`
+	RecipientBlockchainAddress *string           `json:"recipient_blockchain_address"`
+	SenderPublicKey            *string           `json:"sender_public_key,omitempty"`
+	Value                      utils.Amount      `json:"value"`
+	Fee                        utils.Amount      `json:"fee"`
+	Nonce                      *uint64           `json:"nonce"`
+	Timestamp                  *int64            `json:"timestamp"`
+	UnlockHeight               *uint64           `json:"unlock_height,omitempty"`
+	UnlockTime                 *int64            `json:"unlock_time,omitempty"`
+	Signature                  *string           `json:"signature,omitempty"`
+	Policy                     *address.Multisig `json:"policy,omitempty"`
+	Signatures                 map[string]string `json:"signatures,omitempty"`
 }
 
 func (tr *TransactionRequest) Validate() bool {
-	if tr.SenderBlockchainAddress == nil || tr.RecipientBlockchainAddress == nil || tr.SenderPublicKey == nil || tr.Value <= 0 || tr.Signature == nil {
+	if tr.ChainID == nil || tr.SenderBlockchainAddress == nil || tr.RecipientBlockchainAddress == nil || tr.Value <= 0 || tr.Fee < 0 || tr.Nonce == nil || tr.Timestamp == nil {
 		return false
 	}
+	if address.Validate(*tr.SenderBlockchainAddress) != nil || address.Validate(*tr.RecipientBlockchainAddress) != nil {
+		return false
+	}
+	if tr.UnlockTime != nil && *tr.UnlockTime < 0 {
+		return false
+	}
+	if _, err := tr.Value.Add(tr.Fee); err != nil {
+		return false
+	}
+	if !address.IsMultisig(*tr.SenderBlockchainAddress) {
+		return tr.SenderPublicKey != nil && tr.Signature != nil &&
+			utils.IsBigIntTupleString(*tr.SenderPublicKey) && utils.IsBigIntTupleString(*tr.Signature)
+	}
+	if tr.Policy == nil || tr.Policy.Validate() != nil || len(tr.Signatures) == 0 {
+		return false
+	}
+	for publicKey, s := range tr.Signatures {
+		if !utils.IsBigIntTupleString(publicKey) || publicKey != strings.ToLower(publicKey) || !utils.IsBigIntTupleString(s) {
+			return false
+		}
+	}
 	return true
 }
 
+// Witness returns the witness of a validated request.
+func (tr *TransactionRequest) Witness() *Witness {
+	if tr.Policy == nil {
+		return NewWitness(utils.PublicKeyFromString(*tr.SenderPublicKey), utils.SignatureFromString(*tr.Signature))
+	}
+	w := &Witness{Policy: tr.Policy, Signatures: make(map[string]*utils.Signature, len(tr.Signatures))}
+	for publicKey, s := range tr.Signatures {
+		w.Signatures[publicKey] = utils.SignatureFromString(s)
+	}
+	return w
+}
+
+// Transaction rebuilds the signed transaction exactly as the sender created
+// it, keeping its timestamp so the signature and ID still match.
+func (tr *TransactionRequest) Transaction() *Transaction {
+	t := &Transaction{
+		chainID:                    *tr.ChainID,
+		senderBlockchainAddress:    *tr.SenderBlockchainAddress,
+		recipientBlockchainAddress: *tr.RecipientBlockchainAddress,
+		timestamp:                  *tr.Timestamp,
+		value:                      tr.Value,
+		fee:                        tr.Fee,
+		nonce:                      *tr.Nonce,
+	}
+	if tr.UnlockHeight != nil {
+		t.unlockHeight = *tr.UnlockHeight
+	}
+	if tr.UnlockTime != nil {
+		t.unlockTime = *tr.UnlockTime
+	}
+	return t
+}
+
+// TransactionStatusResponse reports where a transaction is, BlockHeight and
+// BlockHash are only set once it is confirmed.
+type TransactionStatusResponse struct {
+	Transaction *Transaction `json:"transaction"`
+	Status      string       `json:"status"`
+	BlockHeight *int         `json:"block_height,omitempty"`
+	BlockHash   string       `json:"block_hash,omitempty"`
+}
+
+const (
+	TransactionPending   = "pending"
+	TransactionConfirmed = "confirmed"
+)
+
+// NonceResponse tells a wallet what it needs to sign its next transaction.
+type NonceResponse struct {
+	BlockchainAddress string `json:"blockchain_address"`
+	ChainID           string `json:"chain_id"`
+	Nonce             uint64 `json:"nonce"`
+}
+
+// AmountResponse is the balance of an address, Spendable leaves out value
+// that is still time locked.
 type AmountResponse struct {
-	BlockchainAddress string  `json:"blockchain_address"`
-	Amount            float32 `json:"amount"`
+	BlockchainAddress string       `json:"blockchain_address"`
+	Amount            utils.Amount `json:"amount"`
+	Spendable         utils.Amount `json:"spendable"`
 }
 
 func (ar *AmountResponse) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
-		BlockchainAddress string  `json:"blockchain_address"`
-		Amount            float32 `json:"amount"`
+		BlockchainAddress string       `json:"blockchain_address"`
+		Amount            utils.Amount `json:"amount"`
+		Spendable         utils.Amount `json:"spendable"`
 	}{
 		BlockchainAddress: ar.BlockchainAddress,
 		Amount:            ar.Amount,
+		Spendable:         ar.Spendable,
 	})
 }
 
 func (t *Transaction) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
-		SenderBlockchainAddress    string  `json:"sender_blockchain_address"`
-		RecipientBlockchainAddress string  `json:"recipient_blockchain_address"`
-		Value                      float32 `json:"value"`
-		Timestamp                  int64   `json:"timestamp"`
+		ID                         string       `json:"id"`
+		ChainID                    string       `json:"chain_id"`
+		SenderBlockchainAddress    string       `json:"sender_blockchain_address"`
+		RecipientBlockchainAddress string       `json:"recipient_blockchain_address"`
+		Value                      utils.Amount `json:"value"`
+		Fee                        utils.Amount `json:"fee"`
+		Nonce                      uint64       `json:"nonce"`
+		Timestamp                  int64        `json:"timestamp"`
+		UnlockHeight               uint64       `json:"unlock_height,omitempty"`
+		UnlockTime                 int64        `json:"unlock_time,omitempty"`
+		Witness                    *Witness     `json:"witness,omitempty"`
 	}{
+		ID:                         t.ID(),
+		ChainID:                    t.chainID,
 		SenderBlockchainAddress:    t.senderBlockchainAddress,
 		RecipientBlockchainAddress: t.recipientBlockchainAddress,
 		Value:                      t.value,
+		Fee:                        t.fee,
+		Nonce:                      t.nonce,
 		Timestamp:                  t.timestamp,
+		UnlockHeight:               t.unlockHeight,
+		UnlockTime:                 t.unlockTime,
+		Witness:                    t.witness,
 	})
 }
 
 func (t *Transaction) UnmarshalJSON(data []byte) error {
 	v := &struct {
-		SenderBlockchainAddress    *string  `json:"sender_blockchain_address"`
-		RecipientBlockchainAddress *string  `json:"recipient_blockchain_address"`
-		Value                      *float32 `json:"value"`
-		Timestamp                  *int64   `json:"timestamp"`
+		ChainID                    *string       `json:"chain_id"`
+		SenderBlockchainAddress    *string       `json:"sender_blockchain_address"`
+		RecipientBlockchainAddress *string       `json:"recipient_blockchain_address"`
+		Value                      *utils.Amount `json:"value"`
+		Fee                        *utils.Amount `json:"fee"`
+		Nonce                      *uint64       `json:"nonce"`
+		Timestamp                  *int64        `json:"timestamp"`
+		UnlockHeight               *uint64       `json:"unlock_height"`
+		UnlockTime                 *int64        `json:"unlock_time"`
+		Witness                    **Witness     `json:"witness"`
 	}{
+		ChainID:                    &t.chainID,
 		SenderBlockchainAddress:    &t.senderBlockchainAddress,
 		RecipientBlockchainAddress: &t.recipientBlockchainAddress,
 		Value:                      &t.value,
+		Fee:                        &t.fee,
+		Nonce:                      &t.nonce,
 		Timestamp:                  &t.timestamp,
+		UnlockHeight:               &t.unlockHeight,
+		UnlockTime:                 &t.unlockTime,
+		Witness:                    &t.witness,
 	}
 	return json.Unmarshal(data, &v)
 }
@@ -81,5 +281,7 @@ func (t *Transaction) Print() {
 	fmt.Printf("%s\n", strings.Repeat("-", 60))
 	fmt.Printf("%-30s %s\n", "sender_blockchain_address:", t.senderBlockchainAddress)
 	fmt.Printf("%-30s %s\n", "recipient_blockchain_address:", t.recipientBlockchainAddress)
-	fmt.Printf("%-30s %.1f\n", "value:", t.value)
+	fmt.Printf("%-30s %s\n", "value:", t.value)
+	fmt.Printf("%-30s %s\n", "fee:", t.fee)
+	fmt.Printf("%-30s %d\n", "nonce:", t.nonce)
 }
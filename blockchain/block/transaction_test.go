@@ -0,0 +1,60 @@
+package block
+
+import (
+	"testing"
+
+	"github.com/EmilioCliff/learn-go/blockchain/utils"
+	"github.com/EmilioCliff/learn-go/blockchain/wallet"
+)
+
+func TestNonceReplay(t *testing.T) {
+	bc := newTestBlockchain(t)
+	alice, bob := wallet.NewWallet(), wallet.NewWallet()
+	if !receive(bc, nextBlock(t, bc, alice.BlockchainAddress())) {
+		t.Fatal("block funding alice was rejected")
+	}
+
+	first := transfer(bc, alice, bob.BlockchainAddress(), utils.Coin/10, 0)
+	if !bc.AddTransaction(first, first.witness) {
+		t.Fatal("transaction with the next nonce was rejected")
+	}
+	if bc.AddTransaction(first, first.witness) {
+		t.Fatal("pending transaction was admitted twice")
+	}
+	if other := transfer(bc, alice, bob.BlockchainAddress(), utils.Coin/5, 0); bc.AddTransaction(other, other.witness) {
+		t.Fatal("second transaction with a pending nonce was admitted")
+	}
+	if gap := transfer(bc, alice, bob.BlockchainAddress(), utils.Coin/10, 2); bc.AddTransaction(gap, gap.witness) {
+		t.Fatal("transaction skipping a nonce was admitted")
+	}
+
+	if !receive(bc, nextBlock(t, bc, bob.BlockchainAddress(), first)) {
+		t.Fatal("block with the transaction was rejected")
+	}
+	if bc.AddTransaction(first, first.witness) {
+		t.Fatal("confirmed transaction was admitted again")
+	}
+	if nonce := bc.NextNonce(alice.BlockchainAddress()); nonce != 1 {
+		t.Fatalf("next nonce = %d, want 1", nonce)
+	}
+
+	// Blocks are held to the same rules as the pool.
+	for name, transactions := range map[string][]*Transaction{
+		"replayed transaction": {first},
+		"replayed nonce":       {transfer(bc, alice, bob.BlockchainAddress(), utils.Coin/5, 0)},
+		"skipped nonce":        {transfer(bc, alice, bob.BlockchainAddress(), utils.Coin/10, 2)},
+		"repeated nonce": {
+			transfer(bc, alice, bob.BlockchainAddress(), utils.Coin/10, 1),
+			transfer(bc, alice, bob.BlockchainAddress(), utils.Coin/5, 1),
+		},
+	} {
+		if receive(bc, nextBlock(t, bc, bob.BlockchainAddress(), transactions...)) {
+			t.Fatalf("block with a %s was accepted", name)
+		}
+	}
+	if !receive(bc, nextBlock(t, bc, bob.BlockchainAddress(),
+		transfer(bc, alice, bob.BlockchainAddress(), utils.Coin/10, 1),
+		transfer(bc, alice, bob.BlockchainAddress(), utils.Coin/10, 2))) {
+		t.Fatal("block with consecutive nonces was rejected")
+	}
+}
@@ -0,0 +1,159 @@
+package block
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/EmilioCliff/learn-go/blockchain/address"
+	"github.com/EmilioCliff/learn-go/blockchain/utils"
+)
+
+// Witness proves that the sender authorized a transaction. A single key
+// address needs its public key and a signature, a multisig address needs
+// its policy and signatures, by public key, from at least M of its keys.
+// The pool keeps the witness with its transaction and blocks carry it, so
+// every node checks it again before accepting the block. Mining rewards
+// have none.
+type Witness struct {
+	PublicKey  *ecdsa.PublicKey
+	Signature  *utils.Signature
+	Policy     *address.Multisig
+	Signatures map[string]*utils.Signature
+}
+
+// NewWitness returns the witness of a single key transaction.
+func NewWitness(publicKey *ecdsa.PublicKey, s *utils.Signature) *Witness {
+	return &Witness{PublicKey: publicKey, Signature: s}
+}
+
+// verify checks that w authorizes t.
+func (w *Witness) verify(t *Transaction) error {
+	if w == nil {
+		return errors.New("missing witness")
+	}
+	if w.Policy == nil {
+		if w.Signature == nil || w.Signature.R == nil || w.Signature.S == nil {
+			return errors.New("missing signature")
+		}
+		if err := address.VerifyPublicKey(t.senderBlockchainAddress, w.PublicKey); err != nil {
+			return err
+		}
+		if !t.VerifySignature(w.PublicKey, w.Signature) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+
+	if err := address.VerifyPolicy(t.senderBlockchainAddress, w.Policy); err != nil {
+		return err
+	}
+	// Keys are compared in the lowercase form the policy keeps, so the same
+	// key written twice in another case counts once.
+	signed := map[string]bool{}
+	for publicKey, s := range w.Signatures {
+		key := strings.ToLower(publicKey)
+		if key != publicKey {
+			return fmt.Errorf("public key %s is not in lowercase", publicKey)
+		}
+		if !w.Policy.Has(key) {
+			return fmt.Errorf("signature by a key outside the policy")
+		}
+		if !t.VerifySignature(utils.PublicKeyFromString(key), s) {
+			return fmt.Errorf("invalid signature by %s", key)
+		}
+		signed[key] = true
+	}
+	if len(signed) < w.Policy.M {
+		return fmt.Errorf("%d of the %d signatures the policy needs", len(signed), w.Policy.M)
+	}
+	return nil
+}
+
+// hash identifies w in the witness root of a block, a missing witness
+// hashes to zero.
+func (w *Witness) hash() [32]byte {
+	if w == nil {
+		return [32]byte{}
+	}
+	m, _ := json.Marshal(w)
+	return sha256.Sum256(m)
+}
+
+type witnessJSON struct {
+	PublicKey  string            `json:"public_key,omitempty"`
+	Signature  string            `json:"signature,omitempty"`
+	Policy     *address.Multisig `json:"policy,omitempty"`
+	Signatures map[string]string `json:"signatures,omitempty"`
+}
+
+func (w *Witness) MarshalJSON() ([]byte, error) {
+	if w.Policy == nil {
+		return json.Marshal(witnessJSON{
+			PublicKey: fmt.Sprintf("%064x%064x", w.PublicKey.X.Bytes(), w.PublicKey.Y.Bytes()),
+			Signature: w.Signature.String(),
+		})
+	}
+	v := witnessJSON{Policy: w.Policy, Signatures: make(map[string]string, len(w.Signatures))}
+	for publicKey, s := range w.Signatures {
+		v.Signatures[publicKey] = s.String()
+	}
+	return json.Marshal(v)
+}
+
+func (w *Witness) UnmarshalJSON(data []byte) error {
+	var v witnessJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Policy == nil {
+		if !utils.IsBigIntTupleString(v.PublicKey) || !utils.IsBigIntTupleString(v.Signature) {
+			return errors.New("malformed witness")
+		}
+		*w = Witness{PublicKey: utils.PublicKeyFromString(v.PublicKey), Signature: utils.SignatureFromString(v.Signature)}
+		return nil
+	}
+	*w = Witness{Policy: v.Policy, Signatures: make(map[string]*utils.Signature, len(v.Signatures))}
+	for publicKey, s := range v.Signatures {
+		if !utils.IsBigIntTupleString(publicKey) || !utils.IsBigIntTupleString(s) {
+			return errors.New("malformed witness")
+		}
+		w.Signatures[publicKey] = utils.SignatureFromString(s)
+	}
+	return nil
+}
+
+// request writes t and w the way peers relay transactions.
+func (w *Witness) request(t *Transaction) *TransactionRequest {
+	tr := &TransactionRequest{
+		ChainID:                    &t.chainID,
+		SenderBlockchainAddress:    &t.senderBlockchainAddress,
+		RecipientBlockchainAddress: &t.recipientBlockchainAddress,
+		Value:                      t.value,
+		Fee:                        t.fee,
+		Nonce:                      &t.nonce,
+		Timestamp:                  &t.timestamp,
+	}
+	if t.unlockHeight > 0 {
+		tr.UnlockHeight = &t.unlockHeight
+	}
+	if t.unlockTime > 0 {
+		tr.UnlockTime = &t.unlockTime
+	}
+	if w.Policy == nil {
+		publicKey := fmt.Sprintf("%064x%064x", w.PublicKey.X.Bytes(), w.PublicKey.Y.Bytes())
+		signature := w.Signature.String()
+		tr.SenderPublicKey = &publicKey
+		tr.Signature = &signature
+		return tr
+	}
+	tr.Policy = w.Policy
+	tr.Signatures = make(map[string]string, len(w.Signatures))
+	for publicKey, s := range w.Signatures {
+		tr.Signatures[publicKey] = s.String()
+	}
+	return tr
+}
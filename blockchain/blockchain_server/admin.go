@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// adminCommands are the requests of the admin command, the ones only the
+// admin token authorizes. The frontend is public and never holds the token,
+// operators send them from here.
+var adminCommands = map[string]struct{ method, path string }{
+	"mine":      {"GET", "/mine"},
+	"start":     {"GET", "/mine/start"},
+	"stop":      {"GET", "/mine/stop"},
+	"clear":     {"DELETE", "/transactions"},
+	"consensus": {"PUT", "/consensus"},
+}
+
+// runAdmin is the admin command: it sends one of adminCommands to a node
+// with the admin token and prints the response.
+func runAdmin(args []string) error {
+	fs := flag.NewFlagSet("admin", flag.ContinueOnError)
+	node := fs.String("node", "http://localhost:5000", "URL of the node")
+	token := fs.String("token", os.Getenv("ADMIN_TOKEN"), "admin token of the node, ADMIN_TOKEN by default")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: blockchain_server admin [flags] %s\n", strings.Join(adminCommandNames(), "|"))
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cmd, ok := adminCommands[fs.Arg(0)]
+	if fs.NArg() != 1 || !ok {
+		fs.Usage()
+		return fmt.Errorf("expected one admin command, got %q", fs.Args())
+	}
+	if *token == "" {
+		return fmt.Errorf("no admin token, set ADMIN_TOKEN or -token")
+	}
+
+	req, err := http.NewRequest(cmd.method, strings.TrimRight(*node, "/")+cmd.path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+*token)
+	// Mining one block may take a while.
+	client := &http.Client{Timeout: 10 * time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s: %s", cmd.method, cmd.path, resp.Status, body)
+	}
+	fmt.Printf("%s\n", body)
+	return nil
+}
+
+func adminCommandNames() []string {
+	names := make([]string, 0, len(adminCommands))
+	for name := range adminCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,628 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/EmilioCliff/learn-go/blockchain/block"
+	"github.com/EmilioCliff/learn-go/blockchain/utils"
+	"github.com/EmilioCliff/learn-go/blockchain/wallet"
+)
+
+// DevnetMnemonic is the BIP-39 test mnemonic. The pre-funded devnet wallets
+// are its first receive addresses, so they are the same on every run and
+// can be restored with POST /wallet/hd.
+const DevnetMnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+// devnetGenesisTime stamps the devnet genesis block, which only depends on
+// the options so every run builds the same one.
+var devnetGenesisTime = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// devnetMinerPassphrase is the MINER_PASSPHRASE of every devnet node.
+const devnetMinerPassphrase = "devnet"
+
+// devnetTimeout bounds how long the devnet waits for its nodes to start,
+// connect and agree on a tip.
+const devnetTimeout = 30 * time.Second
+
+// devnetDifficulty is the genesis difficulty of a devnet unless told
+// otherwise, so blocks take a moment to mine.
+const devnetDifficulty = 2
+
+// DevnetOptions describes a devnet. Difficulty is the one of its genesis
+// block, devnetDifficulty when 0. NodeArgs are config flags passed to every
+// node after the devnet's own, so they override them, see utils.LoadConfig.
+type DevnetOptions struct {
+	Nodes      int
+	Processes  bool
+	BasePort   uint16
+	Dir        string
+	Mnemonic   string
+	Wallets    int
+	Allocation utils.Amount
+	Difficulty int
+	AdminToken string
+	NodeArgs   []string
+}
+
+// Devnet is a network of nodes on localhost sharing one genesis block that
+// funds Wallets. The nodes run in this process or as child processes of
+// the blockchain_server binary, and each is put behind a proxy it is known
+// to its peers by. The proxies drop the requests of nodes on the other side
+// of a partition, like a broken link would.
+type Devnet struct {
+	Genesis *block.Genesis
+	Wallets []*wallet.Wallet
+	Nodes   []*DevnetNode
+
+	opts   DevnetOptions
+	client *http.Client
+
+	mux    sync.RWMutex
+	groups []int
+}
+
+// DevnetNode is one devnet node. URL is the address of its proxy, where
+// peers reach it, and Direct the one it listens on.
+type DevnetNode struct {
+	URL       string
+	Direct    string
+	PublicKey string
+
+	server *BlockchainServer
+	cmd    *exec.Cmd
+	proxy  *http.Server
+}
+
+// StartDevnet writes the genesis file and the node keys to opts.Dir, starts
+// the nodes and waits until each of them has all the others as peers.
+func StartDevnet(opts DevnetOptions) (*Devnet, error) {
+	if opts.Nodes < 1 {
+		return nil, errors.New("a devnet needs at least one node")
+	}
+	if opts.Mnemonic == "" {
+		opts.Mnemonic = DevnetMnemonic
+	}
+	if opts.Difficulty == 0 {
+		opts.Difficulty = devnetDifficulty
+	}
+	if opts.Dir == "" {
+		dir, err := os.MkdirTemp("", "devnet")
+		if err != nil {
+			return nil, err
+		}
+		opts.Dir = dir
+	}
+	if err := os.MkdirAll(opts.Dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	d := &Devnet{
+		opts:    opts,
+		client:  &http.Client{Timeout: devnetTimeout},
+		Genesis: &block.Genesis{Timestamp: devnetGenesisTime, Difficulty: opts.Difficulty, Allocations: map[string]utils.Amount{}},
+	}
+	hw, err := wallet.NewHDWallet(opts.Mnemonic, "")
+	if err != nil {
+		return nil, err
+	}
+	for i := range opts.Wallets {
+		w, err := hw.Wallet(uint32(i))
+		if err != nil {
+			return nil, err
+		}
+		d.Wallets = append(d.Wallets, w)
+		d.Genesis.Allocations[w.BlockchainAddress()] = opts.Allocation
+	}
+	genesisFile := filepath.Join(opts.Dir, "genesis.json")
+	if err := d.Genesis.Save(genesisFile); err != nil {
+		return nil, fmt.Errorf("failed to write genesis: %w", err)
+	}
+
+	// Every node needs the URLs and keys of all the others before any of
+	// them starts.
+	listeners := make([]net.Listener, opts.Nodes)
+	for i := range opts.Nodes {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			d.Close()
+			return nil, err
+		}
+		listeners[i] = ln
+		port := opts.BasePort + uint16(i)
+		if opts.BasePort == 0 {
+			if port, err = freePort(); err != nil {
+				d.Close()
+				return nil, err
+			}
+		}
+		key, err := block.LoadNodeKey(filepath.Join(d.nodeDir(i), "node.key"))
+		if err != nil {
+			d.Close()
+			return nil, err
+		}
+		d.Nodes = append(d.Nodes, &DevnetNode{
+			URL:       "http://" + ln.Addr().String(),
+			Direct:    fmt.Sprintf("http://127.0.0.1:%d", port),
+			PublicKey: key.PublicKey(),
+		})
+	}
+	d.groups = make([]int, opts.Nodes)
+
+	urls, keys := []string{}, []string{}
+	for _, n := range d.Nodes {
+		urls = append(urls, n.URL)
+		keys = append(keys, n.PublicKey)
+	}
+	for i, n := range d.Nodes {
+		direct, _ := url.Parse(n.Direct)
+		n.proxy = &http.Server{Handler: d.proxy(i, direct)}
+		go n.proxy.Serve(listeners[i])
+	}
+	for i, n := range d.Nodes {
+		direct, _ := url.Parse(n.Direct)
+		args := append([]string{
+			"-port", direct.Port(),
+			"-host", "http://127.0.0.1",
+			"-advertised-url", n.URL,
+			"-neighbors", strings.Join(urls, ","),
+			"-peer-keys", strings.Join(keys, ","),
+			"-node-key-file", filepath.Join(d.nodeDir(i), "node.key"),
+			"-data-dir", d.nodeDir(i),
+			"-keystore-dir", filepath.Join(d.nodeDir(i), "keystore"),
+			"-genesis-file", genesisFile,
+			"-admin-token", opts.AdminToken,
+			"-miner-passphrase", devnetMinerPassphrase,
+			"-peer-health-interval", "1s",
+			"-keystore-iterations", "1000",
+		}, opts.NodeArgs...)
+		if err := d.startNode(i, args); err != nil {
+			d.Close()
+			return nil, fmt.Errorf("node %d: %w", i, err)
+		}
+	}
+
+	if err := d.WaitConnected(); err != nil {
+		d.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *Devnet) nodeDir(i int) string {
+	return filepath.Join(d.opts.Dir, fmt.Sprintf("node%d", i))
+}
+
+// startNode runs node i with args and waits until it answers.
+func (d *Devnet) startNode(i int, args []string) error {
+	n := d.Nodes[i]
+	if d.opts.Processes {
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		logFile, err := os.Create(filepath.Join(d.opts.Dir, fmt.Sprintf("node%d.log", i)))
+		if err != nil {
+			return err
+		}
+		defer logFile.Close()
+		n.cmd = exec.Command(exe, args...)
+		n.cmd.Stdout, n.cmd.Stderr = logFile, logFile
+		if err := n.cmd.Start(); err != nil {
+			return err
+		}
+	} else {
+		config, err := utils.LoadConfig(args)
+		if err != nil {
+			return err
+		}
+		if n.server, err = NewBlockchainServer(config); err != nil {
+			return err
+		}
+		if err := n.server.Start(); err != nil {
+			n.server.GetBlockchain().Close()
+			n.server = nil
+			return err
+		}
+	}
+
+	return waitFor(func() error {
+		var node block.NodeInfo
+		return d.do(i, "GET", "/node", &node)
+	})
+}
+
+// proxy forwards to node i, except the requests signed by nodes the
+// partition cuts it off from, whose connection is dropped.
+func (d *Devnet) proxy(i int, target *url.URL) http.Handler {
+	rp := httputil.NewSingleHostReverseProxy(target)
+	// Event streams are passed on as they are written.
+	rp.FlushInterval = -1
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if from := d.nodeByKey(r.Header.Get(block.HeaderNodeKey)); from >= 0 && !d.connected(from, i) {
+			panic(http.ErrAbortHandler)
+		}
+		rp.ServeHTTP(w, r)
+	})
+}
+
+func (d *Devnet) nodeByKey(publicKey string) int {
+	for i, n := range d.Nodes {
+		if publicKey != "" && n.PublicKey == publicKey {
+			return i
+		}
+	}
+	return -1
+}
+
+func (d *Devnet) connected(a, b int) bool {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+	return d.groups[a] == d.groups[b]
+}
+
+// Partition splits the network into sides, the nodes of one side only
+// reach each other. Nodes left out of every side form one more.
+func (d *Devnet) Partition(sides ...[]int) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	for i := range d.groups {
+		d.groups[i] = 0
+	}
+	for s, side := range sides {
+		for _, i := range side {
+			d.groups[i] = s + 1
+		}
+	}
+	log.Printf("INFO: Devnet partitioned into %v\n", sides)
+}
+
+// Heal reconnects every node, waits until they are all peers again and has
+// each of them resolve conflicts with the others.
+func (d *Devnet) Heal() error {
+	d.Partition()
+	if err := d.WaitConnected(); err != nil {
+		return err
+	}
+	for i := range d.Nodes {
+		if err := d.Consensus(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WaitConnected waits until every node has all the others as peers, which
+// takes a health check for nodes evicted during a partition.
+func (d *Devnet) WaitConnected() error {
+	return waitFor(func() error {
+		for i, n := range d.Nodes {
+			var peers []block.PeerInfo
+			if err := d.do(i, "GET", "/peers", &peers); err != nil {
+				return err
+			}
+			if len(peers) != len(d.Nodes)-1 {
+				return fmt.Errorf("node %s has %d of %d peers", n.URL, len(peers), len(d.Nodes)-1)
+			}
+		}
+		return nil
+	})
+}
+
+// WaitConverged waits until the nodes, every node when none is given, have
+// the same tip and returns their chain stats.
+func (d *Devnet) WaitConverged(nodes ...int) (block.ChainStats, error) {
+	if len(nodes) == 0 {
+		for i := range d.Nodes {
+			nodes = append(nodes, i)
+		}
+	}
+	var stats block.ChainStats
+	err := waitFor(func() error {
+		for k, i := range nodes {
+			s, err := d.Stats(i)
+			if err != nil {
+				return err
+			}
+			if k > 0 && s.LastBlockHash != stats.LastBlockHash {
+				return fmt.Errorf("nodes %v are on different tips", nodes)
+			}
+			stats = s
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// Mine has node i mine one block.
+func (d *Devnet) Mine(i int) error {
+	return d.do(i, "GET", "/mine", nil)
+}
+
+func (d *Devnet) Consensus(i int) error {
+	return d.do(i, "PUT", "/consensus", nil)
+}
+
+func (d *Devnet) Stats(i int) (block.ChainStats, error) {
+	var stats block.ChainStats
+	err := d.do(i, "GET", "/chain/stats", &stats)
+	return stats, err
+}
+
+// Client returns a wallet client talking to node i.
+func (d *Devnet) Client(i int) *wallet.Client {
+	return wallet.NewClient(d.Nodes[i].Direct)
+}
+
+// do sends a request to node i directly, with the admin token, and decodes
+// the response into out.
+func (d *Devnet) do(i int, method, path string, out any) error {
+	req, err := http.NewRequest(method, d.Nodes[i].Direct+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+d.opts.AdminToken)
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		return fmt.Errorf("%s %s on node %d: status %d %s", method, path, i, resp.StatusCode, e.Error)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Close stops the nodes and their proxies, the devnet directory is kept.
+func (d *Devnet) Close() {
+	for _, n := range d.Nodes {
+		if n.proxy != nil {
+			n.proxy.Close()
+		}
+		if n.server != nil {
+			n.server.GetBlockchain().StopMining()
+			if err := n.server.Stop(); err != nil {
+				log.Printf("ERROR: Stop node %s: %s\n", n.Direct, err.Error())
+			}
+		}
+		if n.cmd != nil {
+			n.cmd.Process.Signal(os.Interrupt)
+			done := make(chan struct{})
+			go func() {
+				n.cmd.Wait()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(10 * time.Second):
+				n.cmd.Process.Kill()
+				<-done
+			}
+		}
+	}
+}
+
+// waitFor retries check until it succeeds or devnetTimeout passes.
+func waitFor(check func() error) error {
+	deadline := time.Now().Add(devnetTimeout)
+	for {
+		err := check()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func freePort() (uint16, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer ln.Close()
+	return uint16(ln.Addr().(*net.TCPAddr).Port), nil
+}
+
+// devnetScenarios are the scripted runs of devnet -scenario, each checking
+// how the network behaves and returning what went wrong.
+var devnetScenarios = map[string]func(d *Devnet) error{
+	"partition":        scenarioPartition,
+	"rejoin":           scenarioRejoin,
+	"competing-miners": scenarioCompetingMiners,
+}
+
+// scenarioPartition splits the network in two halves that each mine their
+// own fork, one block on the first half and two on the second.
+func scenarioPartition(d *Devnet) error {
+	if len(d.Nodes) < 2 {
+		return errors.New("a partition needs at least two nodes")
+	}
+	start, err := d.WaitConverged()
+	if err != nil {
+		return err
+	}
+	a, b := []int{}, []int{}
+	for i := range d.Nodes {
+		if i < len(d.Nodes)/2 {
+			a = append(a, i)
+		} else {
+			b = append(b, i)
+		}
+	}
+	d.Partition(a, b)
+
+	if err := d.Mine(a[0]); err != nil {
+		return err
+	}
+	for range 2 {
+		if err := d.Mine(b[0]); err != nil {
+			return err
+		}
+	}
+	sideA, err := d.WaitConverged(a...)
+	if err != nil {
+		return err
+	}
+	sideB, err := d.WaitConverged(b...)
+	if err != nil {
+		return err
+	}
+	if sideA.Height != start.Height+1 || sideB.Height != start.Height+2 || sideA.LastBlockHash == sideB.LastBlockHash {
+		return fmt.Errorf("sides did not fork: %v at %d, %v at %d", a, sideA.Height, b, sideB.Height)
+	}
+	log.Printf("INFO: Partition: %v at height %d, %v at height %d\n", a, sideA.Height, b, sideB.Height)
+	return nil
+}
+
+// scenarioRejoin heals a partition and checks that every node switches to
+// the heavier fork.
+func scenarioRejoin(d *Devnet) error {
+	if err := scenarioPartition(d); err != nil {
+		return err
+	}
+	heavier, err := d.Stats(len(d.Nodes) - 1)
+	if err != nil {
+		return err
+	}
+	if err := d.Heal(); err != nil {
+		return err
+	}
+	stats, err := d.WaitConverged()
+	if err != nil {
+		return err
+	}
+	if stats.LastBlockHash != heavier.LastBlockHash {
+		return fmt.Errorf("network settled on %s at %d, not on the heavier fork %s", stats.LastBlockHash, stats.Height, heavier.LastBlockHash)
+	}
+	log.Printf("INFO: Rejoin: every node at height %d\n", stats.Height)
+	return nil
+}
+
+// scenarioCompetingMiners has every node mine the next block at once. The
+// first block found interrupts the other searches, blocks found at the same
+// time leave forks of equal work, which the next block settles.
+func scenarioCompetingMiners(d *Devnet) error {
+	start, err := d.WaitConverged()
+	if err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	mined := make([]error, len(d.Nodes))
+	for i := range d.Nodes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			mined[i] = d.Mine(i)
+		}(i)
+	}
+	wg.Wait()
+	if !slices.Contains(mined, nil) {
+		return fmt.Errorf("no node mined a block: %w", errors.Join(mined...))
+	}
+
+	if err := d.Mine(0); err != nil {
+		return err
+	}
+	stats, err := d.WaitConverged()
+	if err != nil {
+		return err
+	}
+	if stats.Height != start.Height+2 {
+		return fmt.Errorf("network settled at height %d, expected %d", stats.Height, start.Height+2)
+	}
+	log.Printf("INFO: Competing miners: every node at height %d\n", stats.Height)
+	return nil
+}
+
+// runDevnet is the devnet command: it starts a devnet and runs a scenario
+// on it, or keeps it running until interrupted. Arguments after -- are
+// passed to every node.
+func runDevnet(args []string) error {
+	fs := flag.NewFlagSet("devnet", flag.ContinueOnError)
+	nodes := fs.Int("nodes", 3, "number of nodes")
+	processes := fs.Bool("processes", false, "run every node in a child process")
+	basePort := fs.Uint("base-port", 0, "port of the first node, the others follow; any free ports when 0")
+	dir := fs.String("dir", "", "directory for the genesis file and the node data, a new temporary one when empty")
+	mnemonic := fs.String("mnemonic", DevnetMnemonic, "mnemonic the pre-funded wallets are derived from")
+	wallets := fs.Int("wallets", 4, "number of pre-funded wallets")
+	allocation := fs.String("allocation", "1000", "amount every pre-funded wallet starts with")
+	difficulty := fs.Int("difficulty", devnetDifficulty, "difficulty of the genesis block")
+	adminToken := fs.String("admin-token", "devnet", "ADMIN_TOKEN of every node")
+	scenario := fs.String("scenario", "", "scenario to run: partition, rejoin or competing-miners")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	amount, err := utils.ParseAmount(*allocation)
+	if err != nil || amount <= 0 {
+		return fmt.Errorf("invalid allocation %q", *allocation)
+	}
+	run, ok := devnetScenarios[*scenario]
+	if *scenario != "" && !ok {
+		return fmt.Errorf("unknown scenario %q", *scenario)
+	}
+
+	d, err := StartDevnet(DevnetOptions{
+		Nodes:      *nodes,
+		Processes:  *processes,
+		BasePort:   uint16(*basePort),
+		Dir:        *dir,
+		Mnemonic:   *mnemonic,
+		Wallets:    *wallets,
+		Allocation: amount,
+		Difficulty: *difficulty,
+		AdminToken: *adminToken,
+		NodeArgs:   fs.Args(),
+	})
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	log.Printf("INFO: Devnet in %s\n", d.opts.Dir)
+	for i, n := range d.Nodes {
+		log.Printf("INFO: Node %d at %s (peers reach it at %s)\n", i, n.Direct, n.URL)
+	}
+	for _, w := range d.Wallets {
+		log.Printf("INFO: Wallet %s funded with %s\n", w.BlockchainAddress(), amount)
+	}
+	log.Printf("INFO: Wallets are derived from %q\n", *mnemonic)
+
+	if run != nil {
+		if err := run(d); err != nil {
+			return fmt.Errorf("scenario %s: %w", *scenario, err)
+		}
+		log.Printf("INFO: Scenario %s passed\n", *scenario)
+		return nil
+	}
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	return nil
+}
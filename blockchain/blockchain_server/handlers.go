@@ -1,30 +1,235 @@
 package main
 
 import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/EmilioCliff/learn-go/blockchain/address"
 	"github.com/EmilioCliff/learn-go/blockchain/block"
 	"github.com/EmilioCliff/learn-go/blockchain/utils"
 	"github.com/EmilioCliff/learn-go/blockchain/wallet"
 	"github.com/gin-gonic/gin"
 )
 
+func (bcs *BlockchainServer) listWallets(c *gin.Context) {
+	c.JSON(200, bcs.keystore.List())
+}
+
+type walletRequest struct {
+	Passphrase *string `json:"passphrase"`
+	Key        *string `json:"key"`
+	Format     *string `json:"format"`
+}
+
+// createWallet handles POST /wallet. The new key is only stored encrypted
+// under the passphrase, the response carries the public key and address.
 func (bcs *BlockchainServer) createWallet(c *gin.Context) {
-	myWallet := wallet.NewWallet()
+	var wr walletRequest
+	if err := c.ShouldBindJSON(&wr); err != nil {
+		c.JSON(400, gin.H{"message": "failed", "error": err.Error()})
+		return
+	}
+	if wr.Passphrase == nil || *wr.Passphrase == "" {
+		c.JSON(400, gin.H{"message": "failed", "error": "missing or invalid fields"})
+		return
+	}
+
+	myWallet, err := bcs.keystore.Create(*wr.Passphrase)
+	if err != nil {
+		c.JSON(500, gin.H{"message": "failed", "error": err.Error()})
+		return
+	}
+	c.JSON(200, myWallet.Info())
+}
+
+// importWallet handles POST /wallet/import with a private key in the hex,
+// pem or wif format. The key travels in the request, only the admin may
+// send it.
+func (bcs *BlockchainServer) importWallet(c *gin.Context) {
+	var wr walletRequest
+	if err := c.ShouldBindJSON(&wr); err != nil {
+		c.JSON(400, gin.H{"message": "failed", "error": err.Error()})
+		return
+	}
+	if wr.Passphrase == nil || *wr.Passphrase == "" || wr.Key == nil || wr.Format == nil {
+		c.JSON(400, gin.H{"message": "failed", "error": "missing or invalid fields"})
+		return
+	}
+
+	myWallet, err := bcs.keystore.Import(*wr.Key, *wr.Format, *wr.Passphrase)
+	if err != nil {
+		c.JSON(400, gin.H{"message": "failed", "error": err.Error()})
+		return
+	}
+	c.JSON(200, myWallet.Info())
+}
+
+type hdWalletRequest struct {
+	Passphrase *string `json:"passphrase"`
+	Mnemonic   *string `json:"mnemonic"`
+	Path       *string `json:"path"`
+	GapLimit   *int    `json:"gap_limit"`
+}
+
+func (bcs *BlockchainServer) listHDWallets(c *gin.Context) {
+	c.JSON(200, bcs.keystore.ListHD())
+}
+
+// createHDWallet handles POST /wallet/hd. Without a mnemonic a new one is
+// generated and returned this once for the user to back up. With one, the
+// wallet is restored and rescanned for the addresses it used before.
+func (bcs *BlockchainServer) createHDWallet(c *gin.Context) {
+	var hr hdWalletRequest
+	if err := c.ShouldBindJSON(&hr); err != nil {
+		c.JSON(400, gin.H{"message": "failed", "error": err.Error()})
+		return
+	}
+	if hr.Passphrase == nil || *hr.Passphrase == "" {
+		c.JSON(400, gin.H{"message": "failed", "error": "missing or invalid fields"})
+		return
+	}
+	path := ""
+	if hr.Path != nil {
+		path = *hr.Path
+	}
+
+	if hr.Mnemonic == nil {
+		info, mnemonic, err := bcs.keystore.CreateHD(*hr.Passphrase, path)
+		if err != nil {
+			c.JSON(400, gin.H{"message": "failed", "error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"message": "success", "wallet": info, "mnemonic": mnemonic})
+		return
+	}
+
+	info, err := bcs.keystore.RestoreHD(*hr.Mnemonic, *hr.Passphrase, path)
+	if err != nil {
+		c.JSON(400, gin.H{"message": "failed", "error": err.Error()})
+		return
+	}
 	bc := bcs.GetBlockchain()
-	bc.RegisterWallet(myWallet)
-	m, _ := myWallet.MarshalJSON()
-	c.Data(200, "application/json", m)
+	info, err = bcs.keystore.Rescan(info.ID, *hr.Passphrase, 0, bc.AddressUsed)
+	if err != nil {
+		c.JSON(500, gin.H{"message": "failed", "error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "success", "wallet": info})
 }
 
-func (bcs *BlockchainServer) getWallet(c *gin.Context) {
-	blockchainAddress := c.Param("blockchain_address")
+func (bcs *BlockchainServer) getHDWallet(c *gin.Context) {
+	hf, ok := bcs.keystore.HDWallet(c.Param("id"))
+	if !ok {
+		c.JSON(400, gin.H{"message": "failed", "error": "wallet not found"})
+		return
+	}
+	c.JSON(200, hf.Info())
+}
+
+// deriveHDWallet handles POST /wallet/hd/:id/derive, which returns the next
+// receive address of the wallet.
+func (bcs *BlockchainServer) deriveHDWallet(c *gin.Context) {
+	var hr hdWalletRequest
+	if err := c.ShouldBindJSON(&hr); err != nil {
+		c.JSON(400, gin.H{"message": "failed", "error": err.Error()})
+		return
+	}
+	if hr.Passphrase == nil {
+		c.JSON(400, gin.H{"message": "failed", "error": "missing or invalid fields"})
+		return
+	}
+
+	myWallet, err := bcs.keystore.DeriveNext(c.Param("id"), *hr.Passphrase)
+	if err != nil {
+		c.JSON(400, gin.H{"message": "failed", "error": err.Error()})
+		return
+	}
+	c.JSON(200, myWallet.Info())
+}
+
+// rescanHDWallet handles POST /wallet/hd/:id/rescan: it looks for used
+// addresses past the derived ones and returns the balance of every address
+// and their total.
+func (bcs *BlockchainServer) rescanHDWallet(c *gin.Context) {
+	var hr hdWalletRequest
+	if err := c.ShouldBindJSON(&hr); err != nil {
+		c.JSON(400, gin.H{"message": "failed", "error": err.Error()})
+		return
+	}
+	if hr.Passphrase == nil {
+		c.JSON(400, gin.H{"message": "failed", "error": "missing or invalid fields"})
+		return
+	}
+	gapLimit := 0
+	if hr.GapLimit != nil {
+		gapLimit = *hr.GapLimit
+	}
+
 	bc := bcs.GetBlockchain()
-	myWallet := bc.GetWallet(blockchainAddress)
-	if myWallet == nil {
+	info, err := bcs.keystore.Rescan(c.Param("id"), *hr.Passphrase, gapLimit, bc.AddressUsed)
+	if err != nil {
+		c.JSON(400, gin.H{"message": "failed", "error": err.Error()})
+		return
+	}
+	balances := make([]*block.AmountResponse, 0, len(info.Addresses))
+	var total utils.Amount
+	for _, blockchainAddress := range info.Addresses {
+		amount := bc.CalculateTotalAmount(blockchainAddress)
+		balances = append(balances, &block.AmountResponse{BlockchainAddress: blockchainAddress, Amount: amount, Spendable: bc.SpendableAmount(blockchainAddress)})
+		if total, err = total.Add(amount); err != nil {
+			c.JSON(500, gin.H{"message": "failed", "error": err.Error()})
+			return
+		}
+	}
+	c.JSON(200, gin.H{"message": "success", "wallet": info, "balances": balances, "amount": total})
+}
+
+func (bcs *BlockchainServer) getWallet(c *gin.Context) {
+	kf, ok := bcs.keystore.KeyFile(c.Param("blockchain_address"))
+	if !ok {
 		c.JSON(400, gin.H{"message": "failed", "error": "wallet not found"})
 		return
 	}
-	m, _ := myWallet.MarshalJSON()
-	c.Data(200, "application/json", m)
+	c.JSON(200, &wallet.WalletInfo{PublicKey: kf.PublicKey, BlockchainAddress: kf.BlockchainAddress})
+}
+
+// getKeyFile returns the encrypted key file, clients decrypt it locally
+// with the passphrase to sign transactions.
+// keystoreProofHeader carries the wallet.DownloadProof of a key file's
+// passphrase.
+const keystoreProofHeader = "X-Keystore-Proof"
+
+// getKeyFile handles GET /wallet/:blockchain_address/keystore. The key
+// file only goes to a client that sends the download proof of its
+// passphrase, worked out from GET /wallet/:blockchain_address/keystore/kdf.
+func (bcs *BlockchainServer) getKeyFile(c *gin.Context) {
+	kf, err := bcs.keystore.Download(c.Param("blockchain_address"), c.GetHeader(keystoreProofHeader))
+	switch {
+	case errors.Is(err, wallet.ErrWalletNotFound):
+		c.JSON(400, gin.H{"message": "failed", "error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(403, gin.H{"message": "failed", "error": err.Error()})
+		return
+	}
+	c.JSON(200, kf)
+}
+
+// getKeyFileKDF returns how the download proof of a key file is derived
+// from its passphrase.
+func (bcs *BlockchainServer) getKeyFileKDF(c *gin.Context) {
+	kf, ok := bcs.keystore.KeyFile(c.Param("blockchain_address"))
+	if !ok {
+		c.JSON(400, gin.H{"message": "failed", "error": "wallet not found"})
+		return
+	}
+	c.JSON(200, gin.H{"kdf": kf.Crypto.KDF, "iterations": kf.Crypto.Iterations, "salt": kf.Crypto.Salt})
 }
 
 func (bcs *BlockchainServer) getChain(c *gin.Context) {
@@ -37,34 +242,46 @@ func (bcs *BlockchainServer) getWalletAmount(c *gin.Context) {
 	blockchainAddress := c.Param("blockchain_address")
 	bc := bcs.GetBlockchain()
 	amount := bc.CalculateTotalAmount(blockchainAddress)
-	ar := &block.AmountResponse{BlockchainAddress: blockchainAddress, Amount: amount}
+	ar := &block.AmountResponse{BlockchainAddress: blockchainAddress, Amount: amount, Spendable: bc.SpendableAmount(blockchainAddress)}
 	m, _ := ar.MarshalJSON()
 	c.Data(200, "application/json", m)
 }
 
-func (bcs *BlockchainServer) listTransactionPool(c *gin.Context) {
+func (bcs *BlockchainServer) getAddressNonce(c *gin.Context) {
+	blockchainAddress := c.Param("blockchain_address")
 	bc := bcs.GetBlockchain()
-	c.JSON(200, bc.TransactionsPool())
+	c.JSON(200, &block.NonceResponse{BlockchainAddress: blockchainAddress, ChainID: bc.ChainID(), Nonce: bc.NextNonce(blockchainAddress)})
 }
 
-type transactionRequest struct {
-	SenderPrivateKey           string  `json:"sender_private_key"`
-	SenderPublicKey            string  `json:"sender_public_key"`
-	SenderBlockchainAddress    string  `json:"sender_blockchain_address"`
-	RecipientBlockchainAddress string  `json:"recipient_blockchain_address"`
-	Value                      float32 `json:"value"`
+func (bcs *BlockchainServer) getTransaction(c *gin.Context) {
+	bc := bcs.GetBlockchain()
+	status := bc.LookupTransaction(c.Param("id"))
+	if status == nil {
+		c.JSON(404, gin.H{"message": "failed", "error": "transaction not found"})
+		return
+	}
+	c.JSON(200, status)
 }
 
-func (tr *transactionRequest) Validate() bool {
-	if tr.SenderPrivateKey == "" || tr.SenderPublicKey == "" || tr.SenderBlockchainAddress == "" || tr.RecipientBlockchainAddress == "" || tr.Value <= 0 {
-		return false
+func (bcs *BlockchainServer) getTransactionProof(c *gin.Context) {
+	bc := bcs.GetBlockchain()
+	proof := bc.TransactionProof(c.Param("id"))
+	if proof == nil {
+		c.JSON(404, gin.H{"message": "failed", "error": "transaction not confirmed"})
+		return
 	}
-	return true
+	c.JSON(200, proof)
+}
+
+func (bcs *BlockchainServer) listTransactionPool(c *gin.Context) {
+	bc := bcs.GetBlockchain()
+	c.JSON(200, bc.TransactionsPool())
 }
 
-// CreateTransactionHandler handles POST /transactions from clients
+// CreateTransactionHandler handles POST /transactions from clients. Only
+// transactions signed by the client are accepted, see wallet.Client.
 func (bcs *BlockchainServer) createTransaction(c *gin.Context) {
-	var tr transactionRequest
+	var tr block.TransactionRequest
 	if err := c.ShouldBindJSON(&tr); err != nil {
 		c.JSON(400, gin.H{"message": "failed", "error": err.Error()})
 		return
@@ -75,42 +292,14 @@ func (bcs *BlockchainServer) createTransaction(c *gin.Context) {
 		return
 	}
 
-	publicKey := utils.PublicKeyFromString(tr.SenderPublicKey)
-	privateKey := utils.PrivateKeyFromString(tr.SenderPrivateKey, publicKey)
-
-	transaction := wallet.NewTransaction(
-		privateKey,
-		publicKey,
-		tr.SenderBlockchainAddress,
-		tr.RecipientBlockchainAddress,
-		tr.Value,
-	)
-
-	signature := transaction.GenerateSignature()
-	signatureStr := signature.String()
-
-	bt := &block.TransactionRequest{
-		SenderBlockchainAddress:    &tr.SenderBlockchainAddress,
-		RecipientBlockchainAddress: &tr.RecipientBlockchainAddress,
-		SenderPublicKey:            &tr.SenderPublicKey,
-		Value:                      tr.Value,
-		Signature:                  &signatureStr,
-	}
-
 	bc := bcs.GetBlockchain()
-	isCreated := bc.CreateTransaction(
-		*bt.SenderBlockchainAddress,
-		*bt.RecipientBlockchainAddress,
-		bt.Value,
-		publicKey,
-		signature,
-	)
-
+	t := tr.Transaction()
+	isCreated := bc.CreateTransaction(t, tr.Witness())
 	if !isCreated {
 		c.JSON(400, gin.H{"message": "failed", "error": "failed to create a transaction"})
 		return
 	}
-	c.JSON(200, gin.H{"message": "success"})
+	c.JSON(200, gin.H{"message": "success", "id": t.ID()})
 }
 
 // AddTransactionHandler handles PUT /transactions from other nodes
@@ -126,16 +315,15 @@ func (bcs *BlockchainServer) addTransaction(c *gin.Context) {
 		return
 	}
 
-	publicKey := utils.PublicKeyFromString(*tr.SenderPublicKey)
-	signature := utils.SignatureFromString(*tr.Signature)
 	bc := bcs.GetBlockchain()
-	isCreated := bc.AddTransaction(
-		*tr.SenderBlockchainAddress,
-		*tr.RecipientBlockchainAddress,
-		tr.Value,
-		publicKey,
-		signature,
-	)
+	t := tr.Transaction()
+	// Gossip reaches us through several peers, only the first copy counts.
+	if bc.Peers().Seen(t.ID()) {
+		c.JSON(200, gin.H{"message": "success"})
+		return
+	}
+
+	isCreated := bc.CreateTransaction(t, tr.Witness())
 	if !isCreated {
 		c.JSON(400, gin.H{"message": "failed", "error": "failed to add a transaction"})
 		return
@@ -143,6 +331,28 @@ func (bcs *BlockchainServer) addTransaction(c *gin.Context) {
 	c.JSON(200, gin.H{"message": "success"})
 }
 
+// createMultisig handles POST /multisig with an M-of-N policy and returns
+// its address. Nothing is stored, the policy is all it takes to spend from
+// the address again.
+func (bcs *BlockchainServer) createMultisig(c *gin.Context) {
+	var ms address.Multisig
+	if err := c.ShouldBindJSON(&ms); err != nil {
+		c.JSON(400, gin.H{"message": "failed", "error": err.Error()})
+		return
+	}
+	policy, err := address.NewMultisig(ms.M, ms.PublicKeys...)
+	if err != nil {
+		c.JSON(400, gin.H{"message": "failed", "error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "success", "blockchain_address": policy.Address(), "policy": policy})
+}
+
+func (bcs *BlockchainServer) mempoolStats(c *gin.Context) {
+	bc := bcs.GetBlockchain()
+	c.JSON(200, bc.MempoolStats())
+}
+
 func (bcs *BlockchainServer) clearTransaction(c *gin.Context) {
 	bc := bcs.GetBlockchain()
 	bc.ClearTransactionPool()
@@ -153,12 +363,17 @@ func (bcs *BlockchainServer) mine(c *gin.Context) {
 	bc := bcs.GetBlockchain()
 	isMined := bc.Mining()
 	if !isMined {
-		c.JSON(400, gin.H{"message": "failed", "error": "mining is already in progress"})
+		c.JSON(400, gin.H{"message": "failed", "error": "mining is already in progress or was interrupted"})
 		return
 	}
 	c.JSON(200, gin.H{"message": "success"})
 }
 
+func (bcs *BlockchainServer) miningStats(c *gin.Context) {
+	bc := bcs.GetBlockchain()
+	c.JSON(200, bc.MinerStats())
+}
+
 func (bcs *BlockchainServer) startMining(c *gin.Context) {
 	bc := bcs.GetBlockchain()
 	bc.StartMining()
@@ -176,6 +391,216 @@ func (bcs *BlockchainServer) consensusResolve(c *gin.Context) {
 	isReplaced := bc.ResolveConflicts()
 	if isReplaced {
 		c.JSON(200, gin.H{"message": "success", "replaced": true})
+		return
 	}
 	c.JSON(200, gin.H{"message": "success", "replaced": false})
 }
+
+// listBlocks handles GET /blocks?offset=height&limit=n, the blocks from
+// height up. Peers syncing from us send the height as from. The chain length
+// is in the X-Total-Count header.
+func (bcs *BlockchainServer) listBlocks(c *gin.Context) {
+	from, err := strconv.Atoi(c.DefaultQuery("offset", c.DefaultQuery("from", "0")))
+	if err != nil || from < 0 {
+		c.JSON(400, gin.H{"message": "failed", "error": "invalid offset"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+	if err != nil {
+		c.JSON(400, gin.H{"message": "failed", "error": "invalid limit"})
+		return
+	}
+	bc := bcs.GetBlockchain()
+	c.Header("X-Total-Count", strconv.Itoa(bc.BlockCount()))
+	c.JSON(200, bc.Blocks(from, limit))
+}
+
+func (bcs *BlockchainServer) getBlock(c *gin.Context) {
+	height, err := strconv.Atoi(c.Param("height"))
+	if err != nil {
+		c.JSON(400, gin.H{"message": "failed", "error": "invalid height"})
+		return
+	}
+	bc := bcs.GetBlockchain()
+	br := bc.BlockByHeight(height)
+	if br == nil {
+		c.JSON(404, gin.H{"message": "failed", "error": "block not found"})
+		return
+	}
+	c.JSON(200, br)
+}
+
+func (bcs *BlockchainServer) getBlockByHash(c *gin.Context) {
+	var hash [32]byte
+	h, err := hex.DecodeString(c.Param("hash"))
+	if err != nil || len(h) != len(hash) {
+		c.JSON(400, gin.H{"message": "failed", "error": "invalid block hash"})
+		return
+	}
+	copy(hash[:], h)
+	bc := bcs.GetBlockchain()
+	br := bc.BlockByHash(hash)
+	if br == nil {
+		c.JSON(404, gin.H{"message": "failed", "error": "block not found"})
+		return
+	}
+	c.JSON(200, br)
+}
+
+// getAddressTransactions handles
+// GET /address/:blockchain_address/transactions?offset&limit.
+func (bcs *BlockchainServer) getAddressTransactions(c *gin.Context) {
+	blockchainAddress := c.Param("blockchain_address")
+	if err := address.Validate(blockchainAddress); err != nil {
+		c.JSON(400, gin.H{"message": "failed", "error": err.Error()})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(400, gin.H{"message": "failed", "error": "invalid offset"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+	if err != nil {
+		c.JSON(400, gin.H{"message": "failed", "error": "invalid limit"})
+		return
+	}
+	bc := bcs.GetBlockchain()
+	c.JSON(200, bc.AddressTransactions(blockchainAddress, offset, limit))
+}
+
+func (bcs *BlockchainServer) chainStats(c *gin.Context) {
+	bc := bcs.GetBlockchain()
+	c.JSON(200, bc.Stats())
+}
+
+func (bcs *BlockchainServer) listReorgs(c *gin.Context) {
+	bc := bcs.GetBlockchain()
+	c.JSON(200, bc.Reorgs())
+}
+
+// announceBlock handles PUT /blocks, a peer announcing a new block.
+func (bcs *BlockchainServer) announceBlock(c *gin.Context) {
+	var ba block.BlockAnnouncement
+	if err := c.ShouldBindJSON(&ba); err != nil {
+		c.JSON(400, gin.H{"message": "failed", "error": err.Error()})
+		return
+	}
+
+	if !ba.Validate() {
+		c.JSON(400, gin.H{"message": "failed", "error": "missing or invalid fields"})
+		return
+	}
+
+	// Missing blocks are fetched from the URL the node registered at, not
+	// from anywhere the body points to.
+	bc := bcs.GetBlockchain()
+	origin, ok := bc.Peers().PeerURL(c.GetString(nodeKeyContext))
+	if !ok {
+		c.JSON(403, gin.H{"message": "failed", "error": "node is not one of our peers"})
+		return
+	}
+	accepted := bc.ReceiveBlock(ba.Block, *ba.Height, origin)
+	c.JSON(200, gin.H{"message": "success", "accepted": accepted})
+}
+
+func (bcs *BlockchainServer) listPeers(c *gin.Context) {
+	bc := bcs.GetBlockchain()
+	c.JSON(200, bc.Peers().PeerInfos())
+}
+
+// getNode returns the identity of this node, the public key is what other
+// nodes list in PEER_KEYS.
+func (bcs *BlockchainServer) getNode(c *gin.Context) {
+	bc := bcs.GetBlockchain()
+	c.JSON(200, bc.Peers().Node())
+}
+
+// addPeer handles the handshake of POST /peers: the caller is added to our
+// peers with the node key it signed the request with and gets our own key,
+// network and peer list back to discover the rest of the network. Nodes on
+// another network, or that do not answer with their key at the URL they
+// send, are turned away.
+func (bcs *BlockchainServer) addPeer(c *gin.Context) {
+	var pr block.PeerRequest
+	if err := c.ShouldBindJSON(&pr); err != nil {
+		c.JSON(400, gin.H{"message": "failed", "error": err.Error()})
+		return
+	}
+
+	if !pr.Validate() {
+		c.JSON(400, gin.H{"message": "failed", "error": "missing or invalid fields"})
+		return
+	}
+
+	bc := bcs.GetBlockchain()
+	publicKey := c.GetString(nodeKeyContext)
+	if !bc.Peers().Allowed(publicKey) {
+		c.JSON(401, gin.H{"message": "failed", "error": "node key not allowed"})
+		return
+	}
+	if network := bc.Peers().Network(); pr.Network() != network {
+		c.JSON(403, gin.H{"message": "failed", "error": fmt.Sprintf("node is on chain %q with genesis %s", network.ChainID, network.GenesisHash)})
+		return
+	}
+	if err := bc.Peers().VerifyPeer(*pr.URL, publicKey); err != nil {
+		c.JSON(403, gin.H{"message": "failed", "error": err.Error()})
+		return
+	}
+	bc.Peers().AddPeer(*pr.URL, publicKey)
+	c.JSON(200, &block.HandshakeResponse{PublicKey: bc.Peers().Key().PublicKey(), Network: bc.Peers().Network(), Peers: bc.Peers().PeerInfos()})
+}
+
+// eventKeepAlive is how often an idle event stream gets a comment line, so
+// proxies do not close it.
+const eventKeepAlive = 15 * time.Second
+
+// streamEvents handles GET /events, a Server-Sent Events stream of new
+// blocks, pending transactions, reorgs and mining state changes. The type
+// and address query parameters, repeated or comma separated, narrow it down
+// to some event types and to the blocks and transactions of some addresses.
+func (bcs *BlockchainServer) streamEvents(c *gin.Context) {
+	var filter block.EventFilter
+	for _, types := range c.QueryArray("type") {
+		filter.Types = append(filter.Types, strings.Split(types, ",")...)
+	}
+	for _, addresses := range c.QueryArray("address") {
+		for _, a := range strings.Split(addresses, ",") {
+			if err := address.Validate(a); err != nil {
+				c.JSON(400, gin.H{"message": "failed", "error": err.Error()})
+				return
+			}
+			filter.Addresses = append(filter.Addresses, a)
+		}
+	}
+
+	// The stream outlives the server's write timeout.
+	http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+	bc := bcs.GetBlockchain()
+	sub := bc.Subscribe(filter)
+	defer sub.Close()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Writer.WriteHeader(200)
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case e, ok := <-sub.Events():
+			if !ok {
+				return false
+			}
+			c.SSEvent(e.Type, e)
+			return true
+		case <-keepAlive.C:
+			io.WriteString(w, ": keep-alive\n\n")
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
@@ -1,31 +1,44 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"log"
 	"os"
 	"os/signal"
-	"strconv"
 	"syscall"
+
+	"github.com/EmilioCliff/learn-go/blockchain/utils"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "devnet" {
+		if err := runDevnet(os.Args[2:]); err != nil && !errors.Is(err, flag.ErrHelp) {
+			log.Fatalf("ERROR: %s", err.Error())
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		if err := runAdmin(os.Args[2:]); err != nil && !errors.Is(err, flag.ErrHelp) {
+			log.Fatalf("ERROR: %s", err.Error())
+		}
+		return
+	}
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 
-	port := flag.Uint("port", 5000, "TCP Port Number for Blockchain Server")
-	flag.Parse()
-
-	portStr := os.Getenv("PORT")
-	if os.Getenv("ENVIRONMENT") == "production" && portStr != "" {
-		p, err := strconv.ParseUint(portStr, 10, 16)
-		if err != nil {
-			panic(err)
-		}
-		portVal := uint(p)
-		port = &portVal
+	config, err := utils.LoadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("ERROR: %s", err.Error())
+	}
+	bcs, err := NewBlockchainServer(config)
+	if err != nil {
+		log.Fatalf("ERROR: %s", err.Error())
 	}
-
-	bcs := NewBlockchainServer(uint16(*port))
 
 	if err := bcs.Start(); err != nil {
 		panic(err)
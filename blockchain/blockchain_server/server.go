@@ -2,40 +2,81 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/EmilioCliff/learn-go/blockchain/block"
+	"github.com/EmilioCliff/learn-go/blockchain/utils"
 	"github.com/EmilioCliff/learn-go/blockchain/wallet"
 	"github.com/gin-gonic/gin"
 )
 
-var cache map[string]*block.Blockchain = make(map[string]*block.Blockchain)
-
 type BlockchainServer struct {
-	port    uint16
-	gateway string
-	router  *gin.Engine
-	ln      net.Listener
-	srv     *http.Server
+	port       uint16
+	gateway    string
+	router     *gin.Engine
+	ln         net.Listener
+	srv        *http.Server
+	keystore   *wallet.Keystore
+	blockchain *block.Blockchain
+	config     utils.Config
+	// walletLimiter limits the wallets each client creates, each one is
+	// a key file on disk.
+	walletLimiter *rateLimiter
 }
 
-func NewBlockchainServer(port uint16) *BlockchainServer {
-	r := gin.Default()
+// NewBlockchainServer opens the keystore and the chain of the node
+// configured by config, see utils.LoadConfig.
+func NewBlockchainServer(config utils.Config) (*BlockchainServer, error) {
+	bcs := &BlockchainServer{port: config.PORT, router: gin.Default(), config: config}
+	bcs.walletLimiter = newRateLimiter(config.WALLET_RATE_LIMIT, time.Minute)
+
+	// Every node keeps its own keystore, like its own chain file.
+	keystoreDir := ""
+	if config.KEYSTORE_DIR != "" {
+		keystoreDir = filepath.Join(config.KEYSTORE_DIR, fmt.Sprintf("%d", config.PORT))
+	}
+	ks, err := wallet.NewKeystore(keystoreDir, config.KEYSTORE_ITERATIONS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open keystore: %w", err)
+	}
+	bcs.keystore = ks
+
+	// The miner's key is kept in the keystore under MINER_PASSPHRASE so
+	// the node keeps mining to it, and the rewards can still be spent,
+	// after a restart.
+	minersWallet, err := ks.Miner(config.MINER_PASSPHRASE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open miner wallet: %w", err)
+	}
+	bc, err := block.NewBlockchain(minersWallet.BlockchainAddress(), config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load blockchain: %w", err)
+	}
+	bcs.blockchain = bc
+	log.Printf("blockchain_address %v", minersWallet.BlockchainAddress())
 
-	bcs := &BlockchainServer{port: port, router: r}
 	bcs.setUpRoutes()
-	return bcs
+	return bcs, nil
 }
 
+// nodeKeyContext is the context key of the node key a request is signed
+// with.
+const nodeKeyContext = "node_key"
+
 func (bcs *BlockchainServer) setUpRoutes() {
 	bcs.router.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization, "+keystoreProofHeader)
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "X-Total-Count")
 
 		if c.Request.Method == http.MethodOptions {
 			c.AbortWithStatus(http.StatusNoContent)
@@ -44,21 +85,47 @@ func (bcs *BlockchainServer) setUpRoutes() {
 
 		c.Next()
 	})
-	bcs.router.GET("/wallet", bcs.createWallet)
+	bcs.router.GET("/wallet", bcs.listWallets)
+	bcs.router.POST("/wallet", bcs.walletLimiter.limit, bcs.createWallet)
+	bcs.router.POST("/wallet/import", bcs.adminOnly, bcs.importWallet)
+	bcs.router.GET("/wallet/hd", bcs.listHDWallets)
+	bcs.router.POST("/wallet/hd", bcs.walletLimiter.limit, bcs.createHDWallet)
+	bcs.router.GET("/wallet/hd/:id", bcs.getHDWallet)
+	bcs.router.POST("/wallet/hd/:id/derive", bcs.deriveHDWallet)
+	bcs.router.POST("/wallet/hd/:id/rescan", bcs.rescanHDWallet)
 	bcs.router.GET("/wallet/:blockchain_address", bcs.getWallet)
+	bcs.router.GET("/wallet/:blockchain_address/keystore", bcs.getKeyFile)
+	bcs.router.GET("/wallet/:blockchain_address/keystore/kdf", bcs.getKeyFileKDF)
 	bcs.router.GET("/address/:blockchain_address/amount", bcs.getWalletAmount)
+	bcs.router.GET("/address/:blockchain_address/nonce", bcs.getAddressNonce)
+	bcs.router.GET("/address/:blockchain_address/transactions", bcs.getAddressTransactions)
 	bcs.router.GET("/chain", bcs.getChain)
+	bcs.router.GET("/chain/stats", bcs.chainStats)
+	bcs.router.GET("/blocks", bcs.listBlocks)
+	bcs.router.GET("/blocks/:height", bcs.getBlock)
+	bcs.router.GET("/blocks/hash/:hash", bcs.getBlockByHash)
+	bcs.router.GET("/reorgs", bcs.listReorgs)
 	// bcs.router.DELETE("/wallet/:blockchain_address", bcs.deleteWallet)
 	bcs.router.GET("/transactions", bcs.listTransactionPool)
+	bcs.router.GET("/transactions/:id", bcs.getTransaction)
+	bcs.router.GET("/transactions/:id/proof", bcs.getTransactionProof)
 	bcs.router.POST("/transactions", bcs.createTransaction)
+	bcs.router.GET("/mempool/stats", bcs.mempoolStats)
+	bcs.router.GET("/events", bcs.streamEvents)
+	bcs.router.POST("/multisig", bcs.createMultisig)
 
 	// internal
-	bcs.router.PUT("/transactions", bcs.addTransaction)
-	bcs.router.DELETE("/transactions", bcs.clearTransaction)
-	bcs.router.GET("/mine", bcs.mine)
-	bcs.router.GET("/mine/start", bcs.startMining)
-	bcs.router.GET("/mine/stop", bcs.stopMining)
-	bcs.router.PUT("/consensus", bcs.consensusResolve)
+	bcs.router.PUT("/transactions", bcs.peerOnly, bcs.addTransaction)
+	bcs.router.DELETE("/transactions", bcs.peerOrAdmin, bcs.clearTransaction)
+	bcs.router.GET("/mine", bcs.adminOnly, bcs.mine)
+	bcs.router.GET("/mine/start", bcs.adminOnly, bcs.startMining)
+	bcs.router.GET("/mine/stop", bcs.adminOnly, bcs.stopMining)
+	bcs.router.GET("/mine/stats", bcs.miningStats)
+	bcs.router.PUT("/blocks", bcs.peerOnly, bcs.announceBlock)
+	bcs.router.PUT("/consensus", bcs.peerOrAdmin, bcs.consensusResolve)
+	bcs.router.GET("/node", bcs.getNode)
+	bcs.router.GET("/peers", bcs.listPeers)
+	bcs.router.POST("/peers", bcs.signedByNode, bcs.addPeer)
 
 	bcs.srv = &http.Server{
 		Addr:         bcs.PortAddress(),
@@ -66,10 +133,108 @@ func (bcs *BlockchainServer) setUpRoutes() {
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
 	}
+	bcs.srv.RegisterOnShutdown(func() {
+		bcs.GetBlockchain().CloseSubscriptions()
+	})
+}
+
+// signedByNode lets a request through when it is signed by a node key,
+// which it stores under nodeKeyContext for the handler.
+func (bcs *BlockchainServer) signedByNode(c *gin.Context) {
+	publicKey, err := bcs.GetBlockchain().Peers().Authenticate(c.Request)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "failed", "error": err.Error()})
+		return
+	}
+	c.Set(nodeKeyContext, publicKey)
+	c.Next()
+}
+
+// peerOnly lets a request through when it is signed by the key of a
+// registered node, see block.PeerManager.Registered.
+func (bcs *BlockchainServer) peerOnly(c *gin.Context) {
+	peers := bcs.GetBlockchain().Peers()
+	publicKey, err := peers.Authenticate(c.Request)
+	if err == nil && !peers.Registered(publicKey) {
+		err = fmt.Errorf("node %s is not registered", publicKey)
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "failed", "error": err.Error()})
+		return
+	}
+	c.Set(nodeKeyContext, publicKey)
+	c.Next()
+}
+
+// adminOnly lets a request through when it carries ADMIN_TOKEN as a bearer
+// token. Without a token configured no one gets through.
+func (bcs *BlockchainServer) adminOnly(c *gin.Context) {
+	if !bcs.isAdmin(c) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "failed", "error": "admin token required"})
+		return
+	}
+	c.Next()
+}
+
+// peerOrAdmin lets operators through as well as registered nodes.
+func (bcs *BlockchainServer) peerOrAdmin(c *gin.Context) {
+	if bcs.isAdmin(c) {
+		c.Next()
+		return
+	}
+	bcs.peerOnly(c)
+}
+
+func (bcs *BlockchainServer) isAdmin(c *gin.Context) bool {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return bcs.config.ADMIN_TOKEN != "" && ok && subtle.ConstantTimeCompare([]byte(token), []byte(bcs.config.ADMIN_TOKEN)) == 1
 }
 
+// rateLimiter lets each client, by IP, make n requests a window. The
+// counts start over every window, so it keeps no more than the clients of
+// one window.
+type rateLimiter struct {
+	n      int
+	window time.Duration
+
+	mux    sync.Mutex
+	start  time.Time
+	counts map[string]int
+}
+
+// newRateLimiter allows n requests a window, any number when n is 0.
+func newRateLimiter(n int, window time.Duration) *rateLimiter {
+	return &rateLimiter{n: n, window: window, counts: make(map[string]int)}
+}
+
+func (rl *rateLimiter) allow(client string, now time.Time) bool {
+	if rl.n <= 0 {
+		return true
+	}
+	rl.mux.Lock()
+	defer rl.mux.Unlock()
+	if now.Sub(rl.start) >= rl.window {
+		rl.start = now
+		clear(rl.counts)
+	}
+	if rl.counts[client] >= rl.n {
+		return false
+	}
+	rl.counts[client]++
+	return true
+}
+
+func (rl *rateLimiter) limit(c *gin.Context) {
+	if !rl.allow(c.ClientIP(), time.Now()) {
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"message": "failed", "error": "too many requests, try again later"})
+		return
+	}
+	c.Next()
+}
+
+// Start serves the API and then joins the network: the peers we handshake
+// check back with us at GET /node before they register us.
 func (bcs *BlockchainServer) Start() error {
-	bcs.GetBlockchain().Run()
 	var err error
 	if bcs.ln, err = net.Listen("tcp", bcs.PortAddress()); err != nil {
 		return err
@@ -82,6 +247,7 @@ func (bcs *BlockchainServer) Start() error {
 		}
 	}(bcs)
 
+	bcs.GetBlockchain().Run()
 	return nil
 }
 
@@ -91,20 +257,14 @@ func (bcs *BlockchainServer) Stop() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	return bcs.srv.Shutdown(ctx)
+	if err := bcs.srv.Shutdown(ctx); err != nil {
+		return err
+	}
+	return bcs.GetBlockchain().Close()
 }
 
-// initialize blockchain if not already done
 func (bcs *BlockchainServer) GetBlockchain() *block.Blockchain {
-	bc, ok := cache["blockchain"]
-	if !ok {
-		minersWallet := wallet.NewWallet()
-		bc = block.NewBlockchain(minersWallet.BlockchainAddress(), bcs.Port())
-		cache["blockchain"] = bc
-		bc.RegisterWallet(minersWallet)
-		log.Printf("blockchain_address %v", minersWallet.BlockchainAddress())
-	}
-	return bc
+	return bcs.blockchain
 }
 
 func (bcs *BlockchainServer) Port() uint16 {
@@ -0,0 +1,1263 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/elliptic"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/EmilioCliff/learn-go/blockchain/address"
+	"github.com/EmilioCliff/learn-go/blockchain/block"
+	"github.com/EmilioCliff/learn-go/blockchain/utils"
+	"github.com/EmilioCliff/learn-go/blockchain/wallet"
+	"github.com/gin-gonic/gin"
+)
+
+func newTestServer(t *testing.T) (*BlockchainServer, *httptest.Server) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	t.Setenv("DATA_DIR", t.TempDir())
+	t.Setenv("MINING_WORKERS", "2")
+	t.Setenv("KEYSTORE_DIR", t.TempDir())
+	t.Setenv("KEYSTORE_ITERATIONS", "1000")
+	t.Setenv("ADMIN_TOKEN", adminToken)
+	t.Setenv("MINER_PASSPHRASE", minerPassphrase)
+	t.Setenv("PORT", "0")
+	if os.Getenv("GENESIS_FILE") == "" {
+		// Blocks of the lowest difficulty are mined in no time.
+		genesis := &block.Genesis{Timestamp: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Difficulty: 1}
+		genesisFile := filepath.Join(t.TempDir(), "genesis.json")
+		if err := genesis.Save(genesisFile); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("GENESIS_FILE", genesisFile)
+	}
+
+	config, err := utils.LoadConfig(nil)
+	if err != nil {
+		t.Fatalf("load config: %s", err)
+	}
+	bcs, err := NewBlockchainServer(config)
+	if err != nil {
+		t.Fatalf("new server: %s", err)
+	}
+	ts := httptest.NewServer(bcs.router)
+	t.Cleanup(func() {
+		ts.Close()
+		bc := bcs.GetBlockchain()
+		bc.StopMining()
+		bc.Close()
+	})
+	return bcs, ts
+}
+
+func minerWallet(t *testing.T, bcs *BlockchainServer) *wallet.Wallet {
+	t.Helper()
+	w, err := bcs.keystore.Unlock(bcs.GetBlockchain().BlockchainAddress(), minerPassphrase)
+	if err != nil {
+		t.Fatalf("unlock miner wallet: %s", err)
+	}
+	return w
+}
+
+const (
+	adminToken      = "admin-token"
+	minerPassphrase = "miner-secret"
+)
+
+func do(t *testing.T, method, url string, body any) int {
+	t.Helper()
+	return doRequest(t, newRequest(method, url, body))
+}
+
+// admin sends the request with the admin token.
+func admin(t *testing.T, method, url string) int {
+	t.Helper()
+	req := newRequest(method, url, nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	return doRequest(t, req)
+}
+
+// signed sends the request signed with a node key.
+func signed(t *testing.T, key *block.NodeKey, method, url string, body any) int {
+	t.Helper()
+	req := newRequest(method, url, body)
+	if err := key.SignRequest(req); err != nil {
+		t.Fatal(err)
+	}
+	return doRequest(t, req)
+}
+
+func newRequest(method, url string, body any) *http.Request {
+	var r io.Reader
+	if body != nil {
+		m, _ := json.Marshal(body)
+		r = bytes.NewReader(m)
+	}
+	req, _ := http.NewRequest(method, url, r)
+	return req
+}
+
+func doRequest(t *testing.T, req *http.Request) int {
+	t.Helper()
+	method, url := req.Method, req.URL.String()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("%s %s: %s", method, url, err)
+		return 0
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode
+}
+
+// TestConcurrentRequests hammers the read, write and mining endpoints at
+// the same time, run it with -race.
+func TestConcurrentRequests(t *testing.T) {
+	bcs, ts := newTestServer(t)
+	bc := bcs.GetBlockchain()
+	miner := minerWallet(t, bcs)
+	recipient := wallet.NewWallet()
+
+	if code := admin(t, "GET", ts.URL+"/mine"); code != http.StatusOK {
+		t.Fatalf("GET /mine: status %d", code)
+	}
+
+	const rounds = 20
+	var wg sync.WaitGroup
+	run := func(f func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				f(i)
+			}
+		}()
+	}
+
+	run(func(int) { do(t, "GET", ts.URL+"/chain", nil) })
+	run(func(int) { do(t, "GET", ts.URL+"/transactions", nil) })
+	run(func(int) {
+		do(t, "GET", fmt.Sprintf("%s/address/%s/amount", ts.URL, miner.BlockchainAddress()), nil)
+		do(t, "GET", fmt.Sprintf("%s/address/%s/nonce", ts.URL, miner.BlockchainAddress()), nil)
+	})
+	client := wallet.NewClient(ts.URL)
+	run(func(int) {
+		// Concurrent sends race for the same nonce, some are rejected.
+		client.Send(miner, recipient.BlockchainAddress(), utils.Coin/100)
+	})
+	run(func(int) { admin(t, "GET", ts.URL+"/mine") })
+	run(func(i int) {
+		if i%5 == 0 {
+			admin(t, "DELETE", ts.URL+"/transactions")
+		}
+		do(t, "POST", ts.URL+"/wallet", map[string]string{"passphrase": "secret"})
+	})
+	run(func(int) { admin(t, "PUT", ts.URL+"/consensus") })
+	run(func(i int) {
+		if i%2 == 0 {
+			admin(t, "GET", ts.URL+"/mine/start")
+		} else {
+			admin(t, "GET", ts.URL+"/mine/stop")
+		}
+		do(t, "GET", ts.URL+"/mine/stats", nil)
+	})
+	wg.Wait()
+	bc.StopMining()
+
+	chain := bc.Chain()
+	if len(chain) < 2 {
+		t.Fatalf("chain has %d blocks, want at least 2", len(chain))
+	}
+	if !bc.ValidChain(chain) {
+		t.Fatal("chain is invalid after concurrent requests")
+	}
+
+	if got, want := bc.NextNonce(miner.BlockchainAddress()), uint64(len(bc.TransactionsPool())); got < want {
+		t.Fatalf("next nonce %d is behind the %d pooled transactions", got, want)
+	}
+}
+
+func TestSubmitSignedTransaction(t *testing.T) {
+	bcs, ts := newTestServer(t)
+	bc := bcs.GetBlockchain()
+	miner := minerWallet(t, bcs)
+	recipient := wallet.NewWallet()
+	client := wallet.NewClient(ts.URL)
+
+	if code := admin(t, "GET", ts.URL+"/mine"); code != http.StatusOK {
+		t.Fatalf("GET /mine: status %d", code)
+	}
+	id, err := client.Send(miner, recipient.BlockchainAddress(), utils.Coin/2)
+	if err != nil {
+		t.Fatalf("send: %s", err)
+	}
+	if status := bc.LookupTransaction(id); status == nil || status.Status != block.TransactionPending {
+		t.Fatalf("transaction %s is not pending", id)
+	}
+	if got, err := client.Balance(recipient.BlockchainAddress()); err != nil || got != utils.Coin/2 {
+		t.Fatalf("recipient balance = %s, %v", got, err)
+	}
+
+	// A transaction signed by another key is rejected.
+	st := miner.NewTransaction(bc.ChainID(), recipient.BlockchainAddress(), utils.Coin/4, 1).Sign()
+	st.SenderPublicKey = recipient.PublicKeyStr()
+	if _, err := client.Submit(st); err == nil {
+		t.Fatal("transaction with a foreign public key was accepted")
+	}
+
+	// A signature for another chain is not valid on this one.
+	foreign := miner.NewTransaction("other-chain", recipient.BlockchainAddress(), utils.Coin/4, 1).Sign()
+	if _, err := client.Submit(foreign); err == nil {
+		t.Fatal("transaction signed for another chain was accepted")
+	}
+
+	// A valid signature does not let a key spend from another address.
+	spoofed := wallet.NewTransaction(recipient.PrivateKey(), recipient.PublicKey(), bc.ChainID(), miner.BlockchainAddress(), recipient.BlockchainAddress(), utils.Coin/4, 1).Sign()
+	if _, err := client.Submit(spoofed); err == nil {
+		t.Fatal("transaction signed by a key that does not own the sender address was accepted")
+	}
+
+	// Recipients must be well formed addresses.
+	addr := recipient.BlockchainAddress()
+	corrupted := addr[:len(addr)-1] + "1"
+	if strings.HasSuffix(addr, "1") {
+		corrupted = addr[:len(addr)-1] + "2"
+	}
+	for _, bad := range []string{"", "not-an-address", addr[:len(addr)-1], corrupted} {
+		if err := address.Validate(bad); err == nil {
+			t.Fatalf("address %q is valid", bad)
+		}
+		st := miner.NewTransaction(bc.ChainID(), bad, utils.Coin/4, 1).Sign()
+		if _, err := client.Submit(st); err == nil {
+			t.Fatalf("transaction to %q was accepted", bad)
+		}
+	}
+
+	// Private keys are not a way to get a transaction signed anymore.
+	code := do(t, "POST", ts.URL+"/transactions", map[string]any{
+		"sender_private_key":           miner.PrivateKeyStr(),
+		"sender_public_key":            miner.PublicKeyStr(),
+		"sender_blockchain_address":    miner.BlockchainAddress(),
+		"recipient_blockchain_address": recipient.BlockchainAddress(),
+		"value":                        "0.1",
+	})
+	if code != http.StatusBadRequest {
+		t.Fatalf("unsigned transaction: status %d, want 400", code)
+	}
+}
+
+func TestWalletRateLimit(t *testing.T) {
+	t.Setenv("WALLET_RATE_LIMIT", "2")
+	_, ts := newTestServer(t)
+
+	body := map[string]any{"passphrase": "secret"}
+	for i := range 2 {
+		if code := do(t, "POST", ts.URL+"/wallet", body); code != http.StatusOK {
+			t.Fatalf("wallet %d: status %d", i, code)
+		}
+	}
+	if code := do(t, "POST", ts.URL+"/wallet", body); code != http.StatusTooManyRequests {
+		t.Fatalf("wallet over the limit: status %d, want 429", code)
+	}
+	if code := do(t, "POST", ts.URL+"/wallet/hd", body); code != http.StatusTooManyRequests {
+		t.Fatalf("HD wallet over the limit: status %d, want 429", code)
+	}
+}
+
+func TestKeystore(t *testing.T) {
+	bcs, ts := newTestServer(t)
+	bcs.GetBlockchain()
+
+	resp, err := http.Post(ts.URL+"/wallet", "application/json", bytes.NewReader([]byte(`{"passphrase":"secret"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var created map[string]any
+	json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	address, _ := created["blockchain_address"].(string)
+	if address == "" {
+		t.Fatalf("POST /wallet: %v", created)
+	}
+
+	// The key file only goes to whoever proves the passphrase.
+	resp, err = http.Get(ts.URL + "/wallet/" + address + "/keystore/kdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kdf wallet.KeyCrypto
+	json.NewDecoder(resp.Body).Decode(&kdf)
+	resp.Body.Close()
+	keyFile := func(passphrase string) int {
+		t.Helper()
+		req := newRequest("GET", ts.URL+"/wallet/"+address+"/keystore", nil)
+		if passphrase != "" {
+			proof, err := wallet.DownloadProof(&kdf, passphrase)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("X-Keystore-Proof", proof)
+		}
+		return doRequest(t, req)
+	}
+	if code := keyFile(""); code != http.StatusForbidden {
+		t.Fatalf("GET key file without a proof: status %d, want 403", code)
+	}
+	if code := keyFile("wrong"); code != http.StatusForbidden {
+		t.Fatalf("GET key file with a wrong proof: status %d, want 403", code)
+	}
+	if code := keyFile("secret"); code != http.StatusOK {
+		t.Fatalf("GET key file: status %d", code)
+	}
+	proof, _ := wallet.DownloadProof(&kdf, "secret")
+	for _, path := range []string{"/wallet", "/wallet/" + address, "/wallet/" + address + "/keystore", "/chain"} {
+		req := newRequest("GET", ts.URL+path, nil)
+		req.Header.Set("X-Keystore-Proof", proof)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if bytes.Contains(body, []byte("private_key")) {
+			t.Errorf("GET %s leaks a private key: %s", path, body)
+		}
+	}
+
+	if _, err := bcs.keystore.Unlock(address, "wrong"); err != wallet.ErrWrongPassphrase {
+		t.Fatalf("unlock with a wrong passphrase: %v", err)
+	}
+	w, err := bcs.keystore.Unlock(address, "secret")
+	if err != nil {
+		t.Fatalf("unlock: %s", err)
+	}
+
+	// Every export format imports back to the same address.
+	for _, format := range []string{wallet.KeyFormatHex, wallet.KeyFormatPEM, wallet.KeyFormatWIF} {
+		key, err := bcs.keystore.Export(address, "secret", format)
+		if err != nil {
+			t.Fatalf("export %s: %s", format, err)
+		}
+		other, err := wallet.NewKeystore("", 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		imported, err := other.Import(key, format, "other")
+		if err != nil {
+			t.Fatalf("import %s: %s", format, err)
+		}
+		if imported.BlockchainAddress() != w.BlockchainAddress() {
+			t.Fatalf("%s round trip gave %s, want %s", format, imported.BlockchainAddress(), w.BlockchainAddress())
+		}
+	}
+
+	// Private keys are only imported for the admin.
+	other := wallet.NewWallet()
+	body := map[string]any{"key": other.PrivateKeyStr(), "format": wallet.KeyFormatHex, "passphrase": "secret"}
+	if code := do(t, "POST", ts.URL+"/wallet/import", body); code != http.StatusUnauthorized {
+		t.Fatalf("import without the admin token: status %d, want 401", code)
+	}
+	req := newRequest("POST", ts.URL+"/wallet/import", body)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	if code := doRequest(t, req); code != http.StatusOK {
+		t.Fatalf("import: status %d", code)
+	}
+	if _, err := bcs.keystore.Unlock(other.BlockchainAddress(), "secret"); err != nil {
+		t.Fatalf("unlock imported wallet: %s", err)
+	}
+
+	// A restarted node mines to the miner wallet it already has.
+	reopened, err := wallet.NewKeystore(filepath.Join(bcs.config.KEYSTORE_DIR, "0"), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reopened.Miner("wrong"); err != wallet.ErrWrongPassphrase {
+		t.Fatalf("miner wallet with a wrong passphrase: %v", err)
+	}
+	miner, err := reopened.Miner(minerPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if miner.BlockchainAddress() != bcs.GetBlockchain().BlockchainAddress() {
+		t.Fatalf("reopened miner wallet %s, want %s", miner.BlockchainAddress(), bcs.GetBlockchain().BlockchainAddress())
+	}
+}
+
+func post(t *testing.T, url string, body any, out any) int {
+	t.Helper()
+	m, _ := json.Marshal(body)
+	resp, err := http.Post(url, "application/json", bytes.NewReader(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	json.NewDecoder(resp.Body).Decode(out)
+	return resp.StatusCode
+}
+
+func TestHDWallet(t *testing.T) {
+	bcs, ts := newTestServer(t)
+	bc := bcs.GetBlockchain()
+	miner := minerWallet(t, bcs)
+	client := wallet.NewClient(ts.URL)
+
+	var created struct {
+		Wallet   wallet.HDWalletInfo `json:"wallet"`
+		Mnemonic string              `json:"mnemonic"`
+	}
+	if code := post(t, ts.URL+"/wallet/hd", map[string]string{"passphrase": "secret"}, &created); code != http.StatusOK {
+		t.Fatalf("POST /wallet/hd: status %d", code)
+	}
+	hw, err := wallet.NewHDWallet(created.Mnemonic, "")
+	if err != nil {
+		t.Fatalf("mnemonic %q: %s", created.Mnemonic, err)
+	}
+	id := created.Wallet.ID
+
+	// Derived addresses follow the mnemonic and are regular wallets.
+	var derived wallet.WalletInfo
+	if code := post(t, ts.URL+"/wallet/hd/"+id+"/derive", map[string]string{"passphrase": "secret"}, &derived); code != http.StatusOK {
+		t.Fatalf("derive: status %d", code)
+	}
+	if w, _ := hw.Wallet(1); derived.BlockchainAddress != w.BlockchainAddress() {
+		t.Fatalf("derived %s, want %s", derived.BlockchainAddress, w.BlockchainAddress())
+	}
+	if _, err := bcs.keystore.Unlock(derived.BlockchainAddress, "secret"); err != nil {
+		t.Fatalf("unlock derived wallet: %s", err)
+	}
+	if code := post(t, ts.URL+"/wallet/hd/"+id+"/derive", map[string]string{"passphrase": "wrong"}, &derived); code != http.StatusBadRequest {
+		t.Fatalf("derive with a wrong passphrase: status %d", code)
+	}
+
+	// Coins sent to an address past the derived ones are found by a rescan.
+	admin(t, "GET", ts.URL+"/mine")
+	far, _ := hw.Wallet(4)
+	if _, err := client.Send(miner, far.BlockchainAddress(), utils.Coin/2); err != nil {
+		t.Fatalf("send: %s", err)
+	}
+	if code := post(t, ts.URL+"/wallet/hd/"+id+"/rescan", map[string]any{"passphrase": "secret", "gap_limit": wallet.MaxGapLimit + 1}, nil); code != http.StatusBadRequest {
+		t.Fatalf("rescan with a gap limit above %d: status %d", wallet.MaxGapLimit, code)
+	}
+	var rescan struct {
+		Wallet wallet.HDWalletInfo `json:"wallet"`
+		Amount utils.Amount        `json:"amount"`
+	}
+	if code := post(t, ts.URL+"/wallet/hd/"+id+"/rescan", map[string]string{"passphrase": "secret"}, &rescan); code != http.StatusOK {
+		t.Fatalf("rescan: status %d", code)
+	}
+	if rescan.Wallet.NextIndex != 5 || rescan.Amount != utils.Coin/2 {
+		t.Fatalf("rescan found %d addresses holding %s, want 5 holding %s", rescan.Wallet.NextIndex, rescan.Amount, utils.Coin/2)
+	}
+
+	// The mnemonic alone restores the same addresses elsewhere.
+	other, _ := wallet.NewKeystore("", 1000)
+	restored, err := other.RestoreHD(created.Mnemonic, "other", "")
+	if err != nil {
+		t.Fatalf("restore: %s", err)
+	}
+	restored, _ = other.Rescan(restored.ID, "other", 0, bc.AddressUsed)
+	if fmt.Sprint(restored.Addresses) != fmt.Sprint(rescan.Wallet.Addresses) {
+		t.Fatalf("restored %v, want %v", restored.Addresses, rescan.Wallet.Addresses)
+	}
+}
+
+func TestMultisigTransaction(t *testing.T) {
+	bcs, ts := newTestServer(t)
+	bc := bcs.GetBlockchain()
+	miner := minerWallet(t, bcs)
+	client := wallet.NewClient(ts.URL)
+	keys := []*wallet.Wallet{wallet.NewWallet(), wallet.NewWallet(), wallet.NewWallet()}
+	recipient := wallet.NewWallet()
+
+	var created struct {
+		BlockchainAddress string            `json:"blockchain_address"`
+		Policy            *address.Multisig `json:"policy"`
+	}
+	body := map[string]any{"m": 2, "public_keys": []string{keys[0].PublicKeyStr(), keys[1].PublicKeyStr(), keys[2].PublicKeyStr()}}
+	if code := post(t, ts.URL+"/multisig", body, &created); code != http.StatusOK {
+		t.Fatalf("POST /multisig: status %d", code)
+	}
+	policy := created.Policy
+	if !address.IsMultisig(created.BlockchainAddress) || policy.Address() != created.BlockchainAddress {
+		t.Fatalf("multisig address %q does not match its policy", created.BlockchainAddress)
+	}
+	if code := post(t, ts.URL+"/multisig", map[string]any{"m": 4, "public_keys": body["public_keys"]}, &struct{}{}); code != http.StatusBadRequest {
+		t.Fatalf("3 of 2 policy: status %d, want 400", code)
+	}
+
+	admin(t, "GET", ts.URL+"/mine")
+	if _, err := client.Send(miner, policy.Address(), utils.Coin/2); err != nil {
+		t.Fatalf("fund multisig: %s", err)
+	}
+
+	st, err := client.NewMultisigTransaction(policy, recipient.BlockchainAddress(), utils.Coin/4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := recipient.CoSign(st); err != wallet.ErrNotCosigner {
+		t.Fatalf("co-signed by a key outside the policy: %v", err)
+	}
+	if err := keys[2].CoSign(st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Complete() {
+		t.Fatal("1 of 2 signatures is complete")
+	}
+	if _, err := client.Submit(st); err == nil {
+		t.Fatal("transaction with 1 of 2 signatures was accepted")
+	}
+	// The same key in another case is still one signature.
+	doubled := *st
+	doubled.Signatures = map[string]string{}
+	for k, s := range st.Signatures {
+		doubled.Signatures[k] = s
+		doubled.Signatures[strings.ToUpper(k)] = s
+	}
+	if _, err := client.Submit(&doubled); err == nil {
+		t.Fatal("transaction signed twice by the same key was accepted")
+	}
+
+	// Partially signed transactions travel as JSON between the co-signers.
+	m, _ := json.Marshal(st)
+	var relayed wallet.SignedTransaction
+	json.Unmarshal(m, &relayed)
+	if err := keys[0].CoSign(&relayed); err != nil {
+		t.Fatal(err)
+	}
+	if !relayed.Complete() {
+		t.Fatal("2 of 2 signatures is not complete")
+	}
+	tampered := relayed
+	tampered.Value = utils.Coin / 2
+	if _, err := client.Submit(&tampered); err == nil {
+		t.Fatal("transaction with a changed value was accepted")
+	}
+	if _, err := client.Submit(&relayed); err != nil {
+		t.Fatalf("submit 2 of 3: %s", err)
+	}
+	if got := bc.CalculateTotalAmount(recipient.BlockchainAddress()); got != utils.Coin/4 {
+		t.Fatalf("recipient balance = %s, want %s", got, utils.Coin/4)
+	}
+}
+
+func TestTimeLockedTransaction(t *testing.T) {
+	bcs, ts := newTestServer(t)
+	bc := bcs.GetBlockchain()
+	miner := minerWallet(t, bcs)
+	client := wallet.NewClient(ts.URL)
+	recipient := wallet.NewWallet()
+
+	admin(t, "GET", ts.URL+"/mine")
+	unlockHeight := uint64(len(bc.Chain()) + 2)
+	nonce, _ := client.Nonce(miner.BlockchainAddress())
+	st := miner.NewTransaction(bc.ChainID(), recipient.BlockchainAddress(), utils.Coin/2, nonce).LockUntil(unlockHeight, 0).Sign()
+	if _, err := client.Submit(st); err != nil {
+		t.Fatalf("submit locked transaction: %s", err)
+	}
+	if got := bc.CalculateTotalAmount(recipient.BlockchainAddress()); got != utils.Coin/2 {
+		t.Fatalf("recipient balance = %s, want %s", got, utils.Coin/2)
+	}
+
+	for uint64(len(bc.Chain())) < unlockHeight {
+		if got := bc.SpendableAmount(recipient.BlockchainAddress()); got != 0 {
+			t.Fatalf("height %d: spendable %s before unlock height %d", len(bc.Chain()), got, unlockHeight)
+		}
+		if _, err := client.Send(recipient, miner.BlockchainAddress(), utils.Coin/4); err == nil {
+			t.Fatalf("height %d: locked value spent before height %d", len(bc.Chain()), unlockHeight)
+		}
+		admin(t, "GET", ts.URL+"/mine")
+	}
+	if got := bc.SpendableAmount(recipient.BlockchainAddress()); got != utils.Coin/2 {
+		t.Fatalf("spendable %s after unlock, want %s", got, utils.Coin/2)
+	}
+	if _, err := client.Send(recipient, miner.BlockchainAddress(), utils.Coin/4); err != nil {
+		t.Fatalf("spend unlocked value: %s", err)
+	}
+
+	// A lock in time holds until the wall clock passes it.
+	nonce, _ = client.Nonce(miner.BlockchainAddress())
+	later := wallet.NewWallet()
+	st = miner.NewTransaction(bc.ChainID(), later.BlockchainAddress(), utils.Coin/4, nonce).LockUntil(0, time.Now().Add(time.Hour).Unix()).Sign()
+	if _, err := client.Submit(st); err != nil {
+		t.Fatalf("submit time locked transaction: %s", err)
+	}
+	if _, err := client.Send(later, miner.BlockchainAddress(), utils.Coin/8); err == nil {
+		t.Fatal("value locked for an hour was spent")
+	}
+}
+
+func TestTransactionFees(t *testing.T) {
+	// Room for the reward and two transfers, a pool of three.
+	t.Setenv("MAX_BLOCK_SIZE", "480")
+	t.Setenv("MEMPOOL_SIZE", "3")
+	bcs, ts := newTestServer(t)
+	bc := bcs.GetBlockchain()
+	miner := minerWallet(t, bcs)
+	client := wallet.NewClient(ts.URL)
+	senders := []*wallet.Wallet{wallet.NewWallet(), wallet.NewWallet(), wallet.NewWallet()}
+
+	admin(t, "GET", ts.URL+"/mine")
+	for _, w := range senders {
+		if _, err := client.Send(miner, w.BlockchainAddress(), utils.Coin/10); err != nil {
+			t.Fatalf("fund %s: %s", w.BlockchainAddress(), err)
+		}
+		admin(t, "GET", ts.URL+"/mine")
+	}
+	if n := len(bc.TransactionsPool()); n != 0 {
+		t.Fatalf("%d transactions left in the pool after funding", n)
+	}
+
+	// The value and the fee must both be covered.
+	if _, err := client.SendWithFee(senders[0], miner.BlockchainAddress(), utils.Coin/10, 1); err == nil {
+		t.Fatal("transaction whose fee exceeds the balance was accepted")
+	}
+
+	ids := make([]string, len(senders))
+	for i, fee := range []utils.Amount{100_000, 300_000, 200_000} {
+		id, err := client.SendWithFee(senders[i], miner.BlockchainAddress(), utils.Coin/100, fee)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = id
+	}
+	low, high, mid := ids[0], ids[1], ids[2]
+	if _, err := client.SendWithFee(miner, senders[0].BlockchainAddress(), utils.Coin/100, 50_000); err == nil {
+		t.Fatal("full pool accepted a transaction paying less than all of it")
+	}
+	best, err := client.SendWithFee(miner, senders[0].BlockchainAddress(), utils.Coin/100, 400_000)
+	if err != nil {
+		t.Fatalf("full pool rejected a better paying transaction: %s", err)
+	}
+	if bc.LookupTransaction(low) != nil {
+		t.Fatal("lowest paying transaction was not evicted")
+	}
+	pool := bc.TransactionsPool()
+	if len(pool) != 3 || pool[0].ID() != best || pool[1].ID() != high || pool[2].ID() != mid {
+		t.Fatal("pool is not ordered by fee rate")
+	}
+
+	// Only two fit in the block, the best paying ones.
+	before := bc.CalculateTotalAmount(miner.BlockchainAddress())
+	admin(t, "GET", ts.URL+"/mine")
+	for _, id := range []string{best, high} {
+		if status := bc.LookupTransaction(id); status == nil || status.Status != block.TransactionConfirmed {
+			t.Fatalf("transaction %s was not mined", id)
+		}
+	}
+	if status := bc.LookupTransaction(mid); status == nil || status.Status != block.TransactionPending {
+		t.Fatalf("transaction %s is not pending", mid)
+	}
+	// The pool already counted in the values, mining adds the reward and fees.
+	want := before + utils.Coin + 700_000
+	if got := bc.CalculateTotalAmount(miner.BlockchainAddress()); got != want {
+		t.Fatalf("miner balance = %s, want %s", got, want)
+	}
+	if got := bc.CalculateTotalAmount(senders[1].BlockchainAddress()); got != utils.Coin/10-utils.Coin/100-300_000 {
+		t.Fatalf("sender paid %s", utils.Coin/10-got)
+	}
+}
+
+// TestAmountOverflow sends a value and a fee that only add up to less
+// than the sender's empty balance because their sum wraps around.
+func TestAmountOverflow(t *testing.T) {
+	bcs, ts := newTestServer(t)
+	bc := bcs.GetBlockchain()
+	client := wallet.NewClient(ts.URL)
+	sender, recipient := wallet.NewWallet(), wallet.NewWallet()
+	huge := 50_000_000_000 * utils.Coin
+
+	if _, err := client.SendWithFee(sender, recipient.BlockchainAddress(), huge, huge); err == nil {
+		t.Fatal("transaction whose value and fee overflow was accepted")
+	}
+
+	// The node rejects it even when it skips the request validation.
+	st := sender.NewTransaction(bc.ChainID(), recipient.BlockchainAddress(), huge, 0).WithFee(huge).Sign()
+	m, _ := json.Marshal(st)
+	var tr block.TransactionRequest
+	if err := json.Unmarshal(m, &tr); err != nil {
+		t.Fatal(err)
+	}
+	if tr.Validate() {
+		t.Fatal("request whose value and fee overflow is valid")
+	}
+	if bc.AddTransaction(tr.Transaction(), tr.Witness()) {
+		t.Fatal("pool admitted a transaction whose value and fee overflow")
+	}
+
+	admin(t, "GET", ts.URL+"/mine")
+	if got := bc.CalculateTotalAmount(sender.BlockchainAddress()); got != 0 {
+		t.Fatalf("sender balance = %s, want 0", got)
+	}
+}
+
+func TestMempoolPolicies(t *testing.T) {
+	t.Setenv("MEMPOOL_MAX_PER_SENDER", "2")
+	t.Setenv("MEMPOOL_TTL", "1s")
+	peer, _ := block.NewNodeKey()
+	t.Setenv("PEER_KEYS", peer.PublicKey())
+	bcs, ts := newTestServer(t)
+	bc := bcs.GetBlockchain()
+	miner := minerWallet(t, bcs)
+	client := wallet.NewClient(ts.URL)
+	recipient := wallet.NewWallet().BlockchainAddress()
+
+	admin(t, "GET", ts.URL+"/mine")
+	send := func(nonce uint64, fee utils.Amount) (string, error) {
+		return client.Submit(miner.NewTransaction(bc.ChainID(), recipient, utils.Coin/100, nonce).WithFee(fee).Sign())
+	}
+	first, err := send(0, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := send(1, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := send(2, 1000); err == nil {
+		t.Fatal("sender went over MEMPOOL_MAX_PER_SENDER")
+	}
+
+	// Replace by fee takes MEMPOOL_RBF_BUMP percent more.
+	if _, err := send(0, 1050); err == nil {
+		t.Fatal("replacement paying 5% more was accepted")
+	}
+	replacement, err := send(0, 2000)
+	if err != nil {
+		t.Fatalf("replacement paying twice the fee: %s", err)
+	}
+	if bc.LookupTransaction(first) != nil || bc.LookupTransaction(replacement) == nil || bc.LookupTransaction(second) == nil {
+		t.Fatal("replacement did not take the place of the first transaction only")
+	}
+
+	// Transactions older than MEMPOOL_TTL are dropped.
+	time.Sleep(1100 * time.Millisecond)
+	if _, err := send(0, 1000); err != nil {
+		t.Fatalf("resend after expiry: %s", err)
+	}
+	if bc.LookupTransaction(replacement) != nil || bc.LookupTransaction(second) != nil {
+		t.Fatal("expired transactions are still pending")
+	}
+
+	// Only peers may clear the pool.
+	if code := do(t, "DELETE", ts.URL+"/transactions", nil); code != http.StatusUnauthorized {
+		t.Fatalf("DELETE /transactions unsigned: status %d", code)
+	}
+	if code := signed(t, peer, "DELETE", ts.URL+"/transactions", nil); code != http.StatusOK || len(bc.TransactionsPool()) != 0 {
+		t.Fatalf("DELETE /transactions signed by a peer: status %d", code)
+	}
+
+	resp, err := http.Get(ts.URL + "/mempool/stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var stats block.MempoolStats
+	json.NewDecoder(resp.Body).Decode(&stats)
+	want := block.MempoolStats{Admitted: 4, Rejected: 2, Replaced: 1, Expired: 2, Cleared: 1}
+	if stats != want {
+		t.Fatalf("stats = %+v, want %+v", stats, want)
+	}
+}
+
+func get(t *testing.T, url string, out any) int {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	json.NewDecoder(resp.Body).Decode(out)
+	return resp.StatusCode
+}
+
+func TestExplorer(t *testing.T) {
+	bcs, ts := newTestServer(t)
+	bc := bcs.GetBlockchain()
+	miner := minerWallet(t, bcs)
+	client := wallet.NewClient(ts.URL)
+	recipient := wallet.NewWallet().BlockchainAddress()
+
+	admin(t, "GET", ts.URL+"/mine")
+	mined, err := client.Send(miner, recipient, utils.Coin/10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin(t, "GET", ts.URL+"/mine")
+	pending, err := client.Send(miner, recipient, utils.Coin/100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Get(ts.URL + "/blocks?offset=1&limit=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var blocks []*block.Block
+	json.NewDecoder(resp.Body).Decode(&blocks)
+	resp.Body.Close()
+	if len(blocks) != 1 || blocks[0].Hash() != bc.Chain()[1].Hash() || resp.Header.Get("X-Total-Count") != "3" {
+		t.Fatalf("GET /blocks?offset=1&limit=1 gave %d blocks of %s", len(blocks), resp.Header.Get("X-Total-Count"))
+	}
+
+	var byHeight, byHash block.BlockResponse
+	if code := get(t, ts.URL+"/blocks/2", &byHeight); code != 200 || byHeight.Height != 2 || byHeight.Confirmations != 1 {
+		t.Fatalf("GET /blocks/2: status %d, height %d", code, byHeight.Height)
+	}
+	hash := fmt.Sprintf("%x", byHeight.Block.Hash())
+	if code := get(t, ts.URL+"/blocks/hash/"+hash, &byHash); code != 200 || byHash.Height != 2 {
+		t.Fatalf("GET /blocks/hash/%s: status %d, height %d", hash, code, byHash.Height)
+	}
+	if code := do(t, "GET", ts.URL+"/blocks/3", nil); code != http.StatusNotFound {
+		t.Fatalf("GET /blocks/3 past the tip: status %d", code)
+	}
+
+	// The pending transaction comes first, then the confirmed ones newest
+	// first.
+	var page block.AddressTransactionsResponse
+	get(t, ts.URL+"/address/"+recipient+"/transactions?limit=1", &page)
+	if page.Total != 2 || len(page.Transactions) != 1 || page.Transactions[0].Transaction.ID() != pending {
+		t.Fatalf("first page = %+v", page)
+	}
+	get(t, ts.URL+"/address/"+recipient+"/transactions?offset=1&limit=1", &page)
+	if len(page.Transactions) != 1 || page.Transactions[0].Transaction.ID() != mined ||
+		page.Transactions[0].BlockHeight == nil || *page.Transactions[0].BlockHeight != 2 || page.Transactions[0].BlockHash != hash {
+		t.Fatalf("second page = %+v", page)
+	}
+
+	var stats block.ChainStats
+	get(t, ts.URL+"/chain/stats", &stats)
+	if stats.Height != 2 || stats.TotalSupply != 2*utils.Coin || stats.Transactions != 3 || stats.PendingCount != 1 || stats.AverageBlockTime <= 0 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+// subscribe opens GET /events?query and returns the events as they arrive.
+func subscribe(t *testing.T, url string) <-chan block.Event {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: status %d", url, resp.StatusCode)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	events := make(chan block.Event, 16)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data:")
+			if !ok {
+				continue
+			}
+			var e struct {
+				Type string          `json:"type"`
+				Data json.RawMessage `json:"data"`
+			}
+			json.Unmarshal([]byte(data), &e)
+			events <- block.Event{Type: e.Type, Data: e.Data}
+		}
+	}()
+	return events
+}
+
+func next(t *testing.T, events <-chan block.Event) block.Event {
+	t.Helper()
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event within 5s")
+		return block.Event{}
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	bcs, ts := newTestServer(t)
+	miner := minerWallet(t, bcs)
+	client := wallet.NewClient(ts.URL)
+	recipient := wallet.NewWallet().BlockchainAddress()
+
+	payments := subscribe(t, ts.URL+"/events?type=block,transaction&address="+recipient)
+	mining := subscribe(t, ts.URL+"/events?type=mining")
+	if code := do(t, "GET", ts.URL+"/events?address=nope", nil); code != http.StatusBadRequest {
+		t.Fatalf("GET /events with an invalid address: status %d", code)
+	}
+
+	// A block that does not pay the recipient only shows up as mining state.
+	admin(t, "GET", ts.URL+"/mine")
+	var state block.MiningState
+	for _, searching := range []bool{true, false} {
+		e := next(t, mining)
+		json.Unmarshal(e.Data.(json.RawMessage), &state)
+		if e.Type != block.EventMining || state.Searching != searching || state.Height != 1 {
+			t.Fatalf("mining event %+v, want searching %t", state, searching)
+		}
+	}
+
+	id, err := client.Send(miner, recipient, utils.Coin/10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status block.TransactionStatusResponse
+	e := next(t, payments)
+	json.Unmarshal(e.Data.(json.RawMessage), &status)
+	if e.Type != block.EventTransaction || status.Transaction.ID() != id || status.Status != block.TransactionPending {
+		t.Fatalf("got %s event for %+v, want pending transaction %s", e.Type, status, id)
+	}
+
+	admin(t, "GET", ts.URL+"/mine")
+	var br block.BlockResponse
+	e = next(t, payments)
+	json.Unmarshal(e.Data.(json.RawMessage), &br)
+	if e.Type != block.EventBlock || br.Height != 2 || len(br.Block.Transactions()) != 2 || br.Block.Transactions()[1].ID() != id {
+		t.Fatalf("got %s event at height %d, want the block confirming %s", e.Type, br.Height, id)
+	}
+}
+
+func TestPeerAuthentication(t *testing.T) {
+	registered, _ := block.NewNodeKey()
+	stranger, _ := block.NewNodeKey()
+	t.Setenv("PEER_KEYS", registered.PublicKey())
+	bcs, ts := newTestServer(t)
+	bc := bcs.GetBlockchain()
+
+	// Mining control takes the admin token.
+	if code := do(t, "GET", ts.URL+"/mine", nil); code != http.StatusUnauthorized {
+		t.Fatalf("GET /mine without the admin token: status %d", code)
+	}
+	if code := do(t, "GET", ts.URL+"/mine/start", nil); code != http.StatusUnauthorized {
+		t.Fatalf("GET /mine/start without the admin token: status %d", code)
+	}
+	if code := admin(t, "GET", ts.URL+"/mine/stop"); code != http.StatusOK {
+		t.Fatalf("GET /mine/stop with the admin token: status %d", code)
+	}
+
+	// Internal routes take a request signed by a registered node, or the
+	// admin token where operators may call them too.
+	if code := do(t, "PUT", ts.URL+"/consensus", nil); code != http.StatusUnauthorized {
+		t.Fatalf("PUT /consensus unsigned: status %d", code)
+	}
+	if code := signed(t, stranger, "PUT", ts.URL+"/consensus", nil); code != http.StatusUnauthorized {
+		t.Fatalf("PUT /consensus signed by an unregistered node: status %d", code)
+	}
+	if code := signed(t, registered, "PUT", ts.URL+"/consensus", nil); code != http.StatusOK {
+		t.Fatalf("PUT /consensus signed by a registered node: status %d", code)
+	}
+	if code := admin(t, "PUT", ts.URL+"/consensus"); code != http.StatusOK {
+		t.Fatalf("PUT /consensus with the admin token: status %d", code)
+	}
+	if code := admin(t, "PUT", ts.URL+"/blocks"); code != http.StatusUnauthorized {
+		t.Fatalf("PUT /blocks with the admin token: status %d", code)
+	}
+
+	// A signature covers the body and cannot be replayed.
+	req := newRequest("PUT", ts.URL+"/transactions", map[string]string{})
+	registered.SignRequest(req)
+	tampered := newRequest("PUT", ts.URL+"/transactions", map[string]string{"value": "1"})
+	tampered.Header = req.Header
+	if code := doRequest(t, tampered); code != http.StatusUnauthorized {
+		t.Fatalf("PUT /transactions with a tampered body: status %d", code)
+	}
+	req = newRequest("PUT", ts.URL+"/transactions", map[string]string{})
+	registered.SignRequest(req)
+	replay := req.Clone(req.Context())
+	replay.Body, _ = req.GetBody()
+	if code := doRequest(t, req); code != http.StatusBadRequest {
+		t.Fatalf("PUT /transactions signed by a registered node: status %d", code)
+	}
+	if code := doRequest(t, replay); code != http.StatusUnauthorized {
+		t.Fatalf("replayed PUT /transactions: status %d", code)
+	}
+	// Nor replayed under another encoding of the same signature.
+	sig := utils.SignatureFromString(req.Header.Get(block.HeaderNodeSignature))
+	malleated := req.Clone(req.Context())
+	malleated.Body, _ = req.GetBody()
+	sig.S.Sub(elliptic.P256().Params().N, sig.S)
+	malleated.Header.Set(block.HeaderNodeSignature, sig.String())
+	if code := doRequest(t, malleated); code != http.StatusUnauthorized {
+		t.Fatalf("PUT /transactions replayed with (r, n-s): status %d", code)
+	}
+	upper := req.Clone(req.Context())
+	upper.Body, _ = req.GetBody()
+	upper.Header.Set(block.HeaderNodeSignature, strings.ToUpper(req.Header.Get(block.HeaderNodeSignature)))
+	if code := doRequest(t, upper); code != http.StatusUnauthorized {
+		t.Fatalf("PUT /transactions replayed with an upper case signature: status %d", code)
+	}
+
+	// Blocks are only taken from peers, missing ones fetched from the URL
+	// they registered at and not from an origin named in the body.
+	var fetched atomic.Int32
+	evil := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched.Add(1)
+		http.NotFound(w, r)
+	}))
+	defer evil.Close()
+	var unknown map[string]any
+	m, _ := json.Marshal(bc.Chain()[0])
+	json.Unmarshal(m, &unknown)
+	unknown["nonce"] = -1
+	announcement := map[string]any{"block": unknown, "height": 5, "origin": evil.URL}
+	if code := signed(t, registered, "PUT", ts.URL+"/blocks", announcement); code != http.StatusForbidden {
+		t.Fatalf("PUT /blocks signed by a registered node before its handshake: status %d", code)
+	}
+
+	// Only nodes listed in PEER_KEYS and on our network can handshake, at
+	// a URL that answers with their key.
+	var node block.NodeInfo
+	get(t, ts.URL+"/node", &node)
+	var nodeKey atomic.Value
+	nodeKey.Store(registered.PublicKey())
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(block.NodeInfo{PublicKey: nodeKey.Load().(string), Network: node.Network})
+	}))
+	defer peer.Close()
+	self, otherChain := peer.URL, "other-chain"
+	unreachable := "http://127.0.0.1:1"
+	if code := signed(t, registered, "POST", ts.URL+"/peers", &block.PeerRequest{URL: &unreachable, ChainID: &node.ChainID, GenesisHash: &node.GenesisHash}); code != http.StatusForbidden {
+		t.Fatalf("handshake from a URL that does not answer: status %d", code)
+	}
+	nodeKey.Store(stranger.PublicKey())
+	if code := signed(t, registered, "POST", ts.URL+"/peers", &block.PeerRequest{URL: &self, ChainID: &node.ChainID, GenesisHash: &node.GenesisHash}); code != http.StatusForbidden {
+		t.Fatalf("handshake from a URL with another node key: status %d", code)
+	}
+	nodeKey.Store(registered.PublicKey())
+	if code := signed(t, stranger, "POST", ts.URL+"/peers", &block.PeerRequest{URL: &self, ChainID: &node.ChainID, GenesisHash: &node.GenesisHash}); code != http.StatusUnauthorized {
+		t.Fatalf("handshake of an unregistered node: status %d", code)
+	}
+	if code := signed(t, registered, "POST", ts.URL+"/peers", &block.PeerRequest{URL: &self, ChainID: &otherChain, GenesisHash: &node.GenesisHash}); code != http.StatusForbidden {
+		t.Fatalf("handshake of a node on another chain: status %d", code)
+	}
+	hr := newRequest("POST", ts.URL+"/peers", &block.PeerRequest{URL: &self, ChainID: &node.ChainID, GenesisHash: &node.GenesisHash})
+	registered.SignRequest(hr)
+	resp, err := http.DefaultClient.Do(hr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var handshake block.HandshakeResponse
+	json.NewDecoder(resp.Body).Decode(&handshake)
+	if resp.StatusCode != http.StatusOK || handshake.PublicKey != node.PublicKey || node.PublicKey != bc.Peers().Key().PublicKey() || handshake.Network != node.Network {
+		t.Fatalf("handshake of a registered node: status %d, key %s", resp.StatusCode, handshake.PublicKey)
+	}
+	if peers := bc.Peers().PeerInfos(); len(peers) != 1 || peers[0].PublicKey != registered.PublicKey() {
+		t.Fatalf("peers after the handshake: %+v", peers)
+	}
+	if code := signed(t, registered, "PUT", ts.URL+"/blocks", announcement); code != http.StatusOK {
+		t.Fatalf("PUT /blocks signed by a peer: status %d", code)
+	}
+	if n := fetched.Load(); n != 0 {
+		t.Fatalf("%d requests to the origin named in the announcement", n)
+	}
+}
+
+// TestStopMining checks that no block is mined after StopMining returns.
+func TestStopMining(t *testing.T) {
+	t.Setenv("MINING_TIMER", "10ms")
+	bcs, ts := newTestServer(t)
+	bc := bcs.GetBlockchain()
+
+	admin(t, "GET", ts.URL+"/mine/start")
+	admin(t, "GET", ts.URL+"/mine/stop")
+	height := len(bc.Chain())
+
+	var resp struct {
+		Mining bool `json:"mining"`
+	}
+	m, _ := bc.MarshalJSON()
+	json.Unmarshal(m, &resp)
+	if resp.Mining {
+		t.Fatal("chain still reports mining after stop")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := len(bc.Chain()); got != height {
+		t.Fatalf("chain grew from %d to %d blocks after stop", height, got)
+	}
+}
+
+func TestAdminCommand(t *testing.T) {
+	bcs, ts := newTestServer(t)
+	bc := bcs.GetBlockchain()
+	height := len(bc.Chain())
+
+	if err := runAdmin([]string{"-node", ts.URL, "-token", "wrong", "mine"}); err == nil {
+		t.Fatal("admin command with a wrong token succeeded")
+	}
+	if err := runAdmin([]string{"-node", ts.URL, "-token", adminToken, "unknown"}); err == nil {
+		t.Fatal("unknown admin command succeeded")
+	}
+	if err := runAdmin([]string{"-node", ts.URL, "-token", adminToken, "mine"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(bc.Chain()); got != height+1 {
+		t.Fatalf("chain has %d blocks after mine, want %d", got, height+1)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("MINER_PASSPHRASE", minerPassphrase)
+	t.Setenv("MINING_WORKERS", "2")
+	t.Setenv("HOST", "http://Node.example")
+	config, err := utils.LoadConfig([]string{"-mining-workers", "5", "-port", "5003"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.MINING_WORKERS != 5 || config.PORT != 5003 {
+		t.Fatalf("flags did not override the environment: workers %d, port %d", config.MINING_WORKERS, config.PORT)
+	}
+	if got := config.AdvertisedURL(); got != "http://node.example:5003" {
+		t.Fatalf("advertised URL %s", got)
+	}
+
+	t.Setenv("MINING_REWARD", "0")
+	t.Setenv("NEIGHBORS", "http://localhost:5001,localhost:5002")
+	t.Setenv("MINER_PASSPHRASE", "")
+	_, err = utils.LoadConfig([]string{"-mining-workers", "-1"})
+	for _, want := range []string{"MINING_WORKERS", "MINING_REWARD", `"localhost:5002"`, "export MINER_PASSPHRASE="} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("config error %v does not mention %s", err, want)
+		}
+	}
+
+	if _, err := utils.LoadConfig([]string{"-config", filepath.Join(t.TempDir(), "missing.env")}); err == nil {
+		t.Fatal("loaded a missing config file")
+	}
+}
+
+func TestDevnet(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	d, err := StartDevnet(DevnetOptions{
+		Nodes:      3,
+		Dir:        t.TempDir(),
+		Wallets:    2,
+		Allocation: 100 * utils.Coin,
+		AdminToken: adminToken,
+		Difficulty: 1,
+		NodeArgs:   []string{"-mining-workers", "2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	genesis := map[string]bool{}
+	for i := range d.Nodes {
+		var b block.BlockResponse
+		get(t, d.Nodes[i].Direct+"/blocks/0", &b)
+		genesis[fmt.Sprintf("%x", b.Block.Hash())] = true
+		balance, err := d.Client(i).Balance(d.Wallets[1].BlockchainAddress())
+		if err != nil || balance != 100*utils.Coin {
+			t.Fatalf("node %d: pre-funded balance %s, %v", i, balance, err)
+		}
+	}
+	if len(genesis) != 1 {
+		t.Fatalf("nodes built %d different genesis blocks", len(genesis))
+	}
+
+	recipient := wallet.NewWallet()
+	if _, err := d.Client(0).Send(d.Wallets[0], recipient.BlockchainAddress(), utils.Coin); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Mine(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.WaitConverged(); err != nil {
+		t.Fatal(err)
+	}
+	if balance, _ := d.Client(2).Balance(recipient.BlockchainAddress()); balance != utils.Coin {
+		t.Fatalf("payment from a pre-funded wallet: balance %s", balance)
+	}
+
+	for _, scenario := range []string{"competing-miners", "rejoin"} {
+		if err := devnetScenarios[scenario](d); err != nil {
+			t.Fatalf("scenario %s: %s", scenario, err)
+		}
+	}
+}
+
+func TestGenesis(t *testing.T) {
+	funded := wallet.NewWallet()
+	genesis := &block.Genesis{
+		Timestamp:   time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		ChainID:     "genesis-test",
+		Difficulty:  2,
+		Allocations: map[string]utils.Amount{funded.BlockchainAddress(): 50 * utils.Coin},
+	}
+	genesisFile := filepath.Join(t.TempDir(), "genesis.json")
+	if err := genesis.Save(genesisFile); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GENESIS_FILE", genesisFile)
+	t.Setenv("CHAIN_ID", "genesis-test")
+	bcs, ts := newTestServer(t)
+	bc := bcs.GetBlockchain()
+
+	var stats block.ChainStats
+	get(t, ts.URL+"/chain/stats", &stats)
+	first := bc.Chain()[0]
+	if stats.ChainID != "genesis-test" || bc.ChainID() != "genesis-test" || stats.GenesisHash != fmt.Sprintf("%x", first.Hash()) || first.Difficulty() != 2 {
+		t.Fatalf("chain %s from genesis %s at difficulty %d", stats.ChainID, stats.GenesisHash, first.Difficulty())
+	}
+	if balance := bc.CalculateTotalAmount(funded.BlockchainAddress()); balance != 50*utils.Coin {
+		t.Fatalf("allocated balance %s", balance)
+	}
+
+	// Every node started from the file builds the same genesis block, and
+	// only chains starting from it are valid.
+	other, _ := newTestServer(t)
+	if other.GetBlockchain().Chain()[0].Hash() != first.Hash() {
+		t.Fatal("two nodes built different genesis blocks from one file")
+	}
+	t.Setenv("GENESIS_FILE", "")
+	foreign, _ := newTestServer(t)
+	if bc.ValidChain(foreign.GetBlockchain().Chain()) || !bc.ValidChain(bc.Chain()) {
+		t.Fatal("ValidChain does not check the genesis block")
+	}
+
+	// A stored chain from another genesis stops the node from starting.
+	config, err := utils.LoadConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.DATA_DIR = t.TempDir()
+	stored, err := block.NewBlockchain(funded.BlockchainAddress(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored.Close()
+	config.GENESIS_FILE = genesisFile
+	if _, err := block.NewBlockchain(funded.BlockchainAddress(), config); err == nil || !strings.Contains(err.Error(), "genesis") {
+		t.Fatalf("started on a stored chain from another genesis: %v", err)
+	}
+
+	// So does a genesis for another chain than CHAIN_ID, or one without a
+	// difficulty.
+	config.DATA_DIR = t.TempDir()
+	config.CHAIN_ID = "other-chain"
+	if _, err := block.NewBlockchain(funded.BlockchainAddress(), config); err == nil || !strings.Contains(err.Error(), "CHAIN_ID") {
+		t.Fatalf("started with a genesis for another chain: %v", err)
+	}
+	genesis.Difficulty = 0
+	if err := genesis.Save(genesisFile); err != nil {
+		t.Fatal(err)
+	}
+	config.CHAIN_ID = "genesis-test"
+	if _, err := block.NewBlockchain(funded.BlockchainAddress(), config); err == nil || !strings.Contains(err.Error(), "difficulty") {
+		t.Fatalf("started with a genesis without a difficulty: %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+// Package canonical is the one encoding of a transaction that wallets sign,
+// nodes verify and every transaction ID is hashed from. It is a fixed
+// binary layout, so it does not depend on JSON field order or number
+// formatting, and wallets in other languages can rebuild it byte for byte.
+package canonical
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+
+	"github.com/EmilioCliff/learn-go/blockchain/utils"
+)
+
+// Version is the first byte of every encoding, bumped whenever the layout
+// changes.
+const Version byte = 3
+
+// Transaction holds the signed fields of a transaction. The chain ID makes
+// a signature worthless on any other chain and the nonce on any other
+// position in the sender's history. The sender pays Value plus Fee, the fee
+// going to the miner of the block. A non zero UnlockHeight or UnlockTime
+// keeps the value from being spent by the recipient before that block
+// height or block time.
+type Transaction struct {
+	ChainID      string
+	Sender       string
+	Recipient    string
+	Value        utils.Amount
+	Fee          utils.Amount
+	Nonce        uint64
+	Timestamp    int64
+	UnlockHeight uint64
+	UnlockTime   int64
+}
+
+// Encode lays the fields out as
+//
+//	version     1 byte
+//	chain ID    4 byte length, then the UTF-8 bytes
+//	sender      4 byte length, then the UTF-8 bytes
+//	recipient   4 byte length, then the UTF-8 bytes
+//	value       8 bytes, the amount in base units
+//	fee         8 bytes, in base units
+//	nonce       8 bytes
+//	timestamp   8 bytes, unix seconds
+//	unlock      8 bytes height, then 8 bytes unix seconds
+//
+// with every integer big endian.
+func (t *Transaction) Encode() []byte {
+	b := make([]byte, 0, 1+3*4+len(t.ChainID)+len(t.Sender)+len(t.Recipient)+6*8)
+	b = append(b, Version)
+	for _, s := range []string{t.ChainID, t.Sender, t.Recipient} {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+		b = append(b, s...)
+	}
+	b = binary.BigEndian.AppendUint64(b, uint64(t.Value))
+	b = binary.BigEndian.AppendUint64(b, uint64(t.Fee))
+	b = binary.BigEndian.AppendUint64(b, t.Nonce)
+	b = binary.BigEndian.AppendUint64(b, uint64(t.Timestamp))
+	b = binary.BigEndian.AppendUint64(b, t.UnlockHeight)
+	b = binary.BigEndian.AppendUint64(b, uint64(t.UnlockTime))
+	return b
+}
+
+// Hash is the SHA-256 of the encoding, the digest that is signed and the
+// transaction ID.
+func (t *Transaction) Hash() [32]byte {
+	return sha256.Sum256(t.Encode())
+}
+
+// Sign signs the hash of t with privateKey.
+func (t *Transaction) Sign(privateKey *ecdsa.PrivateKey) (*utils.Signature, error) {
+	h := t.Hash()
+	r, s, err := ecdsa.Sign(rand.Reader, privateKey, h[:])
+	if err != nil {
+		return nil, err
+	}
+	return &utils.Signature{R: r, S: s}, nil
+}
+
+// Verify reports whether s is a signature of t by publicKey.
+func (t *Transaction) Verify(publicKey *ecdsa.PublicKey, s *utils.Signature) bool {
+	if publicKey == nil || s == nil || s.R == nil || s.S == nil {
+		return false
+	}
+	h := t.Hash()
+	return ecdsa.Verify(publicKey, h[:], s.R, s.S)
+}
@@ -0,0 +1,160 @@
+package canonical_test
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/EmilioCliff/learn-go/blockchain/block"
+	"github.com/EmilioCliff/learn-go/blockchain/canonical"
+	"github.com/EmilioCliff/learn-go/blockchain/utils"
+	"github.com/EmilioCliff/learn-go/blockchain/wallet"
+)
+
+// The sender key is the P-256 key of RFC 6979 A.2.5.
+const (
+	senderKey       = "c9afa9d845ba75166b5c215767b1d6934e50c3db36e89b127b8a622b120f6721"
+	senderPublicKey = "60fed4ba255a9d31c961eb74c6356d68c049b8923b61fa6ce669622e60f29fb6" +
+		"7903fe1008b8bc99a41ae9e95628bc64f2f1b20c2d7e9f5177a3c294d4462299"
+	senderAddress    = "12mqjrsBKVPLddcfpahHwA1zQ8zaqDY4sL"
+	recipientAddress = "1MgxAxR3rv8YvSUzPwkPCB6pmNER9AqD4C"
+)
+
+var vectors = []struct {
+	name     string
+	tx       canonical.Transaction
+	encoding string
+	hash     string
+}{
+	{
+		name: "transfer",
+		tx: canonical.Transaction{
+			ChainID:   "learn-go-devnet",
+			Sender:    senderAddress,
+			Recipient: recipientAddress,
+			Value:     150000000,
+			Fee:       10000,
+			Nonce:     7,
+			Timestamp: 1700000000,
+		},
+		encoding: "03" +
+			"0000000f" + "6c6561726e2d676f2d6465766e6574" +
+			"00000022" + "31326d716a7273424b56504c64646366706168487741317a51387a6171445934734c" +
+			"00000022" + "314d677841785233727638597653557a50776b50434236706d4e4552394171443443" +
+			"0000000008f0d180" + "0000000000002710" + "0000000000000007" + "000000006553f100" +
+			"0000000000000000" + "0000000000000000",
+		hash: "de1220d50ff6b15b6b95fad148faadc28fd3450b1171e4985896054dd533bcdd",
+	},
+	{
+		name: "mining reward",
+		tx: canonical.Transaction{
+			ChainID:   "learn-go-devnet",
+			Sender:    "THE_BLOCKCHAIN",
+			Recipient: senderAddress,
+			Value:     utils.Coin,
+			Nonce:     3,
+			Timestamp: 1700000123,
+		},
+		encoding: "03" +
+			"0000000f" + "6c6561726e2d676f2d6465766e6574" +
+			"0000000e" + "5448455f424c4f434b434841494e" +
+			"00000022" + "31326d716a7273424b56504c64646366706168487741317a51387a6171445934734c" +
+			"0000000005f5e100" + "0000000000000000" + "0000000000000003" + "000000006553f17b" +
+			"0000000000000000" + "0000000000000000",
+		hash: "70f754ccbaaaff79f06e783709e154babeaecb477763a1c5ac7a20233d42b194",
+	},
+	{
+		name: "time locked transfer",
+		tx: canonical.Transaction{
+			ChainID:      "learn-go-devnet",
+			Sender:       senderAddress,
+			Recipient:    recipientAddress,
+			Value:        250000000,
+			Nonce:        8,
+			Timestamp:    1700000200,
+			UnlockHeight: 1000,
+			UnlockTime:   1710000000,
+		},
+		encoding: "03" +
+			"0000000f" + "6c6561726e2d676f2d6465766e6574" +
+			"00000022" + "31326d716a7273424b56504c64646366706168487741317a51387a6171445934734c" +
+			"00000022" + "314d677841785233727638597653557a50776b50434236706d4e4552394171443443" +
+			"000000000ee6b280" + "0000000000000000" + "0000000000000008" + "000000006553f1c8" +
+			"00000000000003e8" + "0000000065ec8780",
+		hash: "f1f27826b3820d4905fba54fa4fdad43cdcac414316806ce6ccc2bae8271b4e1",
+	},
+}
+
+func TestEncodingVectors(t *testing.T) {
+	for _, v := range vectors {
+		if got := hex.EncodeToString(v.tx.Encode()); got != v.encoding {
+			t.Errorf("%s: encoding\n got %s\nwant %s", v.name, got, v.encoding)
+		}
+		if got := fmt.Sprintf("%x", v.tx.Hash()); got != v.hash {
+			t.Errorf("%s: hash %s, want %s", v.name, got, v.hash)
+		}
+	}
+}
+
+// The signatures of the transfer vector were made once by the Go wallet and
+// once by the browser wallet (app/src/lib/signing.ts), a node must accept
+// both.
+func TestSignatureVectors(t *testing.T) {
+	signatures := map[string]string{
+		"go wallet": "05e64de0a99ae38eb8ec37194884a808aa0774ccd9be55e2a691e27f89f49d19" +
+			"024861f71ae61795d255315f5cd7875e4c7ad3b766d7181d2ec72f45a9deaaf8",
+		"web wallet": "577a71b7b34f381a81feed2ec48b25249a13e23a70d0e9b2c44c90cefb66645d" +
+			"4a0442252ebff2be8556e85cf7cbb6a9e482722f99896ed74dd1d8212673d82c",
+	}
+	publicKey := utils.PublicKeyFromString(senderPublicKey)
+	for name, signature := range signatures {
+		// The request exactly as the browser wallet posts it.
+		body := fmt.Sprintf(`{"chain_id":"learn-go-devnet","sender_blockchain_address":%q,"recipient_blockchain_address":%q,"value":"1.5","fee":"0.0001","nonce":7,"timestamp":1700000000,"sender_public_key":%q,"signature":%q}`,
+			senderAddress, recipientAddress, senderPublicKey, signature)
+		var tr block.TransactionRequest
+		if err := json.Unmarshal([]byte(body), &tr); err != nil || !tr.Validate() {
+			t.Fatalf("%s: invalid request %v", name, err)
+		}
+		tx := tr.Transaction()
+		if tx.ID() != vectors[0].hash {
+			t.Errorf("%s: node computed ID %s, want %s", name, tx.ID(), vectors[0].hash)
+		}
+		if !tx.VerifySignature(publicKey, utils.SignatureFromString(signature)) {
+			t.Errorf("%s: node rejects the signature", name)
+		}
+
+		other := vectors[0].tx
+		other.ChainID = "other-chain"
+		if other.Verify(publicKey, utils.SignatureFromString(signature)) {
+			t.Errorf("%s: signature is valid on another chain", name)
+		}
+	}
+}
+
+// TestWalletToNode signs with the wallet and verifies on the node side
+// after a trip through JSON, like between two nodes.
+func TestWalletToNode(t *testing.T) {
+	privateKey, err := wallet.ParsePrivateKey(senderKey, wallet.KeyFormatHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := wallet.NewWalletFromPrivateKey(privateKey)
+	if w.BlockchainAddress() != senderAddress {
+		t.Fatalf("address %s, want %s", w.BlockchainAddress(), senderAddress)
+	}
+
+	wt := w.NewTransaction("learn-go-devnet", recipientAddress, utils.Coin/3, 42)
+	m, _ := json.Marshal(wt.Sign())
+	var tr block.TransactionRequest
+	if err := json.Unmarshal(m, &tr); err != nil || !tr.Validate() {
+		t.Fatalf("invalid request %s: %v", m, err)
+	}
+	tx := tr.Transaction()
+	if want := fmt.Sprintf("%x", wt.Canonical().Hash()); tx.ID() != want {
+		t.Fatalf("node computed ID %s, wallet %s", tx.ID(), want)
+	}
+	if !tx.VerifySignature(w.PublicKey(), utils.SignatureFromString(*tr.Signature)) {
+		t.Fatal("node rejects the wallet signature")
+	}
+}
@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// AmountDecimals is how many decimal places one coin is split into.
+const AmountDecimals = 8
+
+// Coin is one whole coin expressed in the smallest unit.
+const Coin Amount = 100_000_000
+
+var (
+	ErrAmountOverflow = errors.New("amount overflows")
+	ErrInvalidAmount  = errors.New("invalid amount")
+)
+
+// Amount is an exact currency value counted in the smallest unit
+// (1e-8 of a coin). It is encoded in JSON as a decimal string like "1.5" so
+// no node ever goes through floating point when reading it.
+type Amount int64
+
+// ParseAmount parses a decimal string such as "12", "0.5" or "-3.00000001".
+func ParseAmount(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, ErrInvalidAmount
+	}
+	negative := false
+	if s[0] == '-' || s[0] == '+' {
+		negative = s[0] == '-'
+		s = s[1:]
+	}
+
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" {
+		return 0, ErrInvalidAmount
+	}
+	if len(frac) > AmountDecimals {
+		return 0, fmt.Errorf("%w: more than %d decimal places", ErrInvalidAmount, AmountDecimals)
+	}
+	if !isDigits(whole) || !isDigits(frac) {
+		return 0, ErrInvalidAmount
+	}
+
+	var w, f uint64
+	var err error
+	if whole != "" {
+		if w, err = strconv.ParseUint(whole, 10, 64); err != nil {
+			return 0, ErrAmountOverflow
+		}
+	}
+	if frac != "" {
+		frac += strings.Repeat("0", AmountDecimals-len(frac))
+		f, _ = strconv.ParseUint(frac, 10, 64)
+	}
+
+	if w > uint64(math.MaxInt64/int64(Coin)) {
+		return 0, ErrAmountOverflow
+	}
+	units := w*uint64(Coin) + f
+	if units > math.MaxInt64 {
+		return 0, ErrAmountOverflow
+	}
+	if negative {
+		return -Amount(units), nil
+	}
+	return Amount(units), nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Add returns a + b or ErrAmountOverflow.
+func (a Amount) Add(b Amount) (Amount, error) {
+	c := a + b
+	if (b > 0 && c < a) || (b < 0 && c > a) {
+		return 0, ErrAmountOverflow
+	}
+	return c, nil
+}
+
+// Sub returns a - b or ErrAmountOverflow.
+func (a Amount) Sub(b Amount) (Amount, error) {
+	c := a - b
+	if (b > 0 && c > a) || (b < 0 && c < a) {
+		return 0, ErrAmountOverflow
+	}
+	return c, nil
+}
+
+// Mul returns a * n or ErrAmountOverflow.
+func (a Amount) Mul(n int64) (Amount, error) {
+	if a == 0 || n == 0 {
+		return 0, nil
+	}
+	c := a * Amount(n)
+	if c/Amount(n) != a || (a == -1 && n == math.MinInt64) || (n == -1 && a == math.MinInt64) {
+		return 0, ErrAmountOverflow
+	}
+	return c, nil
+}
+
+// String formats the amount as a decimal without trailing zeros.
+func (a Amount) String() string {
+	sign := ""
+	u := uint64(a)
+	if a < 0 {
+		sign = "-"
+		u = uint64(-(a + 1)) + 1
+	}
+	whole := u / uint64(Coin)
+	frac := u % uint64(Coin)
+	if frac == 0 {
+		return fmt.Sprintf("%s%d", sign, whole)
+	}
+	fs := strings.TrimRight(fmt.Sprintf("%0*d", AmountDecimals, frac), "0")
+	return fmt.Sprintf("%s%d.%s", sign, whole, fs)
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(a.String())), nil
+}
+
+// UnmarshalJSON accepts the decimal string form as well as a bare JSON
+// number, which is parsed from its literal text and never through a float.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	v, err := ParseAmount(s)
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
+
+func (a Amount) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *Amount) UnmarshalText(text []byte) error {
+	v, err := ParseAmount(string(text))
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
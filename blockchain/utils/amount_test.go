@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want Amount
+		err  error
+	}{
+		{"12", 12 * Coin, nil},
+		{"0.5", Coin / 2, nil},
+		{".5", Coin / 2, nil},
+		{"5.", 5 * Coin, nil},
+		{"+1", Coin, nil},
+		{"-3.00000001", -3*Coin - 1, nil},
+		{" 0.00000001 ", 1, nil},
+		{"92233720368.54775807", math.MaxInt64, nil},
+		{"92233720368.54775808", 0, ErrAmountOverflow},
+		{"92233720369", 0, ErrAmountOverflow},
+		{"99999999999999999999", 0, ErrAmountOverflow},
+		{"0.000000001", 0, ErrInvalidAmount},
+		{"", 0, ErrInvalidAmount},
+		{".", 0, ErrInvalidAmount},
+		{"-", 0, ErrInvalidAmount},
+		{"1e8", 0, ErrInvalidAmount},
+		{"1.2.3", 0, ErrInvalidAmount},
+		{"--1", 0, ErrInvalidAmount},
+	} {
+		got, err := ParseAmount(tt.in)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("ParseAmount(%q) = %s, %v; want %s, %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestAmountString(t *testing.T) {
+	for _, a := range []Amount{0, 1, Coin, Coin / 2, -Coin - 1, math.MaxInt64, math.MinInt64 + 1} {
+		parsed, err := ParseAmount(a.String())
+		if err != nil || parsed != a {
+			t.Errorf("ParseAmount(%q) = %s, %v; want %d", a.String(), parsed, err, int64(a))
+		}
+	}
+	if s := Amount(math.MinInt64).String(); s != "-92233720368.54775808" {
+		t.Errorf("MinInt64 formats as %s", s)
+	}
+}
+
+func TestAmountJSON(t *testing.T) {
+	var v struct {
+		Quoted Amount `json:"quoted"`
+		Bare   Amount `json:"bare"`
+	}
+	if err := json.Unmarshal([]byte(`{"quoted":"1.1","bare":0.29}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	// 0.29 is not exact as a float64, the literal text is parsed instead.
+	if v.Quoted != 110_000_000 || v.Bare != 29_000_000 {
+		t.Fatalf("decoded %d and %d", v.Quoted, v.Bare)
+	}
+	if err := json.Unmarshal([]byte(`{"bare":1e30}`), &v); err == nil {
+		t.Fatal("exponent notation was accepted")
+	}
+	m, _ := json.Marshal(v.Quoted)
+	if string(m) != `"1.1"` {
+		t.Fatalf("encoded %s", m)
+	}
+}
+
+func TestAmountArithmeticOverflow(t *testing.T) {
+	if _, err := Amount(math.MaxInt64).Add(1); !errors.Is(err, ErrAmountOverflow) {
+		t.Errorf("MaxInt64 + 1: %v", err)
+	}
+	if _, err := Amount(math.MinInt64).Add(-1); !errors.Is(err, ErrAmountOverflow) {
+		t.Errorf("MinInt64 + -1: %v", err)
+	}
+	if _, err := Amount(math.MinInt64).Sub(1); !errors.Is(err, ErrAmountOverflow) {
+		t.Errorf("MinInt64 - 1: %v", err)
+	}
+	if _, err := Amount(0).Sub(math.MinInt64); !errors.Is(err, ErrAmountOverflow) {
+		t.Errorf("0 - MinInt64: %v", err)
+	}
+	if _, err := Amount(math.MaxInt64/2 + 1).Mul(2); !errors.Is(err, ErrAmountOverflow) {
+		t.Errorf("(MaxInt64/2 + 1) * 2: %v", err)
+	}
+	if _, err := Amount(math.MinInt64).Mul(-1); !errors.Is(err, ErrAmountOverflow) {
+		t.Errorf("MinInt64 * -1: %v", err)
+	}
+	if c, err := Amount(math.MaxInt64).Sub(math.MaxInt64); err != nil || c != 0 {
+		t.Errorf("MaxInt64 - MaxInt64 = %s, %v", c, err)
+	}
+	if c, err := Coin.Mul(-3); err != nil || c != -3*Coin {
+		t.Errorf("Coin * -3 = %s, %v", c, err)
+	}
+}
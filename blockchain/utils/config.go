@@ -1,52 +1,234 @@
 package utils
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
 	"time"
 
+	"github.com/go-viper/mapstructure/v2"
 	"github.com/spf13/viper"
 )
 
+// Config is the settings of a node, see LoadConfig for where they come
+// from.
 type Config struct {
-	PORT              string        `mapstructure:"PORT"`
+	PORT              uint16        `mapstructure:"PORT"`
 	ENVIRONMENT       string        `mapstructure:"ENVIRONMENT"`
+	CHAIN_ID          string        `mapstructure:"CHAIN_ID"`
 	NEIGHBORS         []string      `mapstructure:"NEIGHBORS"`
-	MINING_DIFFICULTY int           `mapstructure:"MINING_DIFFICULTY"`
 	MINING_SENDER     string        `mapstructure:"MINING_SENDER"`
-	MINING_REWARD     float32       `mapstructure:"MINING_REWARD"`
+	MINING_REWARD     Amount        `mapstructure:"MINING_REWARD"`
 	MINING_TIMER      time.Duration `mapstructure:"MINING_TIMER"`
+	MINING_WORKERS    int           `mapstructure:"MINING_WORKERS"`
+	RETARGET_INTERVAL int           `mapstructure:"RETARGET_INTERVAL"`
+	TARGET_BLOCK_TIME time.Duration `mapstructure:"TARGET_BLOCK_TIME"`
+	MAX_BLOCK_SIZE    int           `mapstructure:"MAX_BLOCK_SIZE"`
 	HOST              string        `mapstructure:"HOST"`
+	ADVERTISED_URL    string        `mapstructure:"ADVERTISED_URL"`
+	DATA_DIR          string        `mapstructure:"DATA_DIR"`
+	GENESIS_FILE      string        `mapstructure:"GENESIS_FILE"`
+
+	MEMPOOL_SIZE           int           `mapstructure:"MEMPOOL_SIZE"`
+	MEMPOOL_MAX_BYTES      int           `mapstructure:"MEMPOOL_MAX_BYTES"`
+	MEMPOOL_MAX_PER_SENDER int           `mapstructure:"MEMPOOL_MAX_PER_SENDER"`
+	MEMPOOL_TTL            time.Duration `mapstructure:"MEMPOOL_TTL"`
+	MEMPOOL_RBF_BUMP       int           `mapstructure:"MEMPOOL_RBF_BUMP"`
+
+	MAX_PEERS            int           `mapstructure:"MAX_PEERS"`
+	PEER_FANOUT          int           `mapstructure:"PEER_FANOUT"`
+	PEER_TIMEOUT         time.Duration `mapstructure:"PEER_TIMEOUT"`
+	PEER_HEALTH_INTERVAL time.Duration `mapstructure:"PEER_HEALTH_INTERVAL"`
+	PEER_KEYS            []string      `mapstructure:"PEER_KEYS"`
+	NODE_KEY_FILE        string        `mapstructure:"NODE_KEY_FILE"`
+	ADMIN_TOKEN          string        `mapstructure:"ADMIN_TOKEN"`
+
+	KEYSTORE_DIR        string `mapstructure:"KEYSTORE_DIR"`
+	KEYSTORE_ITERATIONS int    `mapstructure:"KEYSTORE_ITERATIONS"`
+	MINER_PASSPHRASE    string `mapstructure:"MINER_PASSPHRASE"`
+	// WALLET_RATE_LIMIT is how many wallets a client may create a minute,
+	// 0 for no limit.
+	WALLET_RATE_LIMIT int `mapstructure:"WALLET_RATE_LIMIT"`
 }
 
-func LoanConfig() (Config, error) {
-	viper.AddConfigPath(".")
-	viper.SetConfigName("config")
-	viper.SetConfigType("env")
-	setDefaults()
+// LoadConfig layers the settings of a node, each layer overriding the one
+// before: the defaults, the config file, the environment and the flags in
+// args, one per key (-mining-reward 2 sets MINING_REWARD). The
+// config file is -config, or CONFIG_FILE, and config.env in the working
+// directory when neither is set and it exists. The result is validated.
+func LoadConfig(args []string) (Config, error) {
+	v := viper.New()
+	setDefaults(v)
+	v.AutomaticEnv()
 
-	viper.AutomaticEnv()
+	fs := flag.NewFlagSet("blockchain_server", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "config file in env format")
+	keys := map[string]string{}
+	for _, key := range configKeys() {
+		name := strings.ToLower(strings.ReplaceAll(key, "_", "-"))
+		keys[name] = key
+		fs.String(name, "", "overrides "+key)
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if key, ok := keys[f.Name]; ok {
+			v.Set(key, f.Value.String())
+		}
+	})
 
-	if err := viper.ReadInConfig(); err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
+	v.SetConfigType("env")
+	if *configFile != "" {
+		v.SetConfigFile(*configFile)
+		if err := v.ReadInConfig(); err != nil {
+			return Config{}, fmt.Errorf("failed to read config %s: %w", *configFile, err)
+		}
+	} else {
+		v.AddConfigPath(".")
+		v.SetConfigName("config")
+		if err := v.ReadInConfig(); err != nil {
+			if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+				return Config{}, fmt.Errorf("failed to read config: %w", err)
+			}
 			log.Println("Config file not found, using environment variables")
-		} else {
-			return Config{}, fmt.Errorf("failed to read config: %s", err.Error())
 		}
 	}
 
 	var config Config
+	err := v.Unmarshal(&config, viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+		mapstructure.TextUnmarshallerHookFunc(),
+	)))
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to decode config: %w", err)
+	}
+	return config, config.Validate()
+}
+
+// configKeys are the mapstructure names of the Config fields.
+func configKeys() []string {
+	t := reflect.TypeFor[Config]()
+	keys := make([]string, 0, t.NumField())
+	for i := range t.NumField() {
+		keys = append(keys, t.Field(i).Tag.Get("mapstructure"))
+	}
+	return keys
+}
+
+// Validate returns every setting a node cannot start with, nil when there
+// is none.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, a ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, a...))
+		}
+	}
 
-	return config, viper.Unmarshal(&config)
+	check(c.CHAIN_ID != "", "CHAIN_ID is empty")
+	check(NormalizeURL(c.HOST) != "", "HOST %q is not an http(s) URL", c.HOST)
+	check(c.ADVERTISED_URL == "" || NormalizeURL(c.ADVERTISED_URL) != "", "ADVERTISED_URL %q is not an http(s) URL", c.ADVERTISED_URL)
+	for _, n := range c.NEIGHBORS {
+		check(NormalizeURL(n) != "", "NEIGHBORS entry %q is not an http(s) URL", n)
+	}
+	check(c.MINING_SENDER != "", "MINING_SENDER is empty")
+	check(c.MINING_REWARD > 0, "MINING_REWARD %s is not positive", c.MINING_REWARD)
+	check(c.MINING_TIMER > 0, "MINING_TIMER %s is not positive", c.MINING_TIMER)
+	check(c.MINING_WORKERS >= 0, "MINING_WORKERS %d is negative", c.MINING_WORKERS)
+	check(c.RETARGET_INTERVAL >= 0, "RETARGET_INTERVAL %d is negative", c.RETARGET_INTERVAL)
+	check(c.TARGET_BLOCK_TIME > 0, "TARGET_BLOCK_TIME %s is not positive", c.TARGET_BLOCK_TIME)
+	check(c.MAX_BLOCK_SIZE > 0, "MAX_BLOCK_SIZE %d is not positive", c.MAX_BLOCK_SIZE)
+
+	check(c.MEMPOOL_SIZE >= 0, "MEMPOOL_SIZE %d is negative", c.MEMPOOL_SIZE)
+	check(c.MEMPOOL_MAX_BYTES >= 0, "MEMPOOL_MAX_BYTES %d is negative", c.MEMPOOL_MAX_BYTES)
+	check(c.MEMPOOL_MAX_PER_SENDER >= 0, "MEMPOOL_MAX_PER_SENDER %d is negative", c.MEMPOOL_MAX_PER_SENDER)
+	check(c.MEMPOOL_TTL >= 0, "MEMPOOL_TTL %s is negative", c.MEMPOOL_TTL)
+	check(c.MEMPOOL_RBF_BUMP >= 0, "MEMPOOL_RBF_BUMP %d is negative", c.MEMPOOL_RBF_BUMP)
+
+	check(c.MAX_PEERS >= 0, "MAX_PEERS %d is negative", c.MAX_PEERS)
+	check(c.PEER_FANOUT >= 0, "PEER_FANOUT %d is negative", c.PEER_FANOUT)
+	check(c.PEER_TIMEOUT > 0, "PEER_TIMEOUT %s is not positive", c.PEER_TIMEOUT)
+	check(c.PEER_HEALTH_INTERVAL >= 0, "PEER_HEALTH_INTERVAL %s is negative", c.PEER_HEALTH_INTERVAL)
+	for _, k := range c.PEER_KEYS {
+		check(IsBigIntTupleString(k), "PEER_KEYS entry %q is not a public key", k)
+	}
+	check(c.KEYSTORE_ITERATIONS >= 0, "KEYSTORE_ITERATIONS %d is negative", c.KEYSTORE_ITERATIONS)
+	// config.env ships without one, so every node picks its own.
+	check(c.MINER_PASSPHRASE != "", "MINER_PASSPHRASE is empty: export MINER_PASSPHRASE=<passphrase> to create or unlock the miner's key in %s", c.KEYSTORE_DIR)
+	check(c.WALLET_RATE_LIMIT >= 0, "WALLET_RATE_LIMIT %d is negative", c.WALLET_RATE_LIMIT)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// AdvertisedURL is the URL peers reach this node at: ADVERTISED_URL, or
+// HOST with PORT added when HOST does not carry a port.
+func (c *Config) AdvertisedURL() string {
+	if c.ADVERTISED_URL != "" {
+		return NormalizeURL(c.ADVERTISED_URL)
+	}
+	u, err := url.Parse(c.HOST)
+	if err != nil || u.Host == "" || u.Port() != "" {
+		return NormalizeURL(c.HOST)
+	}
+	u.Host = fmt.Sprintf("%s:%d", u.Hostname(), c.PORT)
+	return NormalizeURL(u.String())
+}
+
+// NormalizeURL returns u with a lower case scheme and host, without a
+// default port or a trailing slash, so one node has one URL. It returns ""
+// when u is not an absolute http(s) URL.
+func NormalizeURL(u string) string {
+	parsed, err := url.Parse(strings.TrimSpace(u))
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return ""
+	}
+	parsed.Host = strings.ToLower(parsed.Host)
+	if port := parsed.Port(); (parsed.Scheme == "http" && port == "80") || (parsed.Scheme == "https" && port == "443") {
+		parsed.Host = parsed.Hostname()
+	}
+	return strings.TrimRight(parsed.String(), "/")
 }
 
-func setDefaults() {
-	viper.SetDefault("PORT", "")
-	viper.SetDefault("ENVIRONMENT", "")
-	viper.SetDefault("NEIGHBORS", []string{})
-	viper.SetDefault("MINING_DIFFICULTY", 3)
-	viper.SetDefault("MINING_SENDER", "THE_BLOCKCHAIN")
-	viper.SetDefault("MINING_REWARD", 1.0)
-	viper.SetDefault("MINING_TIMER", 10*time.Second)
-	viper.SetDefault("HOST", "localhost")
+func setDefaults(v *viper.Viper) {
+	v.SetDefault("PORT", 5000)
+	v.SetDefault("ENVIRONMENT", "")
+	v.SetDefault("CHAIN_ID", "learn-go-devnet")
+	v.SetDefault("NEIGHBORS", []string{})
+	v.SetDefault("MINING_SENDER", "THE_BLOCKCHAIN")
+	v.SetDefault("MINING_REWARD", "1")
+	v.SetDefault("MINING_TIMER", 10*time.Second)
+	v.SetDefault("MINING_WORKERS", 0)
+	v.SetDefault("RETARGET_INTERVAL", 10)
+	v.SetDefault("TARGET_BLOCK_TIME", 10*time.Second)
+	v.SetDefault("MAX_BLOCK_SIZE", 100_000)
+	v.SetDefault("MEMPOOL_SIZE", 5000)
+	v.SetDefault("MEMPOOL_MAX_BYTES", 1_000_000)
+	v.SetDefault("MEMPOOL_MAX_PER_SENDER", 25)
+	v.SetDefault("MEMPOOL_TTL", 3*time.Hour)
+	v.SetDefault("MEMPOOL_RBF_BUMP", 10)
+	v.SetDefault("HOST", "http://localhost")
+	v.SetDefault("ADVERTISED_URL", "")
+	v.SetDefault("DATA_DIR", "data")
+	v.SetDefault("GENESIS_FILE", "")
+	v.SetDefault("MAX_PEERS", 32)
+	v.SetDefault("PEER_FANOUT", 8)
+	v.SetDefault("PEER_TIMEOUT", 5*time.Second)
+	v.SetDefault("PEER_HEALTH_INTERVAL", 30*time.Second)
+	v.SetDefault("PEER_KEYS", []string{})
+	v.SetDefault("NODE_KEY_FILE", "")
+	v.SetDefault("ADMIN_TOKEN", "")
+	v.SetDefault("KEYSTORE_DIR", "keystore")
+	v.SetDefault("KEYSTORE_ITERATIONS", 0)
+	v.SetDefault("MINER_PASSPHRASE", "")
+	v.SetDefault("WALLET_RATE_LIMIT", 10)
 }
@@ -26,6 +26,16 @@ func String2BigIntTuple(s string) (big.Int, big.Int) {
 	return r, t
 }
 
+// IsBigIntTupleString reports whether s is two 32 byte integers in hex, the
+// format of public keys and signatures on the wire.
+func IsBigIntTupleString(s string) bool {
+	if len(s) != 128 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
 func SignatureFromString(s string) *Signature {
 	r, t := String2BigIntTuple(s)
 	return &Signature{&r, &t}
@@ -0,0 +1,135 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/EmilioCliff/learn-go/blockchain/address"
+	"github.com/EmilioCliff/learn-go/blockchain/utils"
+)
+
+// Client talks to a node's public API. Transactions are built and signed
+// locally, the node only ever sees the public key and the signature.
+type Client struct {
+	endpoint string
+	client   *http.Client
+}
+
+// NewClient returns a client for the node at endpoint, for instance
+// http://localhost:5000.
+func NewClient(endpoint string) *Client {
+	return &Client{
+		endpoint: strings.TrimRight(endpoint, "/"),
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Nonce returns the nonce the next transaction from blockchainAddress has
+// to carry.
+func (c *Client) Nonce(blockchainAddress string) (uint64, error) {
+	_, nonce, err := c.next(blockchainAddress)
+	return nonce, err
+}
+
+// next returns the chain ID and the nonce the next transaction from
+// blockchainAddress is signed with.
+func (c *Client) next(blockchainAddress string) (string, uint64, error) {
+	var resp struct {
+		ChainID string `json:"chain_id"`
+		Nonce   uint64 `json:"nonce"`
+	}
+	if err := c.do("GET", fmt.Sprintf("/address/%s/nonce", blockchainAddress), nil, &resp); err != nil {
+		return "", 0, err
+	}
+	return resp.ChainID, resp.Nonce, nil
+}
+
+// Balance returns the balance of blockchainAddress including pending
+// transactions.
+func (c *Client) Balance(blockchainAddress string) (utils.Amount, error) {
+	var resp struct {
+		Amount utils.Amount `json:"amount"`
+	}
+	if err := c.do("GET", fmt.Sprintf("/address/%s/amount", blockchainAddress), nil, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Amount, nil
+}
+
+// Submit posts a signed transaction and returns its ID.
+func (c *Client) Submit(st *SignedTransaction) (string, error) {
+	var resp struct {
+		ID string `json:"id"`
+	}
+	if err := c.do("POST", "/transactions", st, &resp); err != nil {
+		return "", err
+	}
+	return resp.ID, nil
+}
+
+// Send builds a transaction of value from w to recipient with the sender's
+// next nonce, signs it and submits it.
+func (c *Client) Send(w *Wallet, recipient string, value utils.Amount) (string, error) {
+	return c.SendWithFee(w, recipient, value, 0)
+}
+
+// SendWithFee is Send paying fee to the miner.
+func (c *Client) SendWithFee(w *Wallet, recipient string, value, fee utils.Amount) (string, error) {
+	if err := address.Validate(recipient); err != nil {
+		return "", err
+	}
+	chainID, nonce, err := c.next(w.BlockchainAddress())
+	if err != nil {
+		return "", err
+	}
+	return c.Submit(w.NewTransaction(chainID, recipient, value, nonce).WithFee(fee).Sign())
+}
+
+// NewMultisigTransaction builds an unsigned transaction from the address
+// of policy, with the chain ID and nonce the node expects, for the key
+// holders to CoSign.
+func (c *Client) NewMultisigTransaction(policy *address.Multisig, recipient string, value utils.Amount) (*SignedTransaction, error) {
+	if err := address.Validate(recipient); err != nil {
+		return nil, err
+	}
+	chainID, nonce, err := c.next(policy.Address())
+	if err != nil {
+		return nil, err
+	}
+	return NewMultisigTransaction(policy, chainID, recipient, value, nonce), nil
+}
+
+func (c *Client) do(method, path string, body, out any) error {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, c.endpoint+path, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var failed struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&failed) == nil && failed.Error != "" {
+			return errors.New(failed.Error)
+		}
+		return fmt.Errorf("%s %s: status %d", method, path, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
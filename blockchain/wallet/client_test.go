@@ -0,0 +1,92 @@
+package wallet
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/EmilioCliff/learn-go/blockchain/utils"
+)
+
+// testNode answers the nonce and balance of any address and keeps the
+// transactions posted to it.
+type testNode struct {
+	chainID   string
+	nonce     uint64
+	submitted []*SignedTransaction
+}
+
+func (n *testNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/nonce"):
+		json.NewEncoder(w).Encode(map[string]any{"chain_id": n.chainID, "nonce": n.nonce})
+	case r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/amount"):
+		json.NewEncoder(w).Encode(map[string]any{"amount": "1.5"})
+	case r.Method == "POST" && r.URL.Path == "/transactions":
+		var st SignedTransaction
+		if err := json.NewDecoder(r.Body).Decode(&st); err != nil {
+			w.WriteHeader(400)
+			json.NewEncoder(w).Encode(map[string]any{"message": "failed", "error": err.Error()})
+			return
+		}
+		n.submitted = append(n.submitted, &st)
+		json.NewEncoder(w).Encode(map[string]any{"message": "success", "id": "tx-id"})
+	default:
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{"message": "failed", "error": "no route"})
+	}
+}
+
+func newTestNode(t *testing.T) (*testNode, *Client) {
+	t.Helper()
+	n := &testNode{chainID: "client-test", nonce: 4}
+	ts := httptest.NewServer(n)
+	t.Cleanup(ts.Close)
+	return n, NewClient(ts.URL + "/")
+}
+
+func TestClientSend(t *testing.T) {
+	n, c := newTestNode(t)
+	w, recipient := NewWallet(), NewWallet()
+
+	if nonce, err := c.Nonce(w.BlockchainAddress()); err != nil || nonce != 4 {
+		t.Fatalf("nonce %d, %v", nonce, err)
+	}
+	if balance, err := c.Balance(w.BlockchainAddress()); err != nil || balance != utils.Coin+utils.Coin/2 {
+		t.Fatalf("balance %s, %v", balance, err)
+	}
+
+	id, err := c.SendWithFee(w, recipient.BlockchainAddress(), 2*utils.Coin, 10)
+	if err != nil || id != "tx-id" {
+		t.Fatalf("send: %s, %v", id, err)
+	}
+	if len(n.submitted) != 1 {
+		t.Fatalf("node got %d transactions", len(n.submitted))
+	}
+	st := n.submitted[0]
+	if st.ChainID != "client-test" || st.Nonce != 4 || st.SenderBlockchainAddress != w.BlockchainAddress() ||
+		st.RecipientBlockchainAddress != recipient.BlockchainAddress() || st.Value != 2*utils.Coin || st.Fee != 10 {
+		t.Fatalf("submitted transaction %+v", st)
+	}
+	// The node gets the public key and a signature over the canonical
+	// fields, never the private key.
+	if !strings.EqualFold(st.SenderPublicKey, w.PublicKeyStr()) {
+		t.Fatalf("submitted public key %s, want %s", st.SenderPublicKey, w.PublicKeyStr())
+	}
+	if !st.Canonical().Verify(utils.PublicKeyFromString(st.SenderPublicKey), utils.SignatureFromString(st.Signature)) {
+		t.Fatal("submitted signature does not verify")
+	}
+	st.Value++
+	if st.Canonical().Verify(utils.PublicKeyFromString(st.SenderPublicKey), utils.SignatureFromString(st.Signature)) {
+		t.Fatal("signature verifies a changed value")
+	}
+
+	if _, err := c.Send(w, "not-an-address", utils.Coin); err == nil || len(n.submitted) != 1 {
+		t.Fatalf("sent to an invalid address: %v", err)
+	}
+	if err := c.do("GET", "/missing", nil, nil); err == nil || err.Error() != "no route" {
+		t.Fatalf("node error %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/tyler-smith/go-bip39"
+)
+
+// HD wallets derive every key from one seed, so a single mnemonic phrase
+// backs up all of them. BIP-32 is only defined for secp256k1, keys here are
+// derived with SLIP-0010, its counterpart for P-256.
+
+// HardenedOffset marks a hardened child index, written with a ' in paths.
+const HardenedOffset uint32 = 0x80000000
+
+// DefaultAccountPath is the BIP-44 receive chain of the first account, with
+// the testnet coin type. Receive addresses are its children 0, 1, 2...
+const DefaultAccountPath = "m/44'/1'/0'/0"
+
+// DefaultGapLimit is how many unused addresses in a row end a rescan.
+const DefaultGapLimit = 20
+
+// MaxGapLimit bounds the gap limit of a rescan, every address it walks is
+// derived and looked up in the chain.
+const MaxGapLimit = 100
+
+const slip10Curve = "Nist256p1 seed"
+
+var ErrInvalidMnemonic = errors.New("invalid mnemonic")
+
+// NewMnemonic returns a new random 12 word BIP-39 mnemonic.
+func NewMnemonic() (string, error) {
+	entropy, err := bip39.NewEntropy(128)
+	if err != nil {
+		return "", err
+	}
+	return bip39.NewMnemonic(entropy)
+}
+
+// HDKey is an extended private key: a key and the chain code its children
+// are derived with.
+type HDKey struct {
+	privateKey *ecdsa.PrivateKey
+	chainCode  []byte
+}
+
+// NewMasterKey returns the root key of seed.
+func NewMasterKey(seed []byte) (*HDKey, error) {
+	mac := hmac.New(sha512.New, []byte(slip10Curve))
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+	for {
+		if privateKey, err := privateKeyFromScalar(sum[:32]); err == nil {
+			return &HDKey{privateKey: privateKey, chainCode: sum[32:]}, nil
+		}
+		mac.Reset()
+		mac.Write(sum)
+		sum = mac.Sum(nil)
+	}
+}
+
+// Child derives the child key at index, hardened if index has the
+// HardenedOffset bit set.
+func (k *HDKey) Child(index uint32) (*HDKey, error) {
+	data := make([]byte, 0, 37)
+	if index >= HardenedOffset {
+		data = append(data, 0)
+		data = append(data, k.privateKey.D.FillBytes(make([]byte, 32))...)
+	} else {
+		data = append(data, elliptic.MarshalCompressed(elliptic.P256(), k.privateKey.X, k.privateKey.Y)...)
+	}
+	data = binary.BigEndian.AppendUint32(data, index)
+
+	n := elliptic.P256().Params().N
+	for {
+		mac := hmac.New(sha512.New, k.chainCode)
+		mac.Write(data)
+		sum := mac.Sum(nil)
+
+		il := new(big.Int).SetBytes(sum[:32])
+		if il.Cmp(n) < 0 {
+			d := il.Add(il, k.privateKey.D)
+			d.Mod(d, n)
+			if privateKey, err := privateKeyFromScalar(d.FillBytes(make([]byte, 32))); err == nil {
+				return &HDKey{privateKey: privateKey, chainCode: sum[32:]}, nil
+			}
+		}
+		// SLIP-0010 retries with the right half of the hash when the key
+		// is out of range, which practically never happens on P-256.
+		data = append([]byte{1}, sum[32:]...)
+		data = binary.BigEndian.AppendUint32(data, index)
+	}
+}
+
+// Derive follows path, like "m/44'/1'/0'/0", from k.
+func (k *HDKey) Derive(path string) (*HDKey, error) {
+	indexes, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, index := range indexes {
+		if k, err = k.Child(index); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+func (k *HDKey) PrivateKey() *ecdsa.PrivateKey {
+	return k.privateKey
+}
+
+func (k *HDKey) Wallet() *Wallet {
+	return NewWalletFromPrivateKey(k.privateKey)
+}
+
+// ParsePath reads a derivation path into child indexes. Hardened indexes
+// are marked with ' or h.
+func ParsePath(path string) ([]uint32, error) {
+	parts := strings.Split(strings.TrimSpace(path), "/")
+	if parts[0] != "m" {
+		return nil, fmt.Errorf("invalid derivation path %q", path)
+	}
+	indexes := make([]uint32, 0, len(parts)-1)
+	for _, p := range parts[1:] {
+		offset := uint32(0)
+		if s, ok := strings.CutSuffix(p, "'"); ok {
+			p, offset = s, HardenedOffset
+		} else if s, ok := strings.CutSuffix(p, "h"); ok {
+			p, offset = s, HardenedOffset
+		}
+		i, err := strconv.ParseUint(p, 10, 32)
+		if err != nil || uint32(i) >= HardenedOffset {
+			return nil, fmt.Errorf("invalid derivation path %q", path)
+		}
+		indexes = append(indexes, uint32(i)+offset)
+	}
+	return indexes, nil
+}
+
+// HDWallet derives the receive addresses of one account from a mnemonic.
+type HDWallet struct {
+	path    string
+	account *HDKey
+}
+
+// NewHDWallet restores the account at path of mnemonic, DefaultAccountPath
+// when path is empty.
+func NewHDWallet(mnemonic, path string) (*HDWallet, error) {
+	if path == "" {
+		path = DefaultAccountPath
+	}
+	seed, err := bip39.NewSeedWithErrorChecking(normalizeMnemonic(mnemonic), "")
+	if err != nil {
+		return nil, ErrInvalidMnemonic
+	}
+	master, err := NewMasterKey(seed)
+	if err != nil {
+		return nil, err
+	}
+	account, err := master.Derive(path)
+	if err != nil {
+		return nil, err
+	}
+	return &HDWallet{path: path, account: account}, nil
+}
+
+func (hw *HDWallet) Path() string {
+	return hw.path
+}
+
+// Wallet returns the receive wallet at index, path/index.
+func (hw *HDWallet) Wallet(index uint32) (*Wallet, error) {
+	k, err := hw.account.Child(index)
+	if err != nil {
+		return nil, err
+	}
+	return k.Wallet(), nil
+}
+
+func normalizeMnemonic(mnemonic string) string {
+	return strings.Join(strings.Fields(strings.ToLower(mnemonic)), " ")
+}
@@ -0,0 +1,146 @@
+package wallet
+
+import (
+	"crypto/elliptic"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// The nist256p1 test vectors of SLIP-0010.
+func TestSLIP10Vectors(t *testing.T) {
+	for _, v := range []struct {
+		seed  string
+		path  string
+		chain string
+		key   string
+	}{
+		{"000102030405060708090a0b0c0d0e0f", "m", "beeb672fe4621673f722f38529c07392fecaa61015c80c34f29ce8b41b3cb6ea", "612091aaa12e22dd2abef664f8a01a82cae99ad7441b7ef8110424915c268bc2"},
+		{"000102030405060708090a0b0c0d0e0f", "m/0'", "3460cea53e6a6bb5fb391eeef3237ffd8724bf0a40e94943c98b83825342ee11", "6939694369114c67917a182c59ddb8cafc3004e63ca5d3b84403ba8613debc0c"},
+		{"000102030405060708090a0b0c0d0e0f", "m/0'/1", "4187afff1aafa8445010097fb99d23aee9f599450c7bd140b6826ac22ba21d0c", "284e9d38d07d21e4e281b645089a94f4cf5a5a81369acf151a1c3a57f18b2129"},
+		{"000102030405060708090a0b0c0d0e0f", "m/0'/1/2'", "98c7514f562e64e74170cc3cf304ee1ce54d6b6da4f880f313e8204c2a185318", "694596e8a54f252c960eb771a3c41e7e32496d03b954aeb90f61635b8e092aa7"},
+		{"000102030405060708090a0b0c0d0e0f", "m/0'/1/2'/2", "ba96f776a5c3907d7fd48bde5620ee374d4acfd540378476019eab70790c63a0", "5996c37fd3dd2679039b23ed6f70b506c6b56b3cb5e424681fb0fa64caf82aaa"},
+		{"000102030405060708090a0b0c0d0e0f", "m/0'/1/2'/2/1000000000", "b9b7b82d326bb9cb5b5b121066feea4eb93d5241103c9e7a18aad40f1dde8059", "21c4f269ef0a5fd1badf47eeacebeeaa3de22eb8e5b0adcd0f27dd99d34d0119"},
+		// A child derivation and a master key that need a retry.
+		{"000102030405060708090a0b0c0d0e0f", "m/28578'", "e94c8ebe30c2250a14713212f6449b20f3329105ea15b652ca5bdfc68f6c65c2", "06f0db126f023755d0b8d86d4591718a5210dd8d024e3e14b6159d63f53aa669"},
+		{"000102030405060708090a0b0c0d0e0f", "m/28578'/33941", "9e87fe95031f14736774cd82f25fd885065cb7c358c1edf813c72af535e83071", "092154eed4af83e078ff9b84322015aefe5769e31270f62c3f66c33888335f3a"},
+		{"a7305bc8df8d0951f0cb224c0e95d7707cbdf2c6ce7e8d481fec69c7ff5e9446", "m", "7762f9729fed06121fd13f326884c82f59aa95c57ac492ce8c9654e60efd130c", "3b8c18469a4634517d6d0b65448f8e6c62091b45540a1743c5846be55d47d88f"},
+	} {
+		seed, _ := hex.DecodeString(v.seed)
+		master, err := NewMasterKey(seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, err := master.Derive(v.path)
+		if err != nil {
+			t.Fatalf("%s: %s", v.path, err)
+		}
+		if chain := hex.EncodeToString(k.chainCode); chain != v.chain {
+			t.Errorf("%s %s: chain code %s, want %s", v.seed, v.path, chain, v.chain)
+		}
+		if key := hex.EncodeToString(k.PrivateKey().D.FillBytes(make([]byte, 32))); key != v.key {
+			t.Errorf("%s %s: private key %s, want %s", v.seed, v.path, key, v.key)
+		}
+	}
+
+	// Derive is Child after Child, public keys come with the private ones.
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	master, _ := NewMasterKey(seed)
+	k, _ := master.Child(HardenedOffset)
+	if k, _ = k.Child(1); hex.EncodeToString(elliptic.MarshalCompressed(elliptic.P256(), k.PrivateKey().X, k.PrivateKey().Y)) != "03526c63f8d0b4bbbf9c80df553fe66742df4676b241dabefdef67733e070f6844" {
+		t.Fatal("m/0'/1 has the wrong public key")
+	}
+}
+
+// The seed of the BIP-39 mnemonic of all abandon and about, without a
+// passphrase, is the root of the account wallets.
+func TestHDWallet(t *testing.T) {
+	const mnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+	seed, _ := hex.DecodeString("5eb00bbddcf069084889a8ab9155568165f5c453ccb85e70811aaed6f6da5fc19a5ac40b389cd370d086206dec8aa6c43daea6690f20ad3d8d48b2d2ce9e38e4")
+	master, err := NewMasterKey(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := master.Derive(DefaultAccountPath + "/3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hw, err := NewHDWallet("  Abandon abandon ABANDON abandon abandon abandon abandon abandon abandon abandon abandon  about ", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := hw.Wallet(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hw.Path() != DefaultAccountPath || w.BlockchainAddress() != k.Wallet().BlockchainAddress() {
+		t.Fatalf("wallet 3 of %s is %s, want %s", hw.Path(), w.BlockchainAddress(), k.Wallet().BlockchainAddress())
+	}
+	other, _ := NewHDWallet(mnemonic, "m/44'/1'/1'/0")
+	if w2, _ := other.Wallet(3); w2.BlockchainAddress() == w.BlockchainAddress() {
+		t.Fatal("two accounts derived the same wallet")
+	}
+
+	for _, m := range []string{
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon",
+		"abandon abandon abandon",
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon notaword",
+		"",
+	} {
+		if _, err := NewHDWallet(m, ""); !errors.Is(err, ErrInvalidMnemonic) {
+			t.Errorf("NewHDWallet(%q) = %v, want ErrInvalidMnemonic", m, err)
+		}
+	}
+	if _, err := NewHDWallet(mnemonic, "44'/1'"); err == nil {
+		t.Fatal("restored a wallet at an invalid path")
+	}
+
+	generated, err := NewMnemonic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewHDWallet(generated, ""); err != nil {
+		t.Fatalf("new mnemonic %q: %s", generated, err)
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	for _, tt := range []struct {
+		path string
+		want []uint32
+	}{
+		{"m", []uint32{}},
+		{" m/44'/1h/0'/0 ", []uint32{44 + HardenedOffset, 1 + HardenedOffset, HardenedOffset, 0}},
+		{"m/2147483647'", []uint32{0xffffffff}},
+		{"m/2147483647", []uint32{0x7fffffff}},
+	} {
+		if got, err := ParsePath(tt.path); err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("ParsePath(%q) = %v, %v; want %v", tt.path, got, err, tt.want)
+		}
+	}
+
+	for _, path := range []string{
+		"",
+		"M/0",
+		"44'/0",
+		"m/",
+		"m//0",
+		"m/0/",
+		"m/-1",
+		"m/+1",
+		"m/x",
+		"m/0''",
+		"m/0'h",
+		"m/0H",
+		"m/2147483648",
+		"m/2147483648'",
+		"m/4294967296",
+		"m/0 /1",
+	} {
+		if _, err := ParsePath(path); err == nil {
+			t.Errorf("ParsePath(%q) accepted an invalid path", path)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package wallet
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/EmilioCliff/learn-go/blockchain/address"
+)
+
+// HDFile is how a Keystore keeps an HD wallet: its mnemonic, encrypted like
+// a key file with the wallet ID as additional data, and the receive
+// addresses derived so far, Addresses[i] being the child at index i. Every
+// derived key is stored as a key file too, so it unlocks and signs like any
+// other wallet.
+type HDFile struct {
+	Version   int       `json:"version"`
+	ID        string    `json:"id"`
+	Path      string    `json:"path"`
+	Addresses []string  `json:"addresses"`
+	Crypto    KeyCrypto `json:"crypto"`
+}
+
+// HDWalletInfo is the public part of an HD wallet.
+type HDWalletInfo struct {
+	ID        string   `json:"id"`
+	Path      string   `json:"path"`
+	NextIndex int      `json:"next_index"`
+	Addresses []string `json:"addresses"`
+}
+
+func (hf *HDFile) Info() *HDWalletInfo {
+	return &HDWalletInfo{
+		ID:        hf.ID,
+		Path:      hf.Path,
+		NextIndex: len(hf.Addresses),
+		Addresses: append([]string{}, hf.Addresses...),
+	}
+}
+
+func (ks *Keystore) loadHD() error {
+	paths, err := filepath.Glob(filepath.Join(ks.dir, "hd", "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		var hf HDFile
+		if err := json.Unmarshal(data, &hf); err != nil {
+			return fmt.Errorf("read HD wallet file %s: %w", p, err)
+		}
+		if err := address.Validate(hf.ID); err != nil {
+			log.Printf("WARN: Skip HD wallet file %s: %s, restore it from its mnemonic\n", p, err.Error())
+			continue
+		}
+		ks.hd[hf.ID] = &hf
+	}
+	return nil
+}
+
+// CreateHD generates a new mnemonic and stores the HD wallet of the account
+// at path under passphrase. The mnemonic is only ever returned here, it is
+// the backup of every key the wallet derives.
+func (ks *Keystore) CreateHD(passphrase, path string) (*HDWalletInfo, string, error) {
+	mnemonic, err := NewMnemonic()
+	if err != nil {
+		return nil, "", err
+	}
+	info, err := ks.RestoreHD(mnemonic, passphrase, path)
+	return info, mnemonic, err
+}
+
+// RestoreHD stores the HD wallet of mnemonic under passphrase with its first
+// receive address. Its ID is that address. Rescan finds the addresses that
+// were used before.
+func (ks *Keystore) RestoreHD(mnemonic, passphrase, path string) (*HDWalletInfo, error) {
+	hw, err := NewHDWallet(mnemonic, path)
+	if err != nil {
+		return nil, err
+	}
+	first, err := hw.Wallet(0)
+	if err != nil {
+		return nil, err
+	}
+
+	ks.hdMux.Lock()
+	defer ks.hdMux.Unlock()
+	if _, ok := ks.HDWallet(first.BlockchainAddress()); ok {
+		return nil, ErrWalletExists
+	}
+	kc, err := seal([]byte(normalizeMnemonic(mnemonic)), []byte(first.BlockchainAddress()), passphrase, ks.iterations)
+	if err != nil {
+		return nil, err
+	}
+	hf := &HDFile{Version: 1, ID: first.BlockchainAddress(), Path: hw.Path(), Crypto: *kc}
+	if err := ks.deriveTo(hf, hw, passphrase, 1); err != nil {
+		return nil, err
+	}
+	return hf.Info(), nil
+}
+
+// DeriveNext derives and stores the next receive wallet of HD wallet id.
+func (ks *Keystore) DeriveNext(id, passphrase string) (*Wallet, error) {
+	ks.hdMux.Lock()
+	defer ks.hdMux.Unlock()
+	hf, hw, err := ks.unlockHD(id, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if err := ks.deriveTo(hf, hw, passphrase, len(hf.Addresses)+1); err != nil {
+		return nil, err
+	}
+	return hw.Wallet(uint32(len(hf.Addresses) - 1))
+}
+
+// Rescan walks the receive addresses of HD wallet id until gapLimit unused
+// ones in a row, DefaultGapLimit when it is not positive, and stores every
+// address up to the last one used reports as used. The gap limit is at most
+// MaxGapLimit.
+func (ks *Keystore) Rescan(id, passphrase string, gapLimit int, used func(blockchainAddress string) bool) (*HDWalletInfo, error) {
+	if gapLimit > MaxGapLimit {
+		return nil, fmt.Errorf("gap limit %d is above %d", gapLimit, MaxGapLimit)
+	}
+	if gapLimit <= 0 {
+		gapLimit = DefaultGapLimit
+	}
+	ks.hdMux.Lock()
+	defer ks.hdMux.Unlock()
+	hf, hw, err := ks.unlockHD(id, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	next := len(hf.Addresses)
+	for index, gap := 0, 0; gap < gapLimit; index++ {
+		var address string
+		if index < len(hf.Addresses) {
+			address = hf.Addresses[index]
+		} else {
+			w, err := hw.Wallet(uint32(index))
+			if err != nil {
+				return nil, err
+			}
+			address = w.BlockchainAddress()
+		}
+		if used(address) {
+			next, gap = max(next, index+1), 0
+		} else {
+			gap++
+		}
+	}
+	if err := ks.deriveTo(hf, hw, passphrase, next); err != nil {
+		return nil, err
+	}
+	return hf.Info(), nil
+}
+
+// HDWallet returns a copy of the HD wallet file of id.
+func (ks *Keystore) HDWallet(id string) (*HDFile, bool) {
+	ks.mux.RLock()
+	defer ks.mux.RUnlock()
+	hf, ok := ks.hd[id]
+	if !ok {
+		return nil, false
+	}
+	c := *hf
+	c.Addresses = append([]string{}, hf.Addresses...)
+	return &c, true
+}
+
+// ListHD returns the public part of every stored HD wallet.
+func (ks *Keystore) ListHD() []*HDWalletInfo {
+	ks.mux.RLock()
+	defer ks.mux.RUnlock()
+	infos := make([]*HDWalletInfo, 0, len(ks.hd))
+	for _, hf := range ks.hd {
+		infos = append(infos, hf.Info())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+func (ks *Keystore) unlockHD(id, passphrase string) (*HDFile, *HDWallet, error) {
+	hf, ok := ks.HDWallet(id)
+	if !ok {
+		return nil, nil, ErrWalletNotFound
+	}
+	mnemonic, err := open(&hf.Crypto, []byte(hf.ID), passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+	hw, err := NewHDWallet(string(mnemonic), hf.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return hf, hw, nil
+}
+
+// deriveTo stores the receive wallets of hw up to index n-1 as key files
+// and saves hf with their addresses. The caller holds ks.hdMux.
+func (ks *Keystore) deriveTo(hf *HDFile, hw *HDWallet, passphrase string, n int) error {
+	for index := len(hf.Addresses); index < n; index++ {
+		w, err := hw.Wallet(uint32(index))
+		if err != nil {
+			return err
+		}
+		// The key may be there already, imported on its own.
+		if err := ks.Store(w, passphrase); err != nil && err != ErrWalletExists {
+			return err
+		}
+		hf.Addresses = append(hf.Addresses, w.BlockchainAddress())
+	}
+
+	ks.mux.Lock()
+	defer ks.mux.Unlock()
+	if ks.dir != "" {
+		if err := os.MkdirAll(filepath.Join(ks.dir, "hd"), 0o700); err != nil {
+			return err
+		}
+		data, _ := json.MarshalIndent(hf, "", "  ")
+		if err := os.WriteFile(filepath.Join(ks.dir, "hd", hf.ID+".json"), data, 0o600); err != nil {
+			return err
+		}
+	}
+	ks.hd[hf.ID] = hf
+	return nil
+}
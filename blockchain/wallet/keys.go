@@ -0,0 +1,102 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/btcsuite/btcutil/base58"
+)
+
+// Formats private keys are imported and exported in.
+const (
+	// KeyFormatHex is the 32 byte private scalar in hex.
+	KeyFormatHex = "hex"
+	// KeyFormatPEM is a SEC 1 "EC PRIVATE KEY" PEM block, PKCS #8 blocks
+	// are accepted on import as well.
+	KeyFormatPEM = "pem"
+	// KeyFormatWIF is the private scalar in base58check with version byte
+	// 0x80, like Bitcoin's wallet import format.
+	KeyFormatWIF = "wif"
+)
+
+const wifVersion = 0x80
+
+var ErrInvalidKey = errors.New("invalid private key")
+
+// EncodePrivateKey writes privateKey in format.
+func EncodePrivateKey(privateKey *ecdsa.PrivateKey, format string) (string, error) {
+	switch format {
+	case KeyFormatHex:
+		return fmt.Sprintf("%064x", privateKey.D), nil
+	case KeyFormatPEM:
+		der, err := x509.MarshalECPrivateKey(privateKey)
+		if err != nil {
+			return "", err
+		}
+		return string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})), nil
+	case KeyFormatWIF:
+		d := make([]byte, 32)
+		privateKey.D.FillBytes(d)
+		return base58.CheckEncode(d, wifVersion), nil
+	}
+	return "", fmt.Errorf("unknown key format %q", format)
+}
+
+// ParsePrivateKey reads a P-256 private key written in format.
+func ParsePrivateKey(s string, format string) (*ecdsa.PrivateKey, error) {
+	s = strings.TrimSpace(s)
+	switch format {
+	case KeyFormatHex:
+		if len(s) == 0 || len(s) > 64 {
+			return nil, ErrInvalidKey
+		}
+		d, err := hex.DecodeString(strings.Repeat("0", 64-len(s)) + s)
+		if err != nil {
+			return nil, ErrInvalidKey
+		}
+		return privateKeyFromScalar(d)
+	case KeyFormatPEM:
+		block, _ := pem.Decode([]byte(s))
+		if block == nil {
+			return nil, ErrInvalidKey
+		}
+		var key any
+		var err error
+		if block.Type == "EC PRIVATE KEY" {
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		} else {
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		}
+		privateKey, ok := key.(*ecdsa.PrivateKey)
+		if err != nil || !ok || privateKey.Curve != elliptic.P256() {
+			return nil, ErrInvalidKey
+		}
+		return privateKey, nil
+	case KeyFormatWIF:
+		d, version, err := base58.CheckDecode(s)
+		if err != nil || version != wifVersion || len(d) != 32 {
+			return nil, ErrInvalidKey
+		}
+		return privateKeyFromScalar(d)
+	}
+	return nil, fmt.Errorf("unknown key format %q", format)
+}
+
+func privateKeyFromScalar(d []byte) (*ecdsa.PrivateKey, error) {
+	curve := elliptic.P256()
+	k := new(big.Int).SetBytes(d)
+	if k.Sign() == 0 || k.Cmp(curve.Params().N) >= 0 {
+		return nil, ErrInvalidKey
+	}
+	privateKey := &ecdsa.PrivateKey{D: k}
+	privateKey.PublicKey.Curve = curve
+	privateKey.PublicKey.X, privateKey.PublicKey.Y = curve.ScalarBaseMult(d)
+	return privateKey, nil
+}
@@ -0,0 +1,400 @@
+package wallet
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/EmilioCliff/learn-go/blockchain/address"
+)
+
+// DefaultKDFIterations is the PBKDF2-SHA256 work factor for new key files.
+const DefaultKDFIterations = 600000
+
+// downloadLabel is what a download proof authenticates, see DownloadProof.
+const downloadLabel = "keystore download"
+
+// minerFile names the file in the keystore dir holding the address of the
+// node's miner wallet.
+const minerFile = "miner"
+
+var (
+	ErrWalletNotFound  = errors.New("wallet not found")
+	ErrWalletExists    = errors.New("wallet already exists")
+	ErrWrongPassphrase = errors.New("wrong passphrase")
+)
+
+// KeyFile is how a Keystore keeps one private key: encrypted with AES-256-GCM
+// under a key derived from the passphrase with PBKDF2-SHA256, the address
+// being the additional authenticated data. Both primitives are in the Web
+// Crypto API, so a browser can decrypt a key file it downloaded, and prove
+// it knows the passphrase to download it, see DownloadProof.
+type KeyFile struct {
+	Version           int       `json:"version"`
+	BlockchainAddress string    `json:"blockchain_address"`
+	PublicKey         string    `json:"public_key"`
+	Crypto            KeyCrypto `json:"crypto"`
+}
+
+type KeyCrypto struct {
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       string `json:"salt"`
+	Cipher     string `json:"cipher"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+	// Verifier is the SHA-256 of the hex DownloadProof of the passphrase,
+	// in hex.
+	Verifier string `json:"verifier,omitempty"`
+}
+
+// Keystore keeps private keys encrypted at rest, one file per address in
+// dir and HD wallets in dir/hd, or in memory only when dir is empty.
+type Keystore struct {
+	dir        string
+	iterations int
+
+	mux  sync.RWMutex
+	keys map[string]*KeyFile
+	hd   map[string]*HDFile
+	// hdMux serializes the derivations of HD wallets.
+	hdMux sync.Mutex
+}
+
+// NewKeystore opens the key files in dir, creating it if needed. New keys
+// are encrypted with iterations PBKDF2 rounds, DefaultKDFIterations when it
+// is not positive.
+func NewKeystore(dir string, iterations int) (*Keystore, error) {
+	if iterations <= 0 {
+		iterations = DefaultKDFIterations
+	}
+	ks := &Keystore{dir: dir, iterations: iterations, keys: make(map[string]*KeyFile), hd: make(map[string]*HDFile)}
+	if dir == "" {
+		return ks, nil
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		var kf KeyFile
+		if err := json.Unmarshal(data, &kf); err != nil {
+			return nil, fmt.Errorf("read key file %s: %w", p, err)
+		}
+		// Keys stored before addresses got a proper checksum cannot be
+		// moved to their new address without the passphrase.
+		if err := address.Validate(kf.BlockchainAddress); err != nil {
+			log.Printf("WARN: Skip key file %s: %s, import the key again\n", p, err.Error())
+			continue
+		}
+		ks.keys[kf.BlockchainAddress] = &kf
+	}
+	if err := ks.loadHD(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// Create generates a new wallet and stores it under passphrase.
+func (ks *Keystore) Create(passphrase string) (*Wallet, error) {
+	w := NewWallet()
+	return w, ks.Store(w, passphrase)
+}
+
+// Miner unlocks the wallet the node mines to with passphrase. The first
+// time, and every time for a keystore kept in memory, it creates the wallet
+// and remembers its address.
+func (ks *Keystore) Miner(passphrase string) (*Wallet, error) {
+	if ks.dir != "" {
+		data, err := os.ReadFile(filepath.Join(ks.dir, minerFile))
+		if err == nil {
+			return ks.Unlock(strings.TrimSpace(string(data)), passphrase)
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	w, err := ks.Create(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if ks.dir != "" {
+		if err := os.WriteFile(filepath.Join(ks.dir, minerFile), []byte(w.BlockchainAddress()+"\n"), 0o600); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// Import stores the private key s, written in format, under passphrase.
+func (ks *Keystore) Import(s, format, passphrase string) (*Wallet, error) {
+	privateKey, err := ParsePrivateKey(s, format)
+	if err != nil {
+		return nil, err
+	}
+	w := NewWalletFromPrivateKey(privateKey)
+	return w, ks.Store(w, passphrase)
+}
+
+// Store encrypts the private key of w under passphrase.
+func (ks *Keystore) Store(w *Wallet, passphrase string) error {
+	kf, err := encryptKey(w, passphrase, ks.iterations)
+	if err != nil {
+		return err
+	}
+
+	ks.mux.Lock()
+	defer ks.mux.Unlock()
+	if _, ok := ks.keys[kf.BlockchainAddress]; ok {
+		return ErrWalletExists
+	}
+	if err := ks.write(kf); err != nil {
+		return err
+	}
+	ks.keys[kf.BlockchainAddress] = kf
+	return nil
+}
+
+// write saves kf to its file, if the keystore has a dir. The caller holds
+// ks.mux.
+func (ks *Keystore) write(kf *KeyFile) error {
+	if ks.dir == "" {
+		return nil
+	}
+	data, _ := json.MarshalIndent(kf, "", "  ")
+	return os.WriteFile(filepath.Join(ks.dir, kf.BlockchainAddress+".json"), data, 0o600)
+}
+
+// Unlock decrypts the wallet of blockchainAddress. A key file written
+// before download proofs gets its verifier now.
+func (ks *Keystore) Unlock(blockchainAddress, passphrase string) (*Wallet, error) {
+	kf, ok := ks.KeyFile(blockchainAddress)
+	if !ok {
+		return nil, ErrWalletNotFound
+	}
+	w, err := decryptKey(kf, passphrase)
+	if err != nil || kf.Crypto.Verifier != "" {
+		return w, err
+	}
+	if err := ks.addVerifier(kf, passphrase); err != nil {
+		log.Printf("WARN: Add a download verifier to key file %s: %s\n", blockchainAddress, err.Error())
+	}
+	return w, nil
+}
+
+// addVerifier replaces kf with a copy carrying the verifier of passphrase.
+func (ks *Keystore) addVerifier(kf *KeyFile, passphrase string) error {
+	proof, err := DownloadProof(&kf.Crypto, passphrase)
+	if err != nil {
+		return err
+	}
+	verified := *kf
+	verified.Crypto.Verifier = downloadVerifier(proof)
+
+	ks.mux.Lock()
+	defer ks.mux.Unlock()
+	if err := ks.write(&verified); err != nil {
+		return err
+	}
+	ks.keys[kf.BlockchainAddress] = &verified
+	return nil
+}
+
+// Download returns the key file of blockchainAddress to whoever sends the
+// DownloadProof of its passphrase. Key files without a verifier cannot be
+// downloaded until they are unlocked once.
+func (ks *Keystore) Download(blockchainAddress, proof string) (*KeyFile, error) {
+	kf, ok := ks.KeyFile(blockchainAddress)
+	if !ok {
+		return nil, ErrWalletNotFound
+	}
+	if kf.Crypto.Verifier == "" {
+		return nil, errors.New("key file has no download verifier yet")
+	}
+	verifier := downloadVerifier(strings.ToLower(proof))
+	if subtle.ConstantTimeCompare([]byte(verifier), []byte(kf.Crypto.Verifier)) != 1 {
+		return nil, ErrWrongPassphrase
+	}
+	return kf, nil
+}
+
+// DownloadProof proves knowledge of passphrase to a Keystore without
+// sending it: the HMAC-SHA256 of "keystore download" under the key
+// passphrase derives with the KDF, iterations and salt of kc, in hex. The
+// key file only keeps the SHA-256 of the proof.
+func DownloadProof(kc *KeyCrypto, passphrase string) (string, error) {
+	if kc.KDF != "pbkdf2-sha256" {
+		return "", fmt.Errorf("unsupported key derivation %s", kc.KDF)
+	}
+	salt, err := hex.DecodeString(kc.Salt)
+	if err != nil {
+		return "", fmt.Errorf("corrupted key file: %w", err)
+	}
+	key, err := deriveKey(passphrase, salt, kc.Iterations)
+	if err != nil {
+		return "", err
+	}
+	return downloadProof(key), nil
+}
+
+// Export unlocks the wallet of blockchainAddress and writes its private key
+// in format.
+func (ks *Keystore) Export(blockchainAddress, passphrase, format string) (string, error) {
+	w, err := ks.Unlock(blockchainAddress, passphrase)
+	if err != nil {
+		return "", err
+	}
+	return EncodePrivateKey(w.PrivateKey(), format)
+}
+
+// KeyFile returns the encrypted key file of blockchainAddress.
+func (ks *Keystore) KeyFile(blockchainAddress string) (*KeyFile, bool) {
+	ks.mux.RLock()
+	defer ks.mux.RUnlock()
+	kf, ok := ks.keys[blockchainAddress]
+	return kf, ok
+}
+
+// List returns the public part of every stored wallet.
+func (ks *Keystore) List() []*WalletInfo {
+	ks.mux.RLock()
+	defer ks.mux.RUnlock()
+	infos := make([]*WalletInfo, 0, len(ks.keys))
+	for _, kf := range ks.keys {
+		infos = append(infos, &WalletInfo{PublicKey: kf.PublicKey, BlockchainAddress: kf.BlockchainAddress})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].BlockchainAddress < infos[j].BlockchainAddress })
+	return infos
+}
+
+func encryptKey(w *Wallet, passphrase string, iterations int) (*KeyFile, error) {
+	d := make([]byte, 32)
+	w.privateKey.D.FillBytes(d)
+	kc, err := seal(d, []byte(w.BlockchainAddress()), passphrase, iterations)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyFile{
+		Version:           1,
+		BlockchainAddress: w.BlockchainAddress(),
+		PublicKey:         w.PublicKeyStr(),
+		Crypto:            *kc,
+	}, nil
+}
+
+func decryptKey(kf *KeyFile, passphrase string) (*Wallet, error) {
+	d, err := open(&kf.Crypto, []byte(kf.BlockchainAddress), passphrase)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := privateKeyFromScalar(d)
+	if err != nil {
+		return nil, err
+	}
+	w := NewWalletFromPrivateKey(privateKey)
+	if !strings.EqualFold(w.PublicKeyStr(), kf.PublicKey) {
+		return nil, errors.New("corrupted key file: public key mismatch")
+	}
+	return w, nil
+}
+
+// seal encrypts plaintext under passphrase, binding it to aad.
+func seal(plaintext, aad []byte, passphrase string, iterations int) (*KeyCrypto, error) {
+	salt := make([]byte, 16)
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	key, err := deriveKey(passphrase, salt, iterations)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := keyCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyCrypto{
+		KDF:        "pbkdf2-sha256",
+		Iterations: iterations,
+		Salt:       hex.EncodeToString(salt),
+		Cipher:     "aes-256-gcm",
+		Nonce:      hex.EncodeToString(nonce),
+		Ciphertext: hex.EncodeToString(aead.Seal(nil, nonce, plaintext, aad)),
+		Verifier:   downloadVerifier(downloadProof(key)),
+	}, nil
+}
+
+func open(kc *KeyCrypto, aad []byte, passphrase string) ([]byte, error) {
+	if kc.KDF != "pbkdf2-sha256" || kc.Cipher != "aes-256-gcm" {
+		return nil, fmt.Errorf("unsupported key file %s/%s", kc.KDF, kc.Cipher)
+	}
+	salt, err1 := hex.DecodeString(kc.Salt)
+	nonce, err2 := hex.DecodeString(kc.Nonce)
+	ciphertext, err3 := hex.DecodeString(kc.Ciphertext)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return nil, fmt.Errorf("corrupted key file: %w", err)
+	}
+	key, err := deriveKey(passphrase, salt, kc.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := keyCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, errors.New("corrupted key file: bad nonce")
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return plaintext, nil
+}
+
+func deriveKey(passphrase string, salt []byte, iterations int) ([]byte, error) {
+	return pbkdf2.Key(sha256.New, passphrase, salt, iterations, 32)
+}
+
+func keyCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func downloadProof(key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(downloadLabel))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func downloadVerifier(proof string) string {
+	sum := sha256.Sum256([]byte(proof))
+	return hex.EncodeToString(sum[:])
+}
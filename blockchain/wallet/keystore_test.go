@@ -0,0 +1,222 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testIterations keeps key derivation fast, the work factor does not
+// change what is tested.
+const testIterations = 1000
+
+func TestKeystore(t *testing.T) {
+	dir := t.TempDir()
+	ks, err := NewKeystore(dir, testIterations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := ks.Create("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kf, ok := ks.KeyFile(w.BlockchainAddress())
+	if !ok || kf.Crypto.Iterations != testIterations || kf.PublicKey != w.PublicKeyStr() {
+		t.Fatalf("key file %+v", kf)
+	}
+	if strings.Contains(kf.Crypto.Ciphertext, w.PrivateKeyStr()) {
+		t.Fatal("key file holds the private key in the clear")
+	}
+
+	unlocked, err := ks.Unlock(w.BlockchainAddress(), "correct horse")
+	if err != nil || unlocked.PrivateKeyStr() != w.PrivateKeyStr() {
+		t.Fatalf("unlocked another key: %v", err)
+	}
+	if _, err := ks.Unlock(w.BlockchainAddress(), "wrong horse"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("unlock with a wrong passphrase: %v", err)
+	}
+	if _, err := ks.Unlock(NewWallet().BlockchainAddress(), "correct horse"); !errors.Is(err, ErrWalletNotFound) {
+		t.Fatalf("unlock of an unknown wallet: %v", err)
+	}
+	if err := ks.Store(w, "another"); !errors.Is(err, ErrWalletExists) {
+		t.Fatalf("stored a wallet twice: %v", err)
+	}
+
+	// The key file is bound to its address: moved to another one it does
+	// not decrypt.
+	moved := *kf
+	moved.BlockchainAddress = NewWallet().BlockchainAddress()
+	if _, err := decryptKey(&moved, "correct horse"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("decrypted a key file under another address: %v", err)
+	}
+
+	// A reopened keystore reads the files back.
+	reopened, err := NewKeystore(dir, testIterations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list := reopened.List(); len(list) != 1 || list[0].BlockchainAddress != w.BlockchainAddress() {
+		t.Fatalf("reopened keystore lists %v", list)
+	}
+	if unlocked, err := reopened.Unlock(w.BlockchainAddress(), "correct horse"); err != nil || unlocked.BlockchainAddress() != w.BlockchainAddress() {
+		t.Fatalf("unlock after reopening: %v", err)
+	}
+}
+
+func TestKeystoreMiner(t *testing.T) {
+	dir := t.TempDir()
+	ks, _ := NewKeystore(dir, testIterations)
+	miner, err := ks.Miner("miner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened, _ := NewKeystore(dir, testIterations)
+	again, err := reopened.Miner("miner")
+	if err != nil || again.BlockchainAddress() != miner.BlockchainAddress() {
+		t.Fatalf("miner wallet after a restart %v, %v", again, err)
+	}
+	if _, err := reopened.Miner("not the miner"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("miner unlocked with a wrong passphrase: %v", err)
+	}
+}
+
+func TestKeystoreDownload(t *testing.T) {
+	dir := t.TempDir()
+	ks, _ := NewKeystore(dir, testIterations)
+	w, _ := ks.Create("correct horse")
+	kf, _ := ks.KeyFile(w.BlockchainAddress())
+
+	proof, err := DownloadProof(&kf.Crypto, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if downloaded, err := ks.Download(w.BlockchainAddress(), strings.ToUpper(proof)); err != nil || downloaded != kf {
+		t.Fatalf("download with the proof: %v", err)
+	}
+	wrong, _ := DownloadProof(&kf.Crypto, "wrong horse")
+	if _, err := ks.Download(w.BlockchainAddress(), wrong); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("download with a wrong proof: %v", err)
+	}
+	// The verifier on file is no proof itself.
+	if _, err := ks.Download(w.BlockchainAddress(), kf.Crypto.Verifier); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("download with the verifier: %v", err)
+	}
+	if _, err := ks.Download(NewWallet().BlockchainAddress(), proof); !errors.Is(err, ErrWalletNotFound) {
+		t.Fatalf("download of an unknown wallet: %v", err)
+	}
+
+	// A key file written before download proofs gets its verifier the
+	// first time it is unlocked.
+	legacy, _ := ks.Create("legacy")
+	path := filepath.Join(dir, legacy.BlockchainAddress()+".json")
+	var lf KeyFile
+	data, _ := os.ReadFile(path)
+	json.Unmarshal(data, &lf)
+	lf.Crypto.Verifier = ""
+	data, _ = json.Marshal(&lf)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ks, _ = NewKeystore(dir, testIterations)
+	proof, _ = DownloadProof(&lf.Crypto, "legacy")
+	if _, err := ks.Download(legacy.BlockchainAddress(), proof); err == nil {
+		t.Fatal("downloaded a key file without a verifier")
+	}
+	if _, err := ks.Unlock(legacy.BlockchainAddress(), "legacy"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Download(legacy.BlockchainAddress(), proof); err != nil {
+		t.Fatalf("download after unlocking: %v", err)
+	}
+	reopened, _ := NewKeystore(dir, testIterations)
+	if _, err := reopened.Download(legacy.BlockchainAddress(), proof); err != nil {
+		t.Fatalf("verifier not saved: %v", err)
+	}
+}
+
+func TestKeyFormats(t *testing.T) {
+	w := NewWallet()
+	for _, format := range []string{KeyFormatHex, KeyFormatPEM, KeyFormatWIF} {
+		s, err := EncodePrivateKey(w.PrivateKey(), format)
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		privateKey, err := ParsePrivateKey("\n"+s+"\n", format)
+		if err != nil || NewWalletFromPrivateKey(privateKey).BlockchainAddress() != w.BlockchainAddress() {
+			t.Fatalf("%s round trip: %v", format, err)
+		}
+	}
+	if s, _ := EncodePrivateKey(w.PrivateKey(), KeyFormatWIF); !strings.HasPrefix(s, "5") {
+		t.Fatalf("WIF key %s does not start with 5", s)
+	}
+
+	// Hex keys may drop their leading zeros, PEM keys may be PKCS #8.
+	if k, err := ParsePrivateKey("1", KeyFormatHex); err != nil || k.D.Int64() != 1 {
+		t.Fatalf("short hex key: %v", err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(w.PrivateKey())
+	pkcs8 := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if k, err := ParsePrivateKey(pkcs8, KeyFormatPEM); err != nil || k.D.Cmp(w.PrivateKey().D) != 0 {
+		t.Fatalf("PKCS #8 key: %v", err)
+	}
+
+	other, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	der, _ = x509.MarshalECPrivateKey(other)
+	p384 := string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	wif, _ := EncodePrivateKey(w.PrivateKey(), KeyFormatWIF)
+	for _, tt := range []struct {
+		s, format string
+	}{
+		{"", KeyFormatHex},
+		{"0", KeyFormatHex},
+		{"xyz", KeyFormatHex},
+		{strings.Repeat("f", 64), KeyFormatHex},
+		{strings.Repeat("1", 65), KeyFormatHex},
+		{"not pem", KeyFormatPEM},
+		{p384, KeyFormatPEM},
+		{wif[:len(wif)-1] + "x", KeyFormatWIF},
+		{w.BlockchainAddress(), KeyFormatWIF},
+	} {
+		if _, err := ParsePrivateKey(tt.s, tt.format); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("ParsePrivateKey(%q, %s) = %v, want ErrInvalidKey", tt.s, tt.format, err)
+		}
+	}
+	if _, err := ParsePrivateKey("1", "der"); err == nil {
+		t.Fatal("parsed an unknown format")
+	}
+	if _, err := EncodePrivateKey(w.PrivateKey(), "der"); err == nil {
+		t.Fatal("encoded an unknown format")
+	}
+}
+
+func TestKeystoreImportExport(t *testing.T) {
+	ks, _ := NewKeystore("", testIterations)
+	w := NewWallet()
+	pemKey, _ := EncodePrivateKey(w.PrivateKey(), KeyFormatPEM)
+	imported, err := ks.Import(pemKey, KeyFormatPEM, "import")
+	if err != nil || imported.BlockchainAddress() != w.BlockchainAddress() {
+		t.Fatalf("import: %v", err)
+	}
+	if _, err := ks.Import(pemKey, KeyFormatPEM, "again"); !errors.Is(err, ErrWalletExists) {
+		t.Fatalf("imported a key twice: %v", err)
+	}
+	if _, err := ks.Import("zz", KeyFormatHex, "import"); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("imported an invalid key: %v", err)
+	}
+
+	exported, err := ks.Export(w.BlockchainAddress(), "import", KeyFormatHex)
+	if err != nil || exported != w.PrivateKeyStr() {
+		t.Fatalf("export %s, %v; want %s", exported, err, w.PrivateKeyStr())
+	}
+	if _, err := ks.Export(w.BlockchainAddress(), "wrong", KeyFormatHex); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("exported with a wrong passphrase: %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/EmilioCliff/learn-go/blockchain/address"
+	"github.com/EmilioCliff/learn-go/blockchain/canonical"
+	"github.com/EmilioCliff/learn-go/blockchain/utils"
+)
+
+var ErrNotCosigner = errors.New("wallet is not a key of the multisig policy")
+
+// NewMultisigTransaction builds an unsigned transaction from the address of
+// policy, set Fee before the first signature. It is passed around the
+// holders of the policy keys, each adding their signature with CoSign, and
+// submitted once Complete.
+func NewMultisigTransaction(policy *address.Multisig, chainID, recipient string, value utils.Amount, nonce uint64) *SignedTransaction {
+	return &SignedTransaction{
+		ChainID:                    chainID,
+		SenderBlockchainAddress:    policy.Address(),
+		RecipientBlockchainAddress: recipient,
+		Value:                      value,
+		Nonce:                      nonce,
+		Timestamp:                  time.Now().Unix(),
+		Policy:                     policy,
+		Signatures:                 map[string]string{},
+	}
+}
+
+// Canonical returns the signed fields of st, see the canonical package.
+func (st *SignedTransaction) Canonical() *canonical.Transaction {
+	return &canonical.Transaction{
+		ChainID:   st.ChainID,
+		Sender:    st.SenderBlockchainAddress,
+		Recipient: st.RecipientBlockchainAddress,
+		Value:     st.Value,
+		Fee:       st.Fee,
+		Nonce:     st.Nonce,
+		Timestamp: st.Timestamp,
+
+		UnlockHeight: st.UnlockHeight,
+		UnlockTime:   st.UnlockTime,
+	}
+}
+
+// CoSign adds the signature of w to the multisig transaction st.
+func (w *Wallet) CoSign(st *SignedTransaction) error {
+	if st.Policy == nil {
+		return fmt.Errorf("not a multisig transaction")
+	}
+	if err := address.VerifyPolicy(st.SenderBlockchainAddress, st.Policy); err != nil {
+		return err
+	}
+	if !st.Policy.Has(w.PublicKeyStr()) {
+		return ErrNotCosigner
+	}
+	s, err := st.Canonical().Sign(w.privateKey)
+	if err != nil {
+		return err
+	}
+	if st.Signatures == nil {
+		st.Signatures = map[string]string{}
+	}
+	st.Signatures[w.PublicKeyStr()] = s.String()
+	return nil
+}
+
+// Complete reports whether st carries as many signatures as its policy
+// needs.
+func (st *SignedTransaction) Complete() bool {
+	return st.Policy != nil && len(st.Signatures) >= st.Policy.M
+}
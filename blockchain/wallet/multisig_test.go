@@ -0,0 +1,81 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/EmilioCliff/learn-go/blockchain/address"
+	"github.com/EmilioCliff/learn-go/blockchain/utils"
+)
+
+func TestCoSign(t *testing.T) {
+	a, b, c := NewWallet(), NewWallet(), NewWallet()
+	policy, err := address.NewMultisig(2, a.PublicKeyStr(), b.PublicKeyStr(), c.PublicKeyStr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient := NewWallet().BlockchainAddress()
+	st := NewMultisigTransaction(policy, "cosign-test", recipient, utils.Coin, 3)
+	st.Fee = 10
+	if st.SenderBlockchainAddress != policy.Address() || st.Complete() {
+		t.Fatalf("new multisig transaction %+v", st)
+	}
+
+	if err := a.CoSign(st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Complete() {
+		t.Fatal("complete with 1 of 2 signatures")
+	}
+	// Signing twice with one key does not count twice.
+	if err := a.CoSign(st); err != nil || st.Complete() {
+		t.Fatalf("complete after signing twice with one key: %v", err)
+	}
+	if err := NewWallet().CoSign(st); !errors.Is(err, ErrNotCosigner) {
+		t.Fatalf("signed by a key outside the policy: %v", err)
+	}
+	if err := c.CoSign(st); err != nil || !st.Complete() {
+		t.Fatalf("2 of 2 signatures: %v", err)
+	}
+	for _, w := range []*Wallet{a, c} {
+		s := utils.SignatureFromString(st.Signatures[w.PublicKeyStr()])
+		if !st.Canonical().Verify(w.PublicKey(), s) {
+			t.Fatalf("signature of %s does not verify", w.BlockchainAddress())
+		}
+	}
+
+	// A transaction whose policy does not match its sender is not signed.
+	forged := NewMultisigTransaction(policy, "cosign-test", recipient, utils.Coin, 3)
+	forged.SenderBlockchainAddress = a.BlockchainAddress()
+	if err := b.CoSign(forged); err == nil || len(forged.Signatures) != 0 {
+		t.Fatalf("signed a transaction from another address: %v", err)
+	}
+	single := a.NewTransaction("cosign-test", recipient, utils.Coin, 0).Sign()
+	if err := b.CoSign(single); err == nil {
+		t.Fatal("cosigned a single key transaction")
+	}
+}
+
+func TestClientMultisigTransaction(t *testing.T) {
+	n, c := newTestNode(t)
+	a, b := NewWallet(), NewWallet()
+	policy, _ := address.NewMultisig(1, a.PublicKeyStr(), b.PublicKeyStr())
+	recipient := NewWallet().BlockchainAddress()
+
+	st, err := c.NewMultisigTransaction(policy, recipient, utils.Coin)
+	if err != nil || st.ChainID != "client-test" || st.Nonce != 4 || st.SenderBlockchainAddress != policy.Address() {
+		t.Fatalf("multisig transaction %+v, %v", st, err)
+	}
+	if err := b.CoSign(st); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Submit(st); err != nil {
+		t.Fatal(err)
+	}
+	if len(n.submitted) != 1 || n.submitted[0].Policy == nil || len(n.submitted[0].Signatures) != 1 || n.submitted[0].Signature != "" {
+		t.Fatalf("node got %+v", n.submitted)
+	}
+	if _, err := c.NewMultisigTransaction(policy, "not-an-address", utils.Coin); err == nil {
+		t.Fatal("multisig transaction to an invalid address")
+	}
+}
@@ -2,44 +2,121 @@ package wallet
 
 import (
 	"crypto/ecdsa"
-	"crypto/rand"
-	"crypto/sha256"
-	"encoding/json"
+	"fmt"
 	"time"
 
+	"github.com/EmilioCliff/learn-go/blockchain/address"
+	"github.com/EmilioCliff/learn-go/blockchain/canonical"
 	"github.com/EmilioCliff/learn-go/blockchain/utils"
 )
 
 type Transaction struct {
 	senderPrivateKey           *ecdsa.PrivateKey
 	senderPublicKey            *ecdsa.PublicKey
+	chainID                    string
 	senderBlockchainAddress    string
 	recipientBlockchainAddress string
-	value                      float32
+	value                      utils.Amount
+	fee                        utils.Amount
+	nonce                      uint64
 	timestamp                  int64
+	unlockHeight               uint64
+	unlockTime                 int64
 }
 
-func NewTransaction(privateKey *ecdsa.PrivateKey, publicKey *ecdsa.PublicKey, sender, recipient string, value float32) *Transaction {
-	return &Transaction{privateKey, publicKey, sender, recipient, value, time.Now().Unix()}
+func NewTransaction(privateKey *ecdsa.PrivateKey, publicKey *ecdsa.PublicKey, chainID, sender, recipient string, value utils.Amount, nonce uint64) *Transaction {
+	return &Transaction{
+		senderPrivateKey:           privateKey,
+		senderPublicKey:            publicKey,
+		chainID:                    chainID,
+		senderBlockchainAddress:    sender,
+		recipientBlockchainAddress: recipient,
+		value:                      value,
+		nonce:                      nonce,
+		timestamp:                  time.Now().Unix(),
+	}
 }
 
-func (t *Transaction) GenerateSignature() *utils.Signature {
-	m, _ := t.MarshalJSON()
-	h := sha256.Sum256([]byte(m))
-	r, s, _ := ecdsa.Sign(rand.Reader, t.senderPrivateKey, h[:])
-	return &utils.Signature{R: r, S: s}
-}
-
-func (t *Transaction) MarshalJSON() ([]byte, error) {
-	return json.Marshal(struct {
-		Sender    string  `json:"sender_blockchain_address"`
-		Recipient string  `json:"recipient_blockchain_address"`
-		Value     float32 `json:"value"`
-		Timestamp int64   `json:"timestamp"`
-	}{
+// WithFee sets the fee paid to the miner on top of the value, the higher
+// the fee per byte the sooner a busy node mines the transaction.
+func (t *Transaction) WithFee(fee utils.Amount) *Transaction {
+	t.fee = fee
+	return t
+}
+
+// LockUntil keeps the recipient from spending the value before the block at
+// height and before unixTime, zero leaves either unset.
+func (t *Transaction) LockUntil(height uint64, unixTime int64) *Transaction {
+	t.unlockHeight = height
+	t.unlockTime = unixTime
+	return t
+}
+
+func (t *Transaction) Timestamp() int64 {
+	return t.timestamp
+}
+
+// NewTransaction builds a transaction from w to recipient on chain chainID,
+// chainID and nonce are the ones GET /address/:blockchain_address/nonce
+// reports for w.
+func (w *Wallet) NewTransaction(chainID, recipient string, value utils.Amount, nonce uint64) *Transaction {
+	return NewTransaction(w.privateKey, w.publicKey, chainID, w.blockchainAddress, recipient, value, nonce)
+}
+
+// Canonical returns the signed fields of t, see the canonical package.
+func (t *Transaction) Canonical() *canonical.Transaction {
+	return &canonical.Transaction{
+		ChainID:   t.chainID,
 		Sender:    t.senderBlockchainAddress,
 		Recipient: t.recipientBlockchainAddress,
 		Value:     t.value,
+		Fee:       t.fee,
+		Nonce:     t.nonce,
 		Timestamp: t.timestamp,
-	})
+
+		UnlockHeight: t.unlockHeight,
+		UnlockTime:   t.unlockTime,
+	}
+}
+
+func (t *Transaction) GenerateSignature() *utils.Signature {
+	s, _ := t.Canonical().Sign(t.senderPrivateKey)
+	return s
+}
+
+// SignedTransaction is the body of POST /transactions: the transaction
+// fields, the sender's public key and the signature over them. The private
+// key never leaves the wallet. Transactions from a multisig address carry
+// the policy and the signatures of its keys instead, see CoSign.
+type SignedTransaction struct {
+	ChainID                    string            `json:"chain_id"`
+	SenderBlockchainAddress    string            `json:"sender_blockchain_address"`
+	RecipientBlockchainAddress string            `json:"recipient_blockchain_address"`
+	SenderPublicKey            string            `json:"sender_public_key,omitempty"`
+	Value                      utils.Amount      `json:"value"`
+	Fee                        utils.Amount      `json:"fee"`
+	Nonce                      uint64            `json:"nonce"`
+	Timestamp                  int64             `json:"timestamp"`
+	UnlockHeight               uint64            `json:"unlock_height,omitempty"`
+	UnlockTime                 int64             `json:"unlock_time,omitempty"`
+	Signature                  string            `json:"signature,omitempty"`
+	Policy                     *address.Multisig `json:"policy,omitempty"`
+	Signatures                 map[string]string `json:"signatures,omitempty"`
+}
+
+// Sign signs t with the sender's private key.
+func (t *Transaction) Sign() *SignedTransaction {
+	return &SignedTransaction{
+		ChainID:                    t.chainID,
+		SenderBlockchainAddress:    t.senderBlockchainAddress,
+		RecipientBlockchainAddress: t.recipientBlockchainAddress,
+		SenderPublicKey:            fmt.Sprintf("%064x%064x", t.senderPublicKey.X.Bytes(), t.senderPublicKey.Y.Bytes()),
+		Value:                      t.value,
+		Fee:                        t.fee,
+		Nonce:                      t.nonce,
+		Timestamp:                  t.timestamp,
+		UnlockHeight:               t.unlockHeight,
+		UnlockTime:                 t.unlockTime,
+		Signature:                  t.GenerateSignature().String(),
+	}
 }
@@ -4,12 +4,10 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
-	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 
-	"github.com/btcsuite/btcutil/base58"
-	"golang.org/x/crypto/ripemd160"
+	"github.com/EmilioCliff/learn-go/blockchain/address"
 )
 
 type Wallet struct {
@@ -19,63 +17,23 @@ type Wallet struct {
 }
 
 func NewWallet() *Wallet {
-	w := new(Wallet)
 	// Generate ECDA private and public key.
 	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	return NewWalletFromPrivateKey(privateKey)
+}
+
+// NewWalletFromPrivateKey rebuilds the wallet of an existing key, for
+// instance one unlocked from a Keystore or imported with ParsePrivateKey.
+func NewWalletFromPrivateKey(privateKey *ecdsa.PrivateKey) *Wallet {
+	w := new(Wallet)
 	w.privateKey = privateKey
 	w.publicKey = &w.privateKey.PublicKey
-
-	// Perform SHA-256 on public key (32 bytes).
-	h2 := sha256.New()
-	h2.Write(w.privateKey.X.Bytes())
-	h2.Write(w.privateKey.Y.Bytes())
-	digest2 := h2.Sum(nil)
-
-	// Perform RIPEMD-160 hashing on the resurt of SHA-256 (20 bytes).
-	h3 := ripemd160.New()
-	h3.Write(digest2)
-	digest3 := h3.Sum(nil)
-
-	// Add version byte in front of RIPEMD-160 hash (0x00 for Main Network) - 21 bytes.
-	vd4 := make([]byte, 21)
-	vd4[0] = 0x00
-	copy(vd4[1:], digest3[:])
-
-	// Perform SHA-256 on the extended RIPEMD-160 result.
-	h5 := sha256.New()
-	h5.Write(vd4)
-	digest5 := h5.Sum(nil)
-
-	// Perform SHA-256 hash on the result of the previous SHA-256 hash.
-	h6 := sha256.New()
-	h6.Write(digest5)
-	digest6 := h5.Sum(nil)
-
-	// Take the first 4 bytes of the second SHA-256 hash for checksum.
-	chsum := digest6[:4]
-
-	// Add the 4 checksum bytes at the end of the extended RIPEMD-160 hash (25 bytes).
-	dc8 := make([]byte, 25)
-	copy(dc8[:21], vd4[:])
-	copy(dc8[21:], chsum[:])
-
-	// Covert the result from a byte string into base58.
-	address := base58.Encode(dc8)
-	w.blockchainAddress = address
-
+	w.blockchainAddress = address.FromPublicKey(w.publicKey)
 	return w
 }
 
 func (w *Wallet) MarshalJSON() ([]byte, error) {
-	return json.Marshal(struct {
-		PrivateKey        string `json:"private_key"`
-		PublicKey         string `json:"public_key"`
-		BlockchainAddress string `json:"blockchain_address"`
-	}{
-		PrivateKey:        w.PrivateKeyStr(),
-		PublicKey:         w.PublicKeyStr(),
-		BlockchainAddress: w.BlockchainAddress(),
-	})
+	return json.Marshal(w.Info())
 }
 
 func (w *Wallet) PrivateKey() *ecdsa.PrivateKey {
@@ -83,7 +41,7 @@ func (w *Wallet) PrivateKey() *ecdsa.PrivateKey {
 }
 
 func (w *Wallet) PrivateKeyStr() string {
-	return fmt.Sprintf("%x", w.privateKey.D.Bytes())
+	return fmt.Sprintf("%064x", w.privateKey.D)
 }
 
 func (w *Wallet) PublicKey() *ecdsa.PublicKey {
@@ -97,3 +55,14 @@ func (w *Wallet) PublicKeyStr() string {
 func (w *Wallet) BlockchainAddress() string {
 	return w.blockchainAddress
 }
+
+// WalletInfo is the public part of a wallet, the only part that is ever
+// sent over the API.
+type WalletInfo struct {
+	PublicKey         string `json:"public_key"`
+	BlockchainAddress string `json:"blockchain_address"`
+}
+
+func (w *Wallet) Info() *WalletInfo {
+	return &WalletInfo{PublicKey: w.PublicKeyStr(), BlockchainAddress: w.BlockchainAddress()}
+}